@@ -2,10 +2,17 @@ package cmd
 
 import (
 	"fmt"
+	"path/filepath"
+	"strconv"
 	"strings"
 
+	"reddock/pkg/bundle"
 	"reddock/pkg/config"
 	"reddock/pkg/container"
+	"reddock/pkg/image"
+	"reddock/pkg/logfile"
+	"reddock/pkg/sysinfo"
+	"reddock/pkg/ui"
 	"reddock/pkg/utils"
 )
 
@@ -46,14 +53,46 @@ func (c *Command) Execute() error {
 		return c.executeShell()
 	case "adb-connect":
 		return c.executeAdbConnect()
+	case "install":
+		return c.executeInstall()
+	case "push":
+		return c.executeTransfer(true)
+	case "pull":
+		return c.executeTransfer(false)
 	case "remove":
 		return c.executeRemove()
 	case "list":
 		return c.executeList()
 	case "log":
 		return c.executeLog()
+	case "logcat":
+		return c.executeLogcat()
+	case "upgrade":
+		return c.executeUpgrade()
+	case "snapshot":
+		return c.executeSnapshot()
+	case "storage":
+		return c.executeStorage()
+	case "du":
+		return c.executeDu()
+	case "clone":
+		return c.executeClone()
+	case "rename":
+		return c.executeRename()
+	case "move-data":
+		return c.executeMoveData()
+	case "reset":
+		return c.executeReset()
+	case "export":
+		return c.executeExport()
+	case "import":
+		return c.executeImport()
 	case "prune":
 		return c.executePrune()
+	case "doctor":
+		return c.executeDoctor()
+	case "image":
+		return c.executeImage()
 	case "version":
 		return c.executeVersion()
 	default:
@@ -63,10 +102,97 @@ func (c *Command) Execute() error {
 
 func (c *Command) executeInit() error {
 	var containerName string
-	var image string
+	var imageURL string
+	var opts container.InitOptions
+	var positional []string
 
-	if len(c.Args) > 0 {
-		containerName = c.Args[0]
+	for i := 0; i < len(c.Args); i++ {
+		if v, ok, err := takeFlag(c.Args, &i, "--binder"); ok {
+			if err != nil {
+				return err
+			}
+			opts.BinderMode = v
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--profile"); ok {
+			if err != nil {
+				return err
+			}
+			opts.SecurityProfile = v
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--gpu"); ok {
+			if err != nil {
+				return err
+			}
+			opts.GPUMode = v
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--gpu-node"); ok {
+			if err != nil {
+				return err
+			}
+			opts.GPUNode = v
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--data-path"); ok {
+			if err != nil {
+				return err
+			}
+			abs, err := filepath.Abs(v)
+			if err != nil {
+				return err
+			}
+			opts.DataPath = abs
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--storage"); ok {
+			if err != nil {
+				return err
+			}
+			if err := config.ValidateStorage(v); err != nil {
+				return err
+			}
+			opts.Storage = v
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--storage-size"); ok {
+			if err != nil {
+				return err
+			}
+			size, err := config.ParseSize(v)
+			if err != nil {
+				return err
+			}
+			opts.StorageSize = size
+			continue
+		}
+		positional = append(positional, c.Args[i])
+	}
+	if opts.BinderMode != "" {
+		if err := config.ValidateBinderMode(opts.BinderMode); err != nil {
+			return err
+		}
+	}
+	if opts.SecurityProfile != "" {
+		if err := config.ValidateSecurityProfile(opts.SecurityProfile); err != nil {
+			return err
+		}
+	}
+	if opts.GPUMode != "" {
+		if err := config.ValidateGPUMode(opts.GPUMode); err != nil {
+			return err
+		}
+	}
+	if err := config.ValidateGPUNode(opts.GPUMode, opts.GPUNode); err != nil {
+		return err
+	}
+	if opts.StorageSize != 0 && opts.Storage != config.StorageImage {
+		return fmt.Errorf("--storage-size requires --storage %s", config.StorageImage)
+	}
+
+	if len(positional) > 0 {
+		containerName = positional[0]
 	} else {
 		fmt.Print("Enter container name: ")
 		_, err := fmt.Scanln(&containerName)
@@ -75,36 +201,29 @@ func (c *Command) executeInit() error {
 		}
 	}
 
-	if len(c.Args) > 1 {
-		image = c.Args[1]
+	if len(positional) > 1 {
+		imageURL = positional[1]
 	} else {
 		fmt.Println("\nAvailable Redroid Images:")
-		var filteredImages []config.RedroidImage
-		isARM := utils.IsARM()
-
-		for _, img := range config.AvailableImages {
-			isOfficial := strings.HasPrefix(img.URL, "redroid/redroid")
-
-			if isOfficial {
-				// Official images work on both (multi-arch), except 64only which is x86_64 only
-				if isARM && img.Is64Only {
-					continue
-				}
-				filteredImages = append(filteredImages, img)
-				continue
-			}
+		var filteredImages []config.CatalogImage
+		hostArch := sysinfo.HostImageArch()
 
-			// Community images: Hide 64only images on ARM hosts
-			if isARM && img.Is64Only {
-				continue
-			}
-			// Community images: Hide ARM-only images on x86_64 hosts
-			if !isARM && img.IsARMOnly {
+		for _, img := range config.LoadCatalog() {
+			// Hide images built for another architecture (e.g. 64only on ARM, ARM-only on x86_64)
+			if !img.SupportsArch(hostArch) {
 				continue
 			}
 			filteredImages = append(filteredImages, img)
 		}
 
+		for _, built := range image.ListBuilt(container.NewRuntime()) {
+			name := "Local build"
+			if built.Addons != "" {
+				name = fmt.Sprintf("Local build (%s on %s)", built.Addons, built.Base)
+			}
+			filteredImages = append(filteredImages, config.CatalogImage{Name: name, URL: built.Ref})
+		}
+
 		for i, img := range filteredImages {
 			fmt.Printf("[%d] %s (%s)\n", i+1, img.Name, img.URL)
 		}
@@ -120,16 +239,25 @@ func (c *Command) executeInit() error {
 
 		if choice == len(filteredImages)+1 {
 			fmt.Print("Enter custom image URL: ")
-			fmt.Scanln(&image)
-			if image == "" {
+			fmt.Scanln(&imageURL)
+			if imageURL == "" {
 				return fmt.Errorf("Image URL is required!")
 			}
 		} else {
-			image = filteredImages[choice-1].URL
+			imageURL = filteredImages[choice-1].URL
 		}
 	}
 
-	init := container.NewInitializer(containerName, image)
+	if image.IsBundle(imageURL) {
+		md, err := image.ImportBundle(container.NewRuntime(), imageURL, false)
+		if err != nil {
+			return err
+		}
+		imageURL = md.Ref
+		opts.LocalImage = true
+	}
+
+	init := container.NewInitializer(containerName, imageURL, opts)
 	return init.Initialize()
 }
 
@@ -225,6 +353,75 @@ func (c *Command) executeAdbConnect() error {
 	return adb.ShowConnection()
 }
 
+func (c *Command) executeInstall() error {
+	usage := "Usage: reddock install <name>[,<name>...]|--all <file.apk|dir|file.xapk|file.apks>... [-g] [-d] [-m]"
+	var opts utils.InstallOptions
+	all := false
+	var positional []string
+	for _, arg := range c.Args {
+		switch arg {
+		case "-g", "--grant":
+			opts.GrantPermissions = true
+		case "-d", "--downgrade":
+			opts.AllowDowngrade = true
+		case "-m", "--multiple":
+			opts.Multiple = true
+		case "--all":
+			all = true
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	var names []string
+	if all {
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+		for _, ct := range cfg.ListContainers() {
+			if container.NewManagerForContainer(ct.Name).IsRunning() {
+				names = append(names, ct.Name)
+			}
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("No running Reddock containers")
+		}
+	} else if len(positional) > 0 {
+		names = strings.Split(positional[0], ",")
+		positional = positional[1:]
+	}
+	if len(names) == 0 || len(positional) == 0 {
+		return fmt.Errorf("Container name and at least one package are required! %s", usage)
+	}
+	return utils.InstallPackages(names, positional, opts)
+}
+
+// executeTransfer handles push (host to device) and pull (device to host).
+func (c *Command) executeTransfer(push bool) error {
+	usage := "Usage: reddock pull <name> <device-path> <local-path> [--offline]"
+	if push {
+		usage = "Usage: reddock push <name> <local-path> <device-path> [--offline]"
+	}
+	offline := false
+	var positional []string
+	for _, arg := range c.Args {
+		if arg == "--offline" {
+			offline = true
+			continue
+		}
+		positional = append(positional, arg)
+	}
+	if len(positional) != 3 {
+		return fmt.Errorf("Container name, source and destination are required! %s", usage)
+	}
+	t := utils.NewFileTransfer(positional[0], offline)
+	if push {
+		return t.Push(positional[1], positional[2])
+	}
+	return t.Pull(positional[1], positional[2])
+}
+
 func (c *Command) executeRemove() error {
 	var containerName string
 	removeImage := false
@@ -246,25 +443,508 @@ func (c *Command) executeRemove() error {
 }
 
 func (c *Command) executeList() error {
+	offline, withSize := false, false
+	for _, arg := range c.Args {
+		switch arg {
+		case "--offline":
+			offline = true
+		case "--size", "-s":
+			withSize = true
+		default:
+			return fmt.Errorf("Unknown list option: %s. Usage: reddock list [--offline] [--size]", arg)
+		}
+	}
+
 	lister := container.NewLister()
-	return lister.ListReddockContainers()
+	return lister.ListReddockContainers(offline, withSize)
 }
 
 func (c *Command) executeLog() error {
-	var containerName string
+	usage := "Usage: reddock log <name> [--tail <n>|all] [--since <time>] [--until <time>] [--no-follow] [-t] [--save [--max-size <size>] [--keep <n>]]"
+	opts := utils.LogOptions{Keep: logfile.DefaultKeep}
+	var positional []string
+	for i := 0; i < len(c.Args); i++ {
+		switch c.Args[i] {
+		case "--no-follow":
+			opts.NoFollow = true
+			continue
+		case "-t", "--timestamps":
+			opts.Timestamps = true
+			continue
+		case "--save":
+			opts.Save = true
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--tail"); ok {
+			if err != nil {
+				return err
+			}
+			opts.Tail = v
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--since"); ok {
+			if err != nil {
+				return err
+			}
+			opts.Since = v
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--until"); ok {
+			if err != nil {
+				return err
+			}
+			opts.Until = v
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--max-size"); ok {
+			if err != nil {
+				return err
+			}
+			size, err := config.ParseSize(v)
+			if err != nil {
+				return err
+			}
+			opts.MaxSize = size
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--keep"); ok {
+			if err != nil {
+				return err
+			}
+			keep, err := strconv.Atoi(v)
+			if err != nil || keep < 0 {
+				return fmt.Errorf("Invalid --keep %q", v)
+			}
+			opts.Keep = keep
+			continue
+		}
+		positional = append(positional, c.Args[i])
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("Container name is required! %s", usage)
+	}
 
-	if len(c.Args) > 0 {
-		containerName = c.Args[0]
-	} else {
-		return fmt.Errorf("Container name is required! Usage: reddock log <container-name>")
+	logger := utils.NewLogManager(positional[0])
+	return logger.Show(opts)
+}
+
+func (c *Command) executeLogcat() error {
+	usage := "Usage: reddock logcat <name> [<tag>:<priority>...] [-p <priority>] [--pid <pid>|--package <pkg>] [--grep <regex>] [-f brief|threadtime|json] [-b <buffers>] [-d] [--save [-q] [--max-size <size>] [--keep <n>]]"
+	opts := utils.LogcatOptions{Keep: logfile.DefaultKeep}
+	var positional []string
+	for i := 0; i < len(c.Args); i++ {
+		arg := c.Args[i]
+		switch arg {
+		case "-d", "--dump":
+			opts.Dump = true
+			continue
+		case "--save":
+			opts.Save = true
+			continue
+		case "-q", "--quiet":
+			opts.Quiet = true
+			continue
+		}
+		var value string
+		var matched bool
+		var err error
+		for _, name := range []string{"-p", "--priority", "--pid", "--package", "--grep", "-e", "-f", "--format", "-b", "--buffer", "--max-size", "--keep"} {
+			if value, matched, err = takeFlag(c.Args, &i, name); matched {
+				arg = name
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if !matched {
+			positional = append(positional, arg)
+			continue
+		}
+		switch arg {
+		case "-p", "--priority":
+			opts.Priority = value
+		case "--pid":
+			pid, err := strconv.Atoi(value)
+			if err != nil || pid <= 0 {
+				return fmt.Errorf("Invalid --pid %q", value)
+			}
+			opts.Pid = pid
+		case "--package":
+			opts.Package = value
+		case "--grep", "-e":
+			opts.Grep = value
+		case "-f", "--format":
+			opts.Format = value
+		case "-b", "--buffer":
+			opts.Buffers = value
+		case "--max-size":
+			size, err := config.ParseSize(value)
+			if err != nil {
+				return err
+			}
+			opts.MaxSize = size
+		case "--keep":
+			keep, err := strconv.Atoi(value)
+			if err != nil || keep < 0 {
+				return fmt.Errorf("Invalid --keep %q", value)
+			}
+			opts.Keep = keep
+		}
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("Container name is required! %s", usage)
 	}
+	opts.Filters = positional[1:]
+	return utils.NewLogcatManager(positional[0], opts).Run()
+}
 
-	logger := utils.NewLogManager(containerName)
-	return logger.Show()
+func (c *Command) executeUpgrade() error {
+	var opts container.UpgradeOptions
+	var positional []string
+	rollback := false
+
+	for i := 0; i < len(c.Args); i++ {
+		if v, ok, err := takeFlag(c.Args, &i, "--timeout"); ok {
+			if err != nil {
+				return err
+			}
+			timeout, err := parseAge(v)
+			if err != nil {
+				return err
+			}
+			opts.Timeout = timeout
+			continue
+		}
+		switch c.Args[i] {
+		case "--rollback":
+			rollback = true
+		case "--no-rollback":
+			opts.NoRollback = true
+		case "--yes", "-y":
+			opts.Yes = true
+		default:
+			positional = append(positional, c.Args[i])
+		}
+	}
+
+	usage := "Usage: reddock upgrade <container-name> <image> [--timeout 10m] [--no-rollback] [-y] | reddock upgrade <container-name> --rollback"
+	if rollback {
+		if len(positional) != 1 {
+			return fmt.Errorf("Container name is required! %s", usage)
+		}
+		return container.NewUpgrader(positional[0], opts).Rollback()
+	}
+	if len(positional) != 2 {
+		return fmt.Errorf("Container name and image are required! %s", usage)
+	}
+	return container.NewUpgrader(positional[0], opts).Upgrade(positional[1])
+}
+
+func (c *Command) executeSnapshot() error {
+	usage := "Usage: reddock snapshot create <container-name> [--label <text>] [--method tar|btrfs|zfs] | list <container-name> | restore <container-name> <id> [-y] | delete <container-name> <id>"
+	if len(c.Args) == 0 {
+		return fmt.Errorf("Snapshot subcommand is required! %s", usage)
+	}
+
+	var label, method string
+	var positional []string
+	yes := false
+	for i := 1; i < len(c.Args); i++ {
+		if v, ok, err := takeFlag(c.Args, &i, "--label"); ok {
+			if err != nil {
+				return err
+			}
+			label = v
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--method"); ok {
+			if err != nil {
+				return err
+			}
+			method = v
+			continue
+		}
+		if c.Args[i] == "-y" || c.Args[i] == "--yes" {
+			yes = true
+			continue
+		}
+		positional = append(positional, c.Args[i])
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("Container name is required! %s", usage)
+	}
+	snapshots := container.NewSnapshotManager(positional[0])
+
+	switch c.Args[0] {
+	case "create":
+		_, err := snapshots.Create(label, method)
+		return err
+	case "list":
+		return snapshots.List()
+	case "restore", "delete":
+		if len(positional) != 2 {
+			return fmt.Errorf("Snapshot ID is required! %s", usage)
+		}
+		if c.Args[0] == "restore" {
+			return snapshots.Restore(positional[1], yes)
+		}
+		return snapshots.Delete(positional[1])
+	default:
+		return fmt.Errorf("Unknown snapshot subcommand: %s. %s", c.Args[0], usage)
+	}
+}
+
+func (c *Command) executeDu() error {
+	asJSON := false
+	var names []string
+	for _, arg := range c.Args {
+		switch {
+		case arg == "--json":
+			asJSON = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("Unknown du option: %s. Usage: reddock du [<name>...] [--json]", arg)
+		default:
+			names = append(names, arg)
+		}
+	}
+	return container.NewLister().DiskUsage(names, asJSON)
+}
+
+func (c *Command) executeStorage() error {
+	usage := "Usage: reddock storage usage <container-name> | resize <container-name> <size>"
+	if len(c.Args) < 2 {
+		return fmt.Errorf("Storage subcommand and container name are required! %s", usage)
+	}
+	storage := container.NewStorageManager(c.Args[1])
+
+	switch c.Args[0] {
+	case "usage":
+		u, err := storage.Usage()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Backend:  %s\n", u.Backend)
+		fmt.Printf("Location: %s\n", u.Location)
+		if u.Capacity > 0 {
+			fmt.Printf("Used:     %s of %s (%.0f%%)\n", ui.FormatBytes(u.Used), ui.FormatBytes(u.Capacity), float64(u.Used)*100/float64(u.Capacity))
+			fmt.Printf("On disk:  %s (sparse image)\n", ui.FormatBytes(u.Allocated))
+		} else {
+			fmt.Printf("Used:     %s (no size limit)\n", ui.FormatBytes(u.Used))
+		}
+		return nil
+	case "resize":
+		if len(c.Args) != 3 {
+			return fmt.Errorf("New size is required! %s", usage)
+		}
+		size, err := config.ParseSize(c.Args[2])
+		if err != nil {
+			return err
+		}
+		return storage.Resize(size)
+	default:
+		return fmt.Errorf("Unknown storage subcommand: %s. %s", c.Args[0], usage)
+	}
+}
+
+func (c *Command) executeClone() error {
+	count := 1
+	var positional []string
+	for i := 0; i < len(c.Args); i++ {
+		if v, ok, err := takeFlag(c.Args, &i, "--count"); ok {
+			if err != nil {
+				return err
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return fmt.Errorf("Invalid --count: %s", v)
+			}
+			count = n
+			continue
+		}
+		positional = append(positional, c.Args[i])
+	}
+	if len(positional) != 2 {
+		return fmt.Errorf("Source and destination names are required! Usage: reddock clone <src> <dst> [--count N]")
+	}
+
+	names := container.CloneNames(positional[1], count)
+	for _, name := range names {
+		if err := config.ValidateContainerName(name); err != nil {
+			return err
+		}
+	}
+	clones, err := container.NewCloner(positional[0]).Clone(names)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\n%-20s %-10s %s\n", "NAME", "ADB PORT", "DATA")
+	for _, clone := range clones {
+		fmt.Printf("%-20s %-10d %s\n", clone.Name, clone.HostADBPort(), clone.GetDataPath())
+	}
+	fmt.Printf("\nStart them with: reddock start <name>\n")
+	return nil
+}
+
+func (c *Command) executeRename() error {
+	force, moveData := false, false
+	var positional []string
+	for _, arg := range c.Args {
+		switch arg {
+		case "--force", "-f":
+			force = true
+		case "--move-data":
+			moveData = true
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) != 2 {
+		return fmt.Errorf("Old and new names are required! Usage: reddock rename <old> <new> [--force] [--move-data]")
+	}
+	return container.NewRenamer().Rename(positional[0], positional[1], force, moveData)
+}
+
+func (c *Command) executeMoveData() error {
+	if len(c.Args) != 2 {
+		return fmt.Errorf("Container name and new path are required! Usage: reddock move-data <name> <path>")
+	}
+	return container.NewDataMover(c.Args[0]).Move(c.Args[1])
+}
+
+func (c *Command) executeReset() error {
+	var name string
+	var opts container.ResetOptions
+	for i := 0; i < len(c.Args); i++ {
+		if v, ok, err := takeFlag(c.Args, &i, "--keep"); ok {
+			if err != nil {
+				return err
+			}
+			opts.Keep = append(opts.Keep, v)
+			continue
+		}
+		switch arg := c.Args[i]; arg {
+		case "--snapshot-first":
+			opts.SnapshotFirst = true
+		case "--yes", "-y":
+			opts.Yes = true
+		default:
+			if name != "" {
+				return fmt.Errorf("Unexpected argument: %s", arg)
+			}
+			name = arg
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("Container name is required! Usage: reddock reset <name> [--keep <path>]... [--snapshot-first] [-y]")
+	}
+	return container.NewResetter(name, opts).Reset()
+}
+
+func (c *Command) executeExport() error {
+	var name, output string
+	includeImage := false
+	for i := 0; i < len(c.Args); i++ {
+		if v, ok, err := takeFlag(c.Args, &i, "-o"); ok {
+			if err != nil {
+				return err
+			}
+			output = v
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--output"); ok {
+			if err != nil {
+				return err
+			}
+			output = v
+			continue
+		}
+		if c.Args[i] == "--image" {
+			includeImage = true
+		} else if name == "" {
+			name = c.Args[i]
+		} else {
+			return fmt.Errorf("Unknown argument: %s", c.Args[i])
+		}
+	}
+	if name == "" || output == "" {
+		return fmt.Errorf("Container name and output file are required! Usage: reddock export <container-name> -o <file> [--image]")
+	}
+
+	if err := bundle.Export(name, output, includeImage); err != nil {
+		return err
+	}
+	fmt.Printf("\nExported '%s' to %s\n", name, output)
+	fmt.Printf("On the other host: reddock import %s [--name <new-name>]\n", output)
+	return nil
+}
+
+func (c *Command) executeImport() error {
+	var path, name string
+	allowMovedImage := false
+	for i := 0; i < len(c.Args); i++ {
+		if v, ok, err := takeFlag(c.Args, &i, "--name"); ok {
+			if err != nil {
+				return err
+			}
+			name = v
+			continue
+		}
+		if c.Args[i] == "--allow-moved-image" {
+			allowMovedImage = true
+			continue
+		}
+		if path != "" {
+			return fmt.Errorf("Unknown argument: %s", c.Args[i])
+		}
+		path = c.Args[i]
+	}
+	if path == "" {
+		return fmt.Errorf("Bundle file is required! Usage: reddock import <file> [--name <new-name>] [--allow-moved-image]")
+	}
+
+	imported, err := bundle.Import(path, name, allowMovedImage)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nImported '%s' (data in %s, ADB port %d)\n", imported.Name, imported.GetDataPath(), imported.HostADBPort())
+	fmt.Printf("Start it with: reddock start %s\n", imported.Name)
+	return nil
+}
+
+func (c *Command) executeDoctor() error {
+	doctor := utils.NewDoctor()
+	return doctor.Run()
 }
 
 func (c *Command) executePrune() error {
-	pruner := container.NewPruner()
+	var opts image.PruneOptions
+
+	for i := 0; i < len(c.Args); i++ {
+		if v, ok, err := takeFlag(c.Args, &i, "--older-than"); ok {
+			if err != nil {
+				return err
+			}
+			age, err := parseAge(v)
+			if err != nil {
+				return err
+			}
+			opts.OlderThan = age
+			continue
+		}
+		switch c.Args[i] {
+		case "--dry-run", "-n":
+			opts.DryRun = true
+		case "--yes", "-y":
+			opts.Yes = true
+		case "--all", "-a":
+			opts.All = true
+		default:
+			return fmt.Errorf("Unknown prune option: %s. Usage: reddock prune [--dry-run] [--older-than <age>] [--all] [-y]", c.Args[i])
+		}
+	}
+
+	pruner := image.NewPruner(opts)
 	return pruner.Prune()
 }
 
@@ -274,21 +954,74 @@ func PrintUsage() {
 	fmt.Println("daemon can block LXC features Waydroid needs; see messages after init.")
 	fmt.Println("\nUsage: reddock [command] [options]")
 	fmt.Println("\nCommands:")
-	fmt.Println("  init [<n>] [<image>]        		Initialize container (interactive if name/image omitted)")
+	fmt.Println("  init [<n>] [<image>|<bundle>]		Initialize container (interactive if name/image omitted)")
+	fmt.Println("    [--binder shared|binderfs]    		Binder devices: host-shared or a private binderfs per instance")
+	fmt.Println("    [--profile privileged|restricted]	Security profile (restricted drops --privileged)")
+	fmt.Println("    [--gpu host|guest|auto]       		GPU rendering mode (validated against /dev/dri render nodes)")
+	fmt.Println("    [--gpu-node <path>]           		Render node for host mode (default: auto-selected)")
+	fmt.Println("    [--data-path <dir>]           		Data directory (default: $HOME/data-<n>)")
+	fmt.Println("    [--storage dir|volume|image] [--storage-size <size>]	/data backend; image is a fixed-size ext4 file (default 16G)")
 	fmt.Println("  start <n> [-v]              		Start container (use -v for foreground/logs)")
 	fmt.Println("  stop <n>                    		Stop container (name required)")
 	fmt.Println("  restart <n> [-v]            		Restart container (use -v for foreground/logs)")
+	fmt.Println("  upgrade <n> <image>         		Move to a new image with a data snapshot; rolls back if boot fails")
+	fmt.Println("    [--timeout <dur>] [--no-rollback] [-y]	Boot wait (default 5m); keep a failed upgrade; skip downgrade prompt")
+	fmt.Println("  upgrade <n> --rollback      		Restore the previous image and pre-upgrade data snapshot")
+	fmt.Println("  snapshot create <n> [--label <text>] [--method tar|btrfs|zfs]	Snapshot the data directory")
+	fmt.Println("  snapshot list|restore|delete <n> [<id>]	List, restore (-y skips the prompt) or delete snapshots")
+	fmt.Println("  storage usage|resize <n> [<size>]	Show /data usage, or grow/shrink an image-backed instance (stopped)")
+	fmt.Println("  clone <src> <dst> [--count N]	Copy an instance (reflink/btrfs) with new ports, android_id and ADB keys")
+	fmt.Println("  rename <old> <new> [--force] [--move-data]	Rename an instance (--force restarts a running one)")
+	fmt.Println("  reset <n> [--keep <path>]... [--snapshot-first] [-y]	Factory reset: wipe /data (except kept paths) and restart")
+	fmt.Println("  move-data <n> <path>           	Move the data directory (rename or verified copy) and recreate the container")
+	fmt.Println("  export <n> -o <file> [--image]	Pack the instance config and data (and image) for another host")
+	fmt.Println("  import <file> [--name <new>] [--allow-moved-image]	Recreate an exported instance with paths and ports for this host")
 	fmt.Println("  status <n>                  		Show container status (name required)")
 	fmt.Println("  shell <n>                   		Enter container shell (name required)")
-	fmt.Println("  adb-connect <n>             		Show ADB connection command (name required)")
+	fmt.Println("  adb-connect <n>             		Check ADB with the built-in client and show how to connect")
+	fmt.Println("  install <n>[,<n>...]|--all <apk|dir|xapk|apks>... [-g] [-d] [-m]	Install packages in parallel (-g grant permissions, -d downgrade, -m one split set)")
+	fmt.Println("  push <n> <local> <device> [--offline]	Copy files/directories to the instance (--offline: stopped, via the data dir)")
+	fmt.Println("  pull <n> <device> <local> [--offline]	Copy files/directories from the instance")
 	fmt.Println("  remove <n> [--image]        		Remove container/data (--image to also remove image)")
-	fmt.Println("  list                           	List all Reddock-managed containers")
-	fmt.Println("  log <n>                     		Show container logs (name required)")
-	fmt.Println("  prune                          	Remove unused images")
+	fmt.Println("  list [--offline] [--size]      	List containers with update state (--offline skips the check, --size adds disk usage)")
+	fmt.Println("  du [<n>...] [--json]           	Disk usage: data, writable layer, snapshots and image (exclusive/shared)")
+	fmt.Println("  log <n> [--tail <n>] [--since <t>] [--until <t>]	Show container logs (--no-follow to exit, -t timestamps)")
+	fmt.Println("    [--no-follow] [-t] [--save [--max-size <size>] [--keep <n>]]	--save also appends to the instance LogFile")
+	fmt.Println("  logcat <n> [<tag>:<prio>...]   	Android logcat (-p <prio>, --pid, --package, --grep <regex>, -f brief|threadtime|json, -d)")
+	fmt.Println("    [--save [-q] [--max-size <size>] [--keep <n>]]	Also append to rotating files in the instance log directory")
+	fmt.Println("  prune [--dry-run] [-y]         	Remove redroid/catalog/built images no instance references")
+	fmt.Println("    [--older-than <age>] [--all]  		Only images older than age (30d, 72h); --all also prunes dangling images")
+	fmt.Println("  image build --base <image>     	Layer GApps/Magisk/libndk/libhoudini archives onto an official image")
+	fmt.Println("    [--gapps|--magisk|--libndk|--libhoudini <archive>] [-t <tag>]")
+	fmt.Println("  image catalog list|add|remove|import	Manage the image catalog used by init (--system for /etc/reddock)")
+	fmt.Println("  image outdated [--pull]        	Compare instance digests with the registry; --pull fetches new images")
+	fmt.Println("  image export <image> -o <file>	Save an image with checksums and catalog metadata for offline hosts")
+	fmt.Println("  image import <file> [--system]	Verify and load an image bundle (and its catalog entry)")
+	fmt.Println("  doctor                         	Check host support (binder, GPU, LSM, restricted profile)")
 	fmt.Println("  version                        	Show version information")
 	fmt.Println("\nExamples:")
 	fmt.Println("  sudo reddock init android13")
+	fmt.Println("  sudo reddock init ci-1 redroid/redroid:13.0.0-latest --binder binderfs")
+	fmt.Println("  sudo reddock init ci-2 registry.example.com:5000/redroid/redroid@sha256:<digest>")
+	fmt.Println("  sudo reddock init ci-3 redroid/redroid:13.0.0-latest --storage image --storage-size 8G")
 	fmt.Println("  sudo reddock start android13 -v")
+	fmt.Println("  sudo reddock install ci-1,ci-2 app.apk game.xapk -g")
+	fmt.Println("  sudo reddock push android13 fixtures/ /sdcard/Download/")
+	fmt.Println("  sudo reddock log android13 --since 10m --no-follow -t")
+	fmt.Println("  sudo reddock logcat android13 --package com.example.app -p W -f json")
+	fmt.Println("  sudo reddock pull android13 /sdcard/DCIM ./dcim --offline")
+	fmt.Println("  sudo reddock image build --base redroid/redroid:11.0.0-latest --gapps gapps.zip --libndk libndk.tar.gz")
+	fmt.Println("  sudo reddock image export redroid/redroid:13.0.0-latest -o android13.rdimg")
+	fmt.Println("  sudo reddock init android13 ./android13.rdimg")
+	fmt.Println("  sudo reddock snapshot create android13 --label before-magisk")
+	fmt.Println("  sudo reddock clone android13 tester --count 10")
+	fmt.Println("  sudo reddock reset android13 --keep /data/misc/adb --snapshot-first")
+	fmt.Println("  sudo reddock move-data android13 /srv/reddock/android13")
+	fmt.Println("  sudo reddock export android13 -o android13.rdinst --image")
+	fmt.Println("  sudo reddock import android13.rdinst --name android13-copy")
+	fmt.Println("  sudo reddock upgrade android12 redroid/redroid:13.0.0-latest")
+	fmt.Println("  sudo reddock du --json")
+	fmt.Println("  sudo reddock prune --dry-run --older-than 30d")
 	fmt.Println("  sudo reddock remove android13")
 	fmt.Println("  sudo reddock remove android13 --image  # Also remove Docker image")
 }
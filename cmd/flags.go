@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// takeFlag matches args[*i] against a value flag given as "name value" or "name=value".
+// On a match it returns the value and advances *i past any consumed argument.
+func takeFlag(args []string, i *int, name string) (string, bool, error) {
+	arg := args[*i]
+	if strings.HasPrefix(arg, name+"=") {
+		return strings.TrimPrefix(arg, name+"="), true, nil
+	}
+	if arg != name {
+		return "", false, nil
+	}
+	if *i+1 >= len(args) {
+		return "", true, fmt.Errorf("Flag %s requires a value", name)
+	}
+	*i++
+	return args[*i], true, nil
+}
+
+// parseAge accepts Go durations (e.g. 72h, 90m) plus whole days and weeks (30d, 2w).
+func parseAge(s string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.Atoi(n)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("Invalid age %q (use e.g. 30d, 2w or 72h)", s)
+			}
+			return time.Duration(v) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("Invalid age %q (use e.g. 30d, 2w or 72h)", s)
+	}
+	return d, nil
+}
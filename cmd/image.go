@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"reddock/pkg/config"
+	"reddock/pkg/container"
+	"reddock/pkg/image"
+)
+
+func (c *Command) executeImage() error {
+	if len(c.Args) == 0 {
+		return fmt.Errorf("Image subcommand is required! Usage: reddock image build|catalog|outdated|export|import ...")
+	}
+	sub := &Command{Name: c.Args[0], Args: c.Args[1:]}
+	switch sub.Name {
+	case "build":
+		return sub.executeImageBuild()
+	case "catalog":
+		return sub.executeImageCatalog()
+	case "outdated":
+		return sub.executeImageOutdated()
+	case "export":
+		return sub.executeImageExport()
+	case "import":
+		return sub.executeImageImport()
+	default:
+		return fmt.Errorf("Unknown image subcommand: %s", sub.Name)
+	}
+}
+
+func (c *Command) executeImageBuild() error {
+	opts := image.BuildOptions{Addons: make(map[string]string)}
+	addonFlags := map[string]string{
+		"--gapps":      image.AddonGApps,
+		"--magisk":     image.AddonMagisk,
+		"--libndk":     image.AddonLibNDK,
+		"--libhoudini": image.AddonLibHoudini,
+	}
+
+args:
+	for i := 0; i < len(c.Args); i++ {
+		if v, ok, err := takeFlag(c.Args, &i, "--base"); ok {
+			if err != nil {
+				return err
+			}
+			opts.Base = v
+			continue
+		}
+		for _, name := range []string{"-t", "--tag"} {
+			if v, ok, err := takeFlag(c.Args, &i, name); ok {
+				if err != nil {
+					return err
+				}
+				opts.Tag = v
+				continue args
+			}
+		}
+		for flag, addon := range addonFlags {
+			if v, ok, err := takeFlag(c.Args, &i, flag); ok {
+				if err != nil {
+					return err
+				}
+				opts.Addons[addon] = v
+				continue args
+			}
+		}
+		return fmt.Errorf("Unknown argument: %s", c.Args[i])
+	}
+
+	if opts.Base == "" {
+		return fmt.Errorf("Base image is required! Usage: reddock image build --base redroid/redroid:<tag> [--gapps <archive>] [--magisk <archive>] [--libndk|--libhoudini <archive>] [-t <tag>]")
+	}
+
+	tag, err := image.NewBuilder(opts).Build()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nUse it with: reddock init <name> %s\n", tag)
+	return nil
+}
+
+func (c *Command) executeImageCatalog() error {
+	usage := "Usage: reddock image catalog list | add <url> [options] | remove <url> | import <file|url> [--system]"
+	if len(c.Args) == 0 {
+		return fmt.Errorf("Catalog subcommand is required! %s", usage)
+	}
+
+	system := false
+	img := config.CatalogImage{}
+	var positional []string
+	for i := 1; i < len(c.Args); i++ {
+		arg := c.Args[i]
+		switch arg {
+		case "--system":
+			system = true
+			continue
+		case "--64only":
+			img.Is64Only = true
+			continue
+		case "--gapps":
+			img.GApps = true
+			continue
+		case "--magisk":
+			img.Magisk = true
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--name"); ok {
+			if err != nil {
+				return err
+			}
+			img.Name = v
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--android"); ok {
+			if err != nil {
+				return err
+			}
+			img.AndroidVersion = v
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--arch"); ok {
+			if err != nil {
+				return err
+			}
+			img.Arch = strings.Split(v, ",")
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--native-bridge"); ok {
+			if err != nil {
+				return err
+			}
+			img.NativeBridge = v
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--boot-arg"); ok {
+			if err != nil {
+				return err
+			}
+			img.BootArgs = append(img.BootArgs, v)
+			continue
+		}
+		positional = append(positional, arg)
+	}
+
+	switch c.Args[0] {
+	case "list":
+		image.PrintCatalog(config.LoadCatalog())
+		return nil
+	case "add":
+		if len(positional) != 1 {
+			return fmt.Errorf("Image URL is required! %s", usage)
+		}
+		img.URL = positional[0]
+		if err := image.AddCatalogImage(img, system); err != nil {
+			return err
+		}
+		fmt.Printf("Added '%s' to %s\n", img.URL, image.CatalogPath(system))
+		return nil
+	case "remove":
+		if len(positional) != 1 {
+			return fmt.Errorf("Image URL is required! %s", usage)
+		}
+		if err := image.RemoveCatalogImage(positional[0], system); err != nil {
+			return err
+		}
+		fmt.Printf("Removed '%s' from the catalog\n", positional[0])
+		return nil
+	case "import":
+		if len(positional) != 1 {
+			return fmt.Errorf("Catalog file is required! %s", usage)
+		}
+		n, err := image.ImportCatalog(positional[0], system)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d image(s) into %s\n", n, image.CatalogPath(system))
+		return nil
+	default:
+		return fmt.Errorf("Unknown catalog subcommand: %s. %s", c.Args[0], usage)
+	}
+}
+
+func (c *Command) executeImageOutdated() error {
+	pull := false
+	for _, arg := range c.Args {
+		if arg == "--pull" {
+			pull = true
+		} else {
+			return fmt.Errorf("Unknown argument: %s. Usage: reddock image outdated [--pull]", arg)
+		}
+	}
+	return image.Outdated(pull)
+}
+
+func (c *Command) executeImageExport() error {
+	var ref, output string
+	for i := 0; i < len(c.Args); i++ {
+		if v, ok, err := takeFlag(c.Args, &i, "-o"); ok {
+			if err != nil {
+				return err
+			}
+			output = v
+			continue
+		}
+		if v, ok, err := takeFlag(c.Args, &i, "--output"); ok {
+			if err != nil {
+				return err
+			}
+			output = v
+			continue
+		}
+		if ref != "" {
+			return fmt.Errorf("Unknown argument: %s", c.Args[i])
+		}
+		ref = c.Args[i]
+	}
+	if ref == "" || output == "" {
+		return fmt.Errorf("Image and output file are required! Usage: reddock image export <image> -o <file>")
+	}
+	if err := config.ValidateImageName(ref); err != nil {
+		return err
+	}
+
+	md, err := image.ExportBundle(container.NewRuntime(), ref, output)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported %s (%s) to %s\n", md.Ref, md.Arch, output)
+	fmt.Printf("\nOn the offline host: reddock image import %s  (or: reddock init <name> %s)\n", output, output)
+	return nil
+}
+
+func (c *Command) executeImageImport() error {
+	var path string
+	system := false
+	for _, arg := range c.Args {
+		if arg == "--system" {
+			system = true
+		} else if path == "" {
+			path = arg
+		} else {
+			return fmt.Errorf("Unknown argument: %s", arg)
+		}
+	}
+	if path == "" {
+		return fmt.Errorf("Bundle file is required! Usage: reddock image import <file> [--system]")
+	}
+
+	md, err := image.ImportBundle(container.NewRuntime(), path, system)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %s (%s", md.Ref, md.Arch)
+	if md.AndroidVersion != "" {
+		fmt.Printf(", Android %s", md.AndroidVersion)
+	}
+	fmt.Printf(")\n\nUse it with: reddock init <name> %s\n", md.Ref)
+	return nil
+}
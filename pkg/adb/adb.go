@@ -0,0 +1,291 @@
+// Package adb is a host-side client for the ADB wire protocol (the one adbd speaks on
+// TCP port 5555), so reddock can run shell commands, transfer files and install packages
+// without the platform-tools adb binary.
+package adb
+
+import (
+	"bufio"
+	"crypto/rsa"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message commands (little-endian ASCII).
+const (
+	cmdCNXN = 0x4e584e43
+	cmdAUTH = 0x48545541
+	cmdOPEN = 0x4e45504f
+	cmdOKAY = 0x59414b4f
+	cmdCLSE = 0x45534c43
+	cmdWRTE = 0x45545257
+	cmdSTLS = 0x534c5453
+)
+
+// AUTH message types.
+const (
+	authToken        = 1
+	authSignature    = 2
+	authRSAPublicKey = 3
+)
+
+const (
+	protocolVersion = 0x01000001
+	maxPayload      = 256 * 1024
+	headerSize      = 24
+
+	// DialTimeout bounds connecting and the CNXN/AUTH handshake.
+	DialTimeout = 10 * time.Second
+)
+
+// hostFeatures are the protocol features this client understands.
+var hostFeatures = []string{"shell_v2", "cmd", "stat_v2"}
+
+type message struct {
+	command uint32
+	arg0    uint32
+	arg1    uint32
+	data    []byte
+}
+
+// Device describes the peer from its CNXN banner.
+type Device struct {
+	State      string            // "device", "recovery", ...
+	Properties map[string]string // ro.product.name, ro.product.model, ro.product.device
+	Features   map[string]bool
+}
+
+// Conn is one ADB transport to a device. Streams (services) are multiplexed over it and
+// may be used from several goroutines.
+type Conn struct {
+	conn       net.Conn
+	writeMu    sync.Mutex
+	maxPayload int
+	device     Device
+
+	mu      sync.Mutex
+	nextID  uint32
+	streams map[uint32]*stream
+	err     error // set when the transport fails; all streams see it
+	closed  chan struct{}
+}
+
+// Dial connects to adbd at addr (host:port) and authenticates with key when the device
+// requires it. A device that does not know the key yet is sent the public key; on devices
+// with ro.adb.secure=1 the user must accept it on screen.
+func Dial(addr string, key *rsa.PrivateKey) (*Conn, error) {
+	nc, err := net.DialTimeout("tcp", addr, DialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to adbd at %s: %v", addr, err)
+	}
+	c := &Conn{conn: nc, maxPayload: maxPayload, nextID: 1, streams: make(map[uint32]*stream), closed: make(chan struct{})}
+	nc.SetDeadline(time.Now().Add(DialTimeout))
+	if err := c.handshake(key); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("ADB handshake with %s failed: %v", addr, err)
+	}
+	nc.SetDeadline(time.Time{})
+	go c.readLoop()
+	return c, nil
+}
+
+// Device returns what the device reported in its CNXN banner.
+func (c *Conn) Device() Device {
+	return c.device
+}
+
+// Close shuts the transport down; open streams fail with io.ErrClosedPipe.
+func (c *Conn) Close() error {
+	c.fail(io.ErrClosedPipe)
+	return c.conn.Close()
+}
+
+func (c *Conn) handshake(key *rsa.PrivateKey) error {
+	banner := "host::features=" + strings.Join(hostFeatures, ",")
+	if err := c.send(message{cmdCNXN, protocolVersion, maxPayload, []byte(banner)}); err != nil {
+		return err
+	}
+	r := bufio.NewReader(c.conn)
+	sentSignature, sentKey := false, false
+	for {
+		m, err := readMessage(r)
+		if err != nil {
+			return err
+		}
+		switch m.command {
+		case cmdCNXN:
+			if int(m.arg1) < c.maxPayload {
+				c.maxPayload = int(m.arg1)
+			}
+			c.device = parseBanner(string(m.data))
+			// The reader goroutine takes over; hand it whatever was already buffered.
+			c.conn = &bufferedConn{Conn: c.conn, r: r}
+			return nil
+		case cmdAUTH:
+			if m.arg0 != authToken {
+				return fmt.Errorf("unexpected AUTH type %d", m.arg0)
+			}
+			if key == nil {
+				return fmt.Errorf("the device requires authentication and no key is available")
+			}
+			switch {
+			case !sentSignature:
+				sig, err := signToken(key, m.data)
+				if err != nil {
+					return err
+				}
+				sentSignature = true
+				err = c.send(message{cmdAUTH, authSignature, 0, sig})
+				if err != nil {
+					return err
+				}
+			case !sentKey:
+				pub, err := AndroidPublicKey(&key.PublicKey)
+				if err != nil {
+					return err
+				}
+				sentKey = true
+				// The device waits for the user to accept the key; give them time.
+				c.conn.SetDeadline(time.Now().Add(60 * time.Second))
+				if err := c.send(message{cmdAUTH, authRSAPublicKey, 0, append([]byte(pub), 0)}); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("the device rejected the key (accept the USB debugging prompt or add it to /data/misc/adb/adb_keys)")
+			}
+		case cmdSTLS:
+			return fmt.Errorf("the device requires TLS (wireless debugging pairing), which is not supported")
+		default:
+			return fmt.Errorf("unexpected message %s during handshake", commandName(m.command))
+		}
+	}
+}
+
+// parseBanner reads "device::ro.product.name=x;ro.product.model=y;features=a,b".
+func parseBanner(banner string) Device {
+	d := Device{Properties: make(map[string]string), Features: make(map[string]bool)}
+	state, props, _ := strings.Cut(strings.TrimRight(banner, "\x00"), "::")
+	d.State = state
+	for _, kv := range strings.Split(props, ";") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		if k == "features" {
+			for _, f := range strings.Split(v, ",") {
+				d.Features[f] = true
+			}
+			continue
+		}
+		d.Properties[k] = v
+	}
+	return d
+}
+
+func (c *Conn) send(m message) error {
+	buf := make([]byte, headerSize+len(m.data))
+	binary.LittleEndian.PutUint32(buf[0:], m.command)
+	binary.LittleEndian.PutUint32(buf[4:], m.arg0)
+	binary.LittleEndian.PutUint32(buf[8:], m.arg1)
+	binary.LittleEndian.PutUint32(buf[12:], uint32(len(m.data)))
+	binary.LittleEndian.PutUint32(buf[16:], checksum(m.data))
+	binary.LittleEndian.PutUint32(buf[20:], m.command^0xffffffff)
+	copy(buf[headerSize:], m.data)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func readMessage(r io.Reader) (message, error) {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return message{}, err
+	}
+	m := message{
+		command: binary.LittleEndian.Uint32(hdr[0:]),
+		arg0:    binary.LittleEndian.Uint32(hdr[4:]),
+		arg1:    binary.LittleEndian.Uint32(hdr[8:]),
+	}
+	length := binary.LittleEndian.Uint32(hdr[12:])
+	if binary.LittleEndian.Uint32(hdr[20:]) != m.command^0xffffffff {
+		return message{}, fmt.Errorf("corrupt message header")
+	}
+	if length > 16*1024*1024 {
+		return message{}, fmt.Errorf("message payload of %d bytes is too large", length)
+	}
+	m.data = make([]byte, length)
+	if _, err := io.ReadFull(r, m.data); err != nil {
+		return message{}, err
+	}
+	// Protocol version 0x01000001 and later no longer require the checksum; older peers
+	// always send it.
+	if sum := binary.LittleEndian.Uint32(hdr[16:]); sum != 0 && sum != checksum(m.data) {
+		return message{}, fmt.Errorf("message checksum mismatch")
+	}
+	return m, nil
+}
+
+func checksum(data []byte) uint32 {
+	var sum uint32
+	for _, b := range data {
+		sum += uint32(b)
+	}
+	return sum
+}
+
+func commandName(cmd uint32) string {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], cmd)
+	return string(b[:])
+}
+
+// readLoop dispatches incoming messages to their streams until the transport fails.
+func (c *Conn) readLoop() {
+	for {
+		m, err := readMessage(c.conn)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.mu.Lock()
+		s := c.streams[m.arg1]
+		c.mu.Unlock()
+		if s == nil {
+			// A stream we already closed; tell the device it is gone.
+			if m.command == cmdWRTE || m.command == cmdOKAY {
+				c.send(message{cmdCLSE, m.arg1, m.arg0, nil})
+			}
+			continue
+		}
+		s.handle(m)
+	}
+}
+
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.closed)
+	for _, s := range c.streams {
+		s.closeWith(err)
+	}
+}
+
+// bufferedConn keeps bytes read ahead during the handshake.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error) {
+	return b.r.Read(p)
+}
@@ -0,0 +1,759 @@
+package adb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testToken is the AUTH token the fake device sends. testdata/token.sig is its signature
+// with testdata/adbkey, made independently of this package with
+//
+//	openssl pkeyutl -sign -inkey adbkey -pkeyopt rsa_padding_mode:pkcs1 -pkeyopt digest:sha1
+//
+// and testdata/adbkey.pub holds the key in adb's format.
+const testToken = "0123456789abcdefghij"
+
+const testBanner = "device::ro.product.name=redroid_x86_64;ro.product.model=redroid13_x86_64;ro.product.device=redroid_x86_64;features=shell_v2,cmd,stat_v2"
+
+func loadTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := LoadOrCreateKey(filepath.Join("testdata", "adbkey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// fakeDevice is a minimal adbd: it performs the CNXN/AUTH handshake and passes every
+// opened service to handle.
+type fakeDevice struct {
+	t          *testing.T
+	banner     string
+	maxPayload uint32
+
+	// authKey makes the device ask for authentication. A trusted key is accepted by its
+	// signature; otherwise the device asks again and expects the public key, or rejects
+	// the connection when reject is set.
+	authKey *rsa.PublicKey
+	trusted bool
+	reject  bool
+
+	handle  func(service string, s *fakeStream)
+	refused string // a service the device answers with CLSE instead of OKAY
+
+	mu        sync.Mutex
+	publicKey string
+}
+
+func (d *fakeDevice) start() string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	d.t.Cleanup(func() { ln.Close() })
+	go func() {
+		nc, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer nc.Close()
+		d.serve(nc)
+	}()
+	return ln.Addr().String()
+}
+
+func (d *fakeDevice) dial() *Conn {
+	d.t.Helper()
+	c, err := Dial(d.start(), loadTestKey(d.t))
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	d.t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func (d *fakeDevice) serve(nc net.Conn) {
+	w := &Conn{conn: nc} // only used to frame messages
+	r := bufio.NewReader(nc)
+	m, err := readMessage(r)
+	if err != nil || m.command != cmdCNXN {
+		d.t.Errorf("expected CNXN from the host, got %s (%v)", commandName(m.command), err)
+		return
+	}
+	if m.arg0 != protocolVersion || m.arg1 != maxPayload {
+		d.t.Errorf("CNXN version %#x, max payload %d", m.arg0, m.arg1)
+	}
+	if banner := string(m.data); !strings.HasPrefix(banner, "host::") || !strings.Contains(banner, "shell_v2") {
+		d.t.Errorf("host banner %q", banner)
+	}
+	if d.authKey != nil && !d.authenticate(w, r) {
+		return
+	}
+	w.send(message{cmdCNXN, protocolVersion, d.maxPayload, []byte(d.banner)})
+	d.dispatch(w, r)
+}
+
+func (d *fakeDevice) authenticate(w *Conn, r *bufio.Reader) bool {
+	w.send(message{cmdAUTH, authToken, 0, []byte(testToken)})
+	m, err := readMessage(r)
+	if err != nil || m.command != cmdAUTH || m.arg0 != authSignature {
+		d.t.Errorf("expected AUTH signature, got %s %d (%v)", commandName(m.command), m.arg0, err)
+		return false
+	}
+	if err := rsa.VerifyPKCS1v15(d.authKey, crypto.SHA1, []byte(testToken), m.data); err != nil {
+		d.t.Errorf("AUTH signature does not verify: %v", err)
+		return false
+	}
+	if d.trusted {
+		return true
+	}
+
+	// An unknown key: send another token and the host answers with its public key.
+	w.send(message{cmdAUTH, authToken, 0, []byte(testToken)})
+	m, err = readMessage(r)
+	if err != nil || m.command != cmdAUTH || m.arg0 != authRSAPublicKey {
+		d.t.Errorf("expected AUTH public key, got %s %d (%v)", commandName(m.command), m.arg0, err)
+		return false
+	}
+	if !bytes.HasSuffix(m.data, []byte{0}) {
+		d.t.Errorf("public key is not NUL-terminated")
+	}
+	d.mu.Lock()
+	d.publicKey = strings.TrimRight(string(m.data), "\x00")
+	d.mu.Unlock()
+	if d.reject {
+		w.send(message{cmdAUTH, authToken, 0, []byte(testToken)})
+		io.Copy(io.Discard, r)
+		return false
+	}
+	return true
+}
+
+func (d *fakeDevice) dispatch(w *Conn, r *bufio.Reader) {
+	streams := make(map[uint32]*fakeStream)
+	next := uint32(100)
+	for {
+		m, err := readMessage(r)
+		if err != nil {
+			return
+		}
+		if len(m.data) > int(d.maxPayload) {
+			d.t.Errorf("%s payload of %d bytes exceeds the negotiated %d", commandName(m.command), len(m.data), d.maxPayload)
+		}
+		if m.command == cmdOPEN {
+			service := strings.TrimRight(string(m.data), "\x00")
+			if service == d.refused {
+				w.send(message{cmdCLSE, 0, m.arg0, nil})
+				continue
+			}
+			s := &fakeStream{
+				w:      w,
+				local:  next,
+				remote: m.arg0,
+				in:     make(chan []byte, 1),
+				okay:   make(chan struct{}, 1),
+				closed: make(chan struct{}),
+			}
+			streams[s.local] = s
+			next++
+			w.send(message{cmdOKAY, s.local, s.remote, nil})
+			go func() {
+				d.handle(service, s)
+				s.Close()
+			}()
+			continue
+		}
+		s := streams[m.arg1]
+		if s == nil {
+			continue
+		}
+		switch m.command {
+		case cmdWRTE:
+			s.in <- m.data
+		case cmdOKAY:
+			select {
+			case s.okay <- struct{}{}:
+			default:
+			}
+		case cmdCLSE:
+			delete(streams, m.arg1)
+			close(s.in)
+			close(s.closed)
+		}
+	}
+}
+
+func (d *fakeDevice) receivedPublicKey() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.publicKey
+}
+
+// fakeStream is the device end of a stream. Like adbd it acknowledges a payload only once
+// it has been read.
+type fakeStream struct {
+	w             *Conn
+	local, remote uint32
+	in            chan []byte
+	okay          chan struct{}
+	closed        chan struct{}
+	buf           []byte
+}
+
+func (s *fakeStream) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		d, ok := <-s.in
+		if !ok {
+			return 0, io.EOF
+		}
+		s.buf = d
+		s.w.send(message{cmdOKAY, s.local, s.remote, nil})
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *fakeStream) Write(p []byte) (int, error) {
+	if err := s.w.send(message{cmdWRTE, s.local, s.remote, p}); err != nil {
+		return 0, err
+	}
+	select {
+	case <-s.okay:
+		return len(p), nil
+	case <-s.closed:
+		return 0, io.ErrClosedPipe
+	case <-time.After(5 * time.Second):
+		return 0, fmt.Errorf("no OKAY for WRTE")
+	}
+}
+
+func (s *fakeStream) Close() {
+	s.w.send(message{cmdCLSE, s.local, s.remote, nil})
+}
+
+// shellV2 answers a shell,v2 service with stdout and stderr packets and an exit status.
+func shellV2(w io.Writer, stdout, stderr string, status byte) {
+	packet := func(id byte, data []byte) {
+		buf := make([]byte, 5+len(data))
+		buf[0] = id
+		binary.LittleEndian.PutUint32(buf[1:], uint32(len(data)))
+		copy(buf[5:], data)
+		w.Write(buf)
+	}
+	if stdout != "" {
+		packet(shellStdout, []byte(stdout))
+	}
+	if stderr != "" {
+		packet(shellStderr, []byte(stderr))
+	}
+	packet(shellExit, []byte{status})
+}
+
+func TestSignTokenVector(t *testing.T) {
+	want, err := os.ReadFile(filepath.Join("testdata", "token.sig"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signToken(loadTestKey(t), []byte(testToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sig, want) {
+		t.Errorf("signToken does not match the openssl signature")
+	}
+}
+
+func TestAndroidPublicKeyVector(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "adbkey.pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _, _ := strings.Cut(string(data), " ")
+	pub, err := AndroidPublicKey(&loadTestKey(t).PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, comment, _ := strings.Cut(pub, " ")
+	if got != want {
+		t.Errorf("AndroidPublicKey =\n%s\nwant\n%s", got, want)
+	}
+	if !strings.Contains(comment, "@") {
+		t.Errorf("public key comment %q is not user@host", comment)
+	}
+}
+
+func TestLoadOrCreateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".android", "adbkey")
+	key, err := LoadOrCreateKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := LoadOrCreateKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(again) {
+		t.Errorf("the saved key does not load back")
+	}
+	pub, err := os.ReadFile(path + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := AndroidPublicKey(&key.PublicKey); strings.TrimSpace(string(pub)) != want {
+		t.Errorf("adbkey.pub does not hold the key's public half")
+	}
+}
+
+func TestReadMessageChecksum(t *testing.T) {
+	encode := func(command uint32, data []byte, sum, magic uint32) []byte {
+		buf := make([]byte, headerSize+len(data))
+		binary.LittleEndian.PutUint32(buf[0:], command)
+		binary.LittleEndian.PutUint32(buf[4:], 1)
+		binary.LittleEndian.PutUint32(buf[8:], 2)
+		binary.LittleEndian.PutUint32(buf[12:], uint32(len(data)))
+		binary.LittleEndian.PutUint32(buf[16:], sum)
+		binary.LittleEndian.PutUint32(buf[20:], magic)
+		copy(buf[headerSize:], data)
+		return buf
+	}
+	data := []byte("payload")
+	for _, tc := range []struct {
+		name    string
+		raw     []byte
+		wantErr string
+	}{
+		{"checksum", encode(cmdWRTE, data, checksum(data), cmdWRTE^0xffffffff), ""},
+		{"no checksum", encode(cmdWRTE, data, 0, cmdWRTE^0xffffffff), ""},
+		{"wrong checksum", encode(cmdWRTE, data, checksum(data)+1, cmdWRTE^0xffffffff), "checksum mismatch"},
+		{"wrong magic", encode(cmdWRTE, data, 0, cmdWRTE), "corrupt message header"},
+		{"truncated", encode(cmdWRTE, data, 0, cmdWRTE^0xffffffff)[:headerSize+3], "unexpected EOF"},
+	} {
+		m, err := readMessage(bytes.NewReader(tc.raw))
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%s: err = %v, want %q", tc.name, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if m.command != cmdWRTE || m.arg0 != 1 || m.arg1 != 2 || string(m.data) != "payload" {
+			t.Errorf("%s: decoded %+v", tc.name, m)
+		}
+	}
+}
+
+func TestHandshakeWithoutAuth(t *testing.T) {
+	d := &fakeDevice{t: t, banner: testBanner, maxPayload: 4096}
+	c := d.dial()
+	dev := c.Device()
+	if dev.State != "device" {
+		t.Errorf("State = %q", dev.State)
+	}
+	if dev.Properties["ro.product.model"] != "redroid13_x86_64" {
+		t.Errorf("Properties = %v", dev.Properties)
+	}
+	if !dev.Features["shell_v2"] || dev.Features["abb"] {
+		t.Errorf("Features = %v", dev.Features)
+	}
+	if c.maxPayload != 4096 {
+		t.Errorf("maxPayload = %d, want the device's 4096", c.maxPayload)
+	}
+}
+
+func TestAuthWithTrustedKey(t *testing.T) {
+	key := loadTestKey(t)
+	d := &fakeDevice{t: t, banner: testBanner, maxPayload: maxPayload, authKey: &key.PublicKey, trusted: true}
+	d.dial()
+	if pub := d.receivedPublicKey(); pub != "" {
+		t.Errorf("the host sent its public key to a device that trusts it")
+	}
+}
+
+func TestAuthFallsBackToPublicKey(t *testing.T) {
+	key := loadTestKey(t)
+	d := &fakeDevice{t: t, banner: testBanner, maxPayload: maxPayload, authKey: &key.PublicKey}
+	d.dial()
+	want, _ := AndroidPublicKey(&key.PublicKey)
+	if got := d.receivedPublicKey(); got != want {
+		t.Errorf("device received public key %q, want %q", got, want)
+	}
+}
+
+func TestAuthRejected(t *testing.T) {
+	key := loadTestKey(t)
+	d := &fakeDevice{t: t, banner: testBanner, maxPayload: maxPayload, authKey: &key.PublicKey, reject: true}
+	_, err := Dial(d.start(), key)
+	if err == nil || !strings.Contains(err.Error(), "rejected the key") {
+		t.Errorf("err = %v, want the key to be rejected", err)
+	}
+}
+
+func TestExecShellV2(t *testing.T) {
+	d := &fakeDevice{t: t, banner: testBanner, maxPayload: maxPayload}
+	d.handle = func(service string, s *fakeStream) {
+		if service != "shell,v2,raw:ls /nope" {
+			t.Errorf("service = %q", service)
+			return
+		}
+		shellV2(s, "partial\n", "ls: /nope: No such file or directory\n", 1)
+	}
+	c := d.dial()
+
+	var stdout, stderr bytes.Buffer
+	status, err := c.Exec("ls /nope", &stdout, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 1 || stdout.String() != "partial\n" || !strings.Contains(stderr.String(), "No such file") {
+		t.Errorf("status %d, stdout %q, stderr %q", status, stdout.String(), stderr.String())
+	}
+}
+
+func TestShellReportsExitStatus(t *testing.T) {
+	d := &fakeDevice{t: t, banner: testBanner, maxPayload: maxPayload}
+	d.handle = func(service string, s *fakeStream) {
+		shellV2(s, "", "denied\n", 13)
+	}
+	c := d.dial()
+	_, err := c.Shell("cat /data/secret")
+	if err == nil || !strings.Contains(err.Error(), "status 13: denied") {
+		t.Errorf("err = %v, want the exit status and stderr", err)
+	}
+}
+
+func TestExecWithoutShellV2(t *testing.T) {
+	d := &fakeDevice{t: t, banner: "device::ro.product.model=old;features=cmd", maxPayload: maxPayload}
+	d.handle = func(service string, s *fakeStream) {
+		if service != "shell:getprop 'ro.build.version.sdk'" {
+			t.Errorf("service = %q", service)
+		}
+		s.Write([]byte("23\n"))
+	}
+	c := d.dial()
+	sdk, err := c.Getprop("ro.build.version.sdk")
+	if err != nil || sdk != "23" {
+		t.Errorf("Getprop = %q, %v", sdk, err)
+	}
+}
+
+// TestStreamQueuesEarlyWrites has the device send several payloads without waiting for
+// the OKAYs in between; none may be lost.
+func TestStreamQueuesEarlyWrites(t *testing.T) {
+	d := &fakeDevice{t: t, banner: testBanner, maxPayload: maxPayload}
+	d.handle = func(service string, s *fakeStream) {
+		for _, part := range []string{"one ", "two ", "three"} {
+			s.w.send(message{cmdWRTE, s.local, s.remote, []byte(part)})
+		}
+	}
+	c := d.dial()
+	s, err := c.Open("burst:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	data, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "one two three" {
+		t.Errorf("read %q, want every payload", data)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	d := &fakeDevice{t: t, banner: testBanner, maxPayload: maxPayload, refused: "jdwp:1"}
+	d.handle = func(string, *fakeStream) {}
+	c := d.dial()
+
+	if _, err := c.Open("jdwp:1"); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Errorf("Open of a refused service: err = %v", err)
+	}
+	// A service that ends right away is open and reads EOF.
+	s, err := c.Open("nothing:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Read = %d, %v; want EOF", n, err)
+	}
+}
+
+// fakeFile is a file or directory served by serveSync.
+type fakeFile struct {
+	mode  uint32
+	data  []byte
+	mtime uint32
+}
+
+// serveSync implements the device side of the sync: service over files.
+func serveSync(t *testing.T, s *fakeStream, mu *sync.Mutex, files map[string]*fakeFile) {
+	r := bufio.NewReader(s)
+	header := func(id string, n uint32) []byte {
+		buf := make([]byte, 8)
+		copy(buf, id)
+		binary.LittleEndian.PutUint32(buf[4:], n)
+		return buf
+	}
+	words := func(ws ...uint32) []byte {
+		buf := make([]byte, 4*len(ws))
+		for i, w := range ws {
+			binary.LittleEndian.PutUint32(buf[4*i:], w)
+		}
+		return buf
+	}
+	request := func() (string, []byte, error) {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return "", nil, err
+		}
+		id, n := string(hdr[:4]), binary.LittleEndian.Uint32(hdr[4:])
+		if id == "DONE" {
+			return id, words(n), nil // DONE carries the mtime, not a length
+		}
+		data := make([]byte, n)
+		_, err := io.ReadFull(r, data)
+		return id, data, err
+	}
+
+	for {
+		id, arg, err := request()
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		switch id {
+		case "STAT":
+			f := files[string(arg)]
+			if f == nil {
+				f = &fakeFile{}
+			}
+			s.Write(append(header("STAT", f.mode), words(uint32(len(f.data)), f.mtime)...))
+		case "LIST":
+			var names []string
+			for p := range files {
+				if path.Dir(p) == string(arg) && p != string(arg) {
+					names = append(names, p)
+				}
+			}
+			sort.Strings(names)
+			var out []byte
+			for _, name := range append([]string{".", ".."}, names...) {
+				f := files[name]
+				if f == nil {
+					f = &fakeFile{mode: 040755}
+				}
+				base := path.Base(name)
+				out = append(out, header("DENT", f.mode)...)
+				out = append(out, words(uint32(len(f.data)), f.mtime, uint32(len(base)))...)
+				out = append(out, base...)
+			}
+			out = append(out, header("DONE", 0)...)
+			out = append(out, words(0, 0, 0)...)
+			s.Write(out)
+		case "SEND":
+			name, mode, _ := strings.Cut(string(arg), ",")
+			f := &fakeFile{}
+			fmt.Sscan(mode, &f.mode)
+			mu.Unlock()
+			for {
+				id, data, err := request()
+				if err != nil {
+					return
+				}
+				if id == "DONE" {
+					f.mtime = binary.LittleEndian.Uint32(data)
+					break
+				}
+				if id != "DATA" || len(data) > syncChunk {
+					t.Errorf("SEND got %s with %d bytes", id, len(data))
+				}
+				f.data = append(f.data, data...)
+			}
+			mu.Lock()
+			files[name] = f
+			s.Write(header("OKAY", 0))
+		case "RECV":
+			f := files[string(arg)]
+			if f == nil {
+				msg := "No such file or directory"
+				s.Write(append(header("FAIL", uint32(len(msg))), msg...))
+				break
+			}
+			for data := f.data; len(data) > 0; {
+				n := min(len(data), syncChunk)
+				s.Write(append(header("DATA", uint32(n)), data[:n]...))
+				data = data[n:]
+			}
+			s.Write(header("DONE", 0))
+		case "QUIT":
+			mu.Unlock()
+			return
+		default:
+			t.Errorf("unexpected sync request %q", id)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestSync(t *testing.T) {
+	var mu sync.Mutex
+	files := map[string]*fakeFile{
+		"/sdcard":             {mode: 040771, mtime: 1700000000},
+		"/sdcard/notes.txt":   {mode: 0100660, data: []byte("hello"), mtime: 1700000001},
+		"/sdcard/Music":       {mode: 040771, mtime: 1700000002},
+		"/sdcard/Music/a.mp3": {mode: 0100660, data: []byte("x")},
+	}
+	d := &fakeDevice{t: t, banner: testBanner, maxPayload: 4096}
+	d.handle = func(service string, s *fakeStream) {
+		if service != "sync:" {
+			t.Errorf("service = %q", service)
+			return
+		}
+		serveSync(t, s, &mu, files)
+	}
+	c := d.dial()
+	y, err := c.OpenSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer y.Close()
+
+	st, err := y.Stat("/sdcard/notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Name != "notes.txt" || st.Mode != 0660 || st.Size != 5 || st.ModTime.Unix() != 1700000001 {
+		t.Errorf("Stat = %+v", st)
+	}
+	if st, err := y.Stat("/sdcard/missing"); err != nil || st.Mode != 0 {
+		t.Errorf("Stat of a missing path = %+v, %v; want mode 0", st, err)
+	}
+
+	entries, err := y.List("/sdcard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	if want := []string{"Music", "notes.txt"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("List = %v, want %v", names, want)
+	}
+	if !entries[0].IsDir() || entries[1].IsDir() {
+		t.Errorf("List modes = %v, %v", entries[0].Mode, entries[1].Mode)
+	}
+
+	// Larger than both syncChunk and the transport's max payload.
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 10000)
+	mtime := time.Unix(1700000100, 0)
+	sent := 0
+	if err := y.Push(bytes.NewReader(payload), "/sdcard/big.bin", 0640, mtime, func(n int) { sent += n }); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	got := files["/sdcard/big.bin"]
+	mu.Unlock()
+	if got == nil || !bytes.Equal(got.data, payload) || got.mode != 0100640 || got.mtime != uint32(mtime.Unix()) {
+		t.Fatalf("pushed file = %+v", got)
+	}
+	if sent != len(payload) {
+		t.Errorf("progress reported %d bytes, want %d", sent, len(payload))
+	}
+
+	var pulled bytes.Buffer
+	if err := y.Pull("/sdcard/big.bin", &pulled, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pulled.Bytes(), payload) {
+		t.Errorf("pulled %d bytes that differ from the pushed %d", pulled.Len(), len(payload))
+	}
+	if err := y.Pull("/sdcard/missing", io.Discard, nil); err == nil || !strings.Contains(err.Error(), "No such file") {
+		t.Errorf("Pull of a missing file: err = %v", err)
+	}
+}
+
+func TestInstallMultiple(t *testing.T) {
+	dir := t.TempDir()
+	apks := []string{filepath.Join(dir, "base.apk"), filepath.Join(dir, "split_config.en.apk")}
+	os.WriteFile(apks[0], []byte("base-apk"), 0644)
+	os.WriteFile(apks[1], []byte("split"), 0644)
+
+	var mu sync.Mutex
+	files := make(map[string]*fakeFile)
+	var commands []string
+	d := &fakeDevice{t: t, banner: testBanner, maxPayload: maxPayload}
+	d.handle = func(service string, s *fakeStream) {
+		if service == "sync:" {
+			serveSync(t, s, &mu, files)
+			return
+		}
+		cmd, ok := strings.CutPrefix(service, "shell,v2,raw:")
+		if !ok {
+			t.Errorf("service = %q", service)
+			return
+		}
+		mu.Lock()
+		commands = append(commands, cmd)
+		mu.Unlock()
+		switch {
+		case strings.HasPrefix(cmd, "pm install-create"):
+			shellV2(s, "Success: created install session [77]\n", "", 0)
+		case strings.HasPrefix(cmd, "pm install-write"):
+			shellV2(s, "Success: streamed 8 bytes\n", "", 0)
+		case strings.HasPrefix(cmd, "pm install-commit"):
+			shellV2(s, "Success\n", "", 0)
+		default:
+			shellV2(s, "", "", 0)
+		}
+	}
+	c := d.dial()
+
+	if err := c.Install(apks, InstallOptions{GrantPermissions: true}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"pm install-create -r -g -S 13",
+		"pm install-write -S 8 77 0_'base.apk' '/data/local/tmp/reddock-0-base.apk'",
+		"pm install-write -S 5 77 1_'split_config.en.apk' '/data/local/tmp/reddock-1-split_config.en.apk'",
+		"pm install-commit 77",
+		"rm -f '/data/local/tmp/reddock-0-base.apk' '/data/local/tmp/reddock-1-split_config.en.apk'",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(commands, want) {
+		t.Errorf("commands =\n%s\nwant\n%s", strings.Join(commands, "\n"), strings.Join(want, "\n"))
+	}
+	if f := files["/data/local/tmp/reddock-1-split_config.en.apk"]; f == nil || string(f.data) != "split" || f.mode != 0100644 {
+		t.Errorf("staged split = %+v", f)
+	}
+}
+
+func TestPmResult(t *testing.T) {
+	if err := pmResult("Success\n", nil); err != nil {
+		t.Errorf("Success: %v", err)
+	}
+	err := pmResult("adb: failed\nFailure [INSTALL_FAILED_VERSION_DOWNGRADE]\n", fmt.Errorf("status 1"))
+	if err == nil || err.Error() != "Failure [INSTALL_FAILED_VERSION_DOWNGRADE]" {
+		t.Errorf("err = %v, want the Failure line", err)
+	}
+}
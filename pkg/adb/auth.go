@@ -0,0 +1,139 @@
+package adb
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"reddock/pkg/sysinfo"
+)
+
+// keyBits is the RSA key size adb uses; the Android public key format is fixed to it.
+const keyBits = 2048
+
+// KeyPath is where adb keeps its private key: $ANDROID_USER_HOME/adbkey, else
+// ~/.android/adbkey. Under sudo the invoking user's key is used when root has none, so
+// devices that already trust the user's adb also trust reddock.
+func KeyPath() string {
+	if dir := os.Getenv("ANDROID_USER_HOME"); dir != "" {
+		return filepath.Join(dir, "adbkey")
+	}
+	return sysinfo.HomeFile(".android", "adbkey")
+}
+
+// LoadOrCreateKey reads the adb private key at path, or generates one (and the matching
+// adbkey.pub) the way adb does when there is none.
+func LoadOrCreateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return parsePrivateKey(data)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := AndroidPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, fmt.Errorf("Failed to save ADB key: %v", err)
+	}
+	if err := os.WriteFile(path+".pub", []byte(pub+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("Failed to save ADB public key: %v", err)
+	}
+	return key, nil
+}
+
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("ADB key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse ADB key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("ADB key is not an RSA key")
+	}
+	return key, nil
+}
+
+// signToken signs the device's AUTH token. adbd verifies it as a SHA-1 digest, although
+// the token is random bytes rather than a hash.
+func signToken(key *rsa.PrivateKey, token []byte) ([]byte, error) {
+	return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA1, token)
+}
+
+// AndroidPublicKey encodes pub in the format of adbkey.pub and /data/misc/adb/adb_keys:
+// base64 of the mincrypt RSAPublicKey struct (little-endian words: length, n0inv,
+// modulus, R^2 mod n, exponent), followed by " user@host".
+func AndroidPublicKey(pub *rsa.PublicKey) (string, error) {
+	const words = keyBits / 32
+	if pub.N.BitLen() != keyBits {
+		return "", fmt.Errorf("ADB keys must be %d-bit RSA keys", keyBits)
+	}
+	buf := make([]byte, 4*(3+2*words))
+	binary.LittleEndian.PutUint32(buf[0:], words)
+
+	// n0inv = -1 / n[0] mod 2^32
+	r32 := new(big.Int).Lsh(big.NewInt(1), 32)
+	n0 := new(big.Int).Mod(pub.N, r32)
+	inv := new(big.Int).ModInverse(n0, r32)
+	n0inv := new(big.Int).Sub(r32, inv)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(n0inv.Uint64()))
+
+	putLittleEndian(buf[8:8+4*words], pub.N)
+	rr := new(big.Int).Lsh(big.NewInt(1), 2*keyBits)
+	rr.Mod(rr, pub.N)
+	putLittleEndian(buf[8+4*words:8+8*words], rr)
+	binary.LittleEndian.PutUint32(buf[8+8*words:], uint32(pub.E))
+
+	host, _ := os.Hostname()
+	user := os.Getenv("SUDO_USER")
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	return fmt.Sprintf("%s %s@%s", base64.StdEncoding.EncodeToString(buf), user, host), nil
+}
+
+// putLittleEndian writes n into buf as a little-endian number of len(buf) bytes.
+func putLittleEndian(buf []byte, n *big.Int) {
+	be := n.FillBytes(make([]byte, len(buf)))
+	for i := range be {
+		buf[i] = be[len(be)-1-i]
+	}
+}
+
+// Connect dials addr with the user's adb key (see KeyPath), creating the key if needed.
+func Connect(addr string) (*Conn, error) {
+	key, err := LoadOrCreateKey(KeyPath())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load ADB key: %v", err)
+	}
+	return Dial(addr, key)
+}
@@ -0,0 +1,123 @@
+package adb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// stagingDir is where packages are pushed before pm installs them.
+const stagingDir = "/data/local/tmp"
+
+// InstallOptions map to pm install flags.
+type InstallOptions struct {
+	GrantPermissions bool // -g: grant all runtime permissions
+	AllowDowngrade   bool // -d: allow a lower versionCode
+}
+
+func (o InstallOptions) flags() string {
+	flags := "-r"
+	if o.GrantPermissions {
+		flags += " -g"
+	}
+	if o.AllowDowngrade {
+		flags += " -d"
+	}
+	return flags
+}
+
+var sessionIDPattern = regexp.MustCompile(`\[(\d+)\]`)
+
+// Install installs one APK, or the APKs of one split package in a single pm session
+// (what `adb install-multiple` does). The files are staged in /data/local/tmp and removed
+// afterwards.
+func (c *Conn) Install(apks []string, opts InstallOptions) error {
+	if len(apks) == 0 {
+		return fmt.Errorf("no APK given")
+	}
+	y, err := c.OpenSync()
+	if err != nil {
+		return err
+	}
+	var remote []string
+	defer func() {
+		if len(remote) > 0 {
+			c.Shell("rm -f " + quoteAll(remote))
+		}
+	}()
+	var total int64
+	for i, apk := range apks {
+		f, err := os.Open(apk)
+		if err != nil {
+			y.Close()
+			return err
+		}
+		st, err := f.Stat()
+		if err != nil {
+			f.Close()
+			y.Close()
+			return err
+		}
+		dst := fmt.Sprintf("%s/reddock-%d-%s", stagingDir, i, filepath.Base(apk))
+		err = y.Push(f, dst, 0644, st.ModTime(), nil)
+		f.Close()
+		if err != nil {
+			y.Close()
+			return fmt.Errorf("Failed to push %s: %v", apk, err)
+		}
+		remote = append(remote, dst)
+		total += st.Size()
+	}
+	y.Close()
+
+	if len(remote) == 1 {
+		return pmResult(c.Shell(fmt.Sprintf("pm install %s %s", opts.flags(), Quote(remote[0]))))
+	}
+
+	out, err := c.Shell(fmt.Sprintf("pm install-create %s -S %d", opts.flags(), total))
+	if err := pmResult(out, err); err != nil {
+		return err
+	}
+	m := sessionIDPattern.FindStringSubmatch(out)
+	if m == nil {
+		return fmt.Errorf("pm install-create did not return a session: %s", strings.TrimSpace(out))
+	}
+	session := m[1]
+	for i, path := range remote {
+		st, err := os.Stat(apks[i])
+		if err != nil {
+			return err
+		}
+		cmd := fmt.Sprintf("pm install-write -S %d %s %d_%s %s", st.Size(), session, i, Quote(filepath.Base(apks[i])), Quote(path))
+		if err := pmResult(c.Shell(cmd)); err != nil {
+			c.Shell("pm install-abandon " + session)
+			return err
+		}
+	}
+	return pmResult(c.Shell("pm install-commit " + session))
+}
+
+// pmResult turns pm output into an error unless it reports Success.
+func pmResult(out string, err error) error {
+	if strings.Contains(out, "Success") {
+		return nil
+	}
+	out = strings.TrimSpace(out)
+	if i := strings.Index(out, "Failure"); i >= 0 {
+		return fmt.Errorf("%s", out[i:])
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("pm: %s", out)
+}
+
+func quoteAll(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = Quote(a)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,101 @@
+package adb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Shell protocol (shell_v2) packet ids.
+const (
+	shellStdout = 1
+	shellStderr = 2
+	shellExit   = 3
+)
+
+// Exec runs command in the device shell, copying its output to stdout and stderr (either
+// may be nil), and returns the exit status. Devices without the shell_v2 feature (before
+// Android 7) merge stderr into stdout and always report status 0.
+func (c *Conn) Exec(command string, stdout, stderr io.Writer) (int, error) {
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	if !c.device.Features["shell_v2"] {
+		s, err := c.open("shell:" + command)
+		if err != nil {
+			return -1, err
+		}
+		defer s.Close()
+		_, err = io.Copy(stdout, s)
+		return 0, err
+	}
+
+	s, err := c.open("shell,v2,raw:" + command)
+	if err != nil {
+		return -1, err
+	}
+	defer s.Close()
+	r := bufio.NewReader(s)
+	var hdr [5]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF {
+				return -1, fmt.Errorf("shell closed without an exit status")
+			}
+			return -1, err
+		}
+		n := int64(binary.LittleEndian.Uint32(hdr[1:]))
+		switch hdr[0] {
+		case shellStdout:
+			_, err = io.CopyN(stdout, r, n)
+		case shellStderr:
+			_, err = io.CopyN(stderr, r, n)
+		case shellExit:
+			status, err := r.ReadByte()
+			if err != nil {
+				return -1, err
+			}
+			return int(status), nil
+		default:
+			_, err = io.CopyN(io.Discard, r, n)
+		}
+		if err != nil {
+			return -1, err
+		}
+	}
+}
+
+// Shell runs command and returns its output. A non-zero exit status is an error that
+// includes stderr.
+func (c *Conn) Shell(command string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	status, err := c.Exec(command, &stdout, &stderr)
+	if err != nil {
+		return stdout.String(), err
+	}
+	if status != 0 {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = strings.TrimSpace(stdout.String())
+		}
+		return stdout.String(), fmt.Errorf("'%s' exited with status %d: %s", command, status, msg)
+	}
+	return stdout.String(), nil
+}
+
+// Getprop returns a system property.
+func (c *Conn) Getprop(name string) (string, error) {
+	out, err := c.Shell("getprop " + Quote(name))
+	return strings.TrimSpace(out), err
+}
+
+// Quote quotes s for the device's /system/bin/sh.
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
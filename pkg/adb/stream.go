@@ -0,0 +1,193 @@
+package adb
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// stream is one service connection (shell:, sync:, ...) on a transport. ADB acknowledges
+// every WRTE with an OKAY, so normally at most one payload is in flight in each direction;
+// payloads a device sends ahead of the OKAY are queued rather than dropped.
+type stream struct {
+	c      *Conn
+	local  uint32
+	remote uint32
+
+	opened chan error
+	okay   chan struct{}
+	ready  chan struct{} // signalled when pending grows
+	buf    []byte
+
+	closeOnce sync.Once
+	mu        sync.Mutex
+	pending   [][]byte
+	err       error
+	done      chan struct{}
+}
+
+// Open starts service on the device (e.g. "shell:ls", "sync:") and returns its byte stream.
+func (c *Conn) Open(service string) (io.ReadWriteCloser, error) {
+	return c.open(service)
+}
+
+func (c *Conn) open(service string) (*stream, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	s := &stream{
+		c:      c,
+		local:  c.nextID,
+		opened: make(chan error, 1),
+		okay:   make(chan struct{}, 1),
+		ready:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	c.nextID++
+	c.streams[s.local] = s
+	c.mu.Unlock()
+
+	if err := c.send(message{cmdOPEN, s.local, 0, append([]byte(service), 0)}); err != nil {
+		s.forget()
+		return nil, err
+	}
+	select {
+	case err := <-s.opened:
+		if err != nil {
+			s.forget()
+			return nil, fmt.Errorf("%s: %v", service, err)
+		}
+		return s, nil
+	case <-c.closed:
+		return nil, c.err
+	}
+}
+
+func (s *stream) handle(m message) {
+	switch m.command {
+	case cmdOKAY:
+		if s.remote == 0 {
+			s.remote = m.arg0
+			s.opened <- nil
+			return
+		}
+		select {
+		case s.okay <- struct{}{}:
+		default:
+		}
+	case cmdWRTE:
+		s.mu.Lock()
+		s.pending = append(s.pending, m.data)
+		s.mu.Unlock()
+		select {
+		case s.ready <- struct{}{}:
+		default:
+		}
+	case cmdCLSE:
+		if s.remote == 0 {
+			s.opened <- fmt.Errorf("service refused by the device")
+		}
+		s.forget()
+		s.closeWith(io.EOF)
+	}
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if d, ok := s.next(); ok {
+			s.accept(d)
+			continue
+		}
+		select {
+		case <-s.ready:
+		case <-s.done:
+			// Data delivered just before the close is still readable.
+			d, ok := s.next()
+			if !ok {
+				return 0, s.closeErr()
+			}
+			s.accept(d)
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// next takes the oldest queued payload.
+func (s *stream) next() ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) == 0 {
+		return nil, false
+	}
+	d := s.pending[0]
+	s.pending = s.pending[1:]
+	return d, true
+}
+
+func (s *stream) accept(d []byte) {
+	s.buf = d
+	s.c.send(message{cmdOKAY, s.local, s.remote, nil})
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > s.c.maxPayload {
+			n = s.c.maxPayload
+		}
+		select {
+		case <-s.done:
+			return written, s.closeErr()
+		default:
+		}
+		if err := s.c.send(message{cmdWRTE, s.local, s.remote, p[:n]}); err != nil {
+			return written, err
+		}
+		select {
+		case <-s.okay:
+		case <-s.done:
+			return written, s.closeErr()
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close tells the device the stream is finished.
+func (s *stream) Close() error {
+	select {
+	case <-s.done:
+		return nil
+	default:
+	}
+	s.forget()
+	s.closeWith(io.ErrClosedPipe)
+	return s.c.send(message{cmdCLSE, s.local, s.remote, nil})
+}
+
+func (s *stream) forget() {
+	s.c.mu.Lock()
+	delete(s.c.streams, s.local)
+	s.c.mu.Unlock()
+}
+
+func (s *stream) closeWith(err error) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+		close(s.done)
+	})
+}
+
+func (s *stream) closeErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
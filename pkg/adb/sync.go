@@ -0,0 +1,247 @@
+package adb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// syncChunk is the largest DATA payload of the file sync protocol.
+const syncChunk = 64 * 1024
+
+// FileInfo is what the sync protocol reports about a device path.
+type FileInfo struct {
+	Name    string
+	Mode    os.FileMode
+	Size    int64
+	ModTime time.Time
+}
+
+// IsDir reports whether the entry is a directory.
+func (f FileInfo) IsDir() bool {
+	return f.Mode.IsDir()
+}
+
+// Sync is a file sync session ("sync:" service). It is not safe for concurrent use; open
+// one per goroutine.
+type Sync struct {
+	s *stream
+	r *bufio.Reader
+}
+
+// OpenSync starts a file sync session.
+func (c *Conn) OpenSync() (*Sync, error) {
+	s, err := c.open("sync:")
+	if err != nil {
+		return nil, err
+	}
+	return &Sync{s: s, r: bufio.NewReaderSize(s, syncChunk)}, nil
+}
+
+// Close ends the session.
+func (y *Sync) Close() error {
+	y.request("QUIT", nil)
+	return y.s.Close()
+}
+
+func (y *Sync) request(id string, data []byte) error {
+	buf := make([]byte, 8+len(data))
+	copy(buf, id)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(data)))
+	copy(buf[8:], data)
+	_, err := y.s.Write(buf)
+	return err
+}
+
+// response reads an 8-byte sync header: a 4-byte id and a little-endian word.
+func (y *Sync) response() (string, uint32, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(y.r, hdr[:]); err != nil {
+		return "", 0, err
+	}
+	return string(hdr[:4]), binary.LittleEndian.Uint32(hdr[4:]), nil
+}
+
+// failure reads the message of a FAIL response.
+func (y *Sync) failure(n uint32) error {
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(y.r, msg); err != nil {
+		return err
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// Stat returns information about remote. A missing path has Mode 0 and no error, as in
+// adb's own sync protocol.
+func (y *Sync) Stat(remote string) (FileInfo, error) {
+	if err := y.request("STAT", []byte(remote)); err != nil {
+		return FileInfo{}, err
+	}
+	id, mode, err := y.response()
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if id != "STAT" {
+		return FileInfo{}, fmt.Errorf("unexpected sync response %q", id)
+	}
+	var rest [8]byte
+	if _, err := io.ReadFull(y.r, rest[:]); err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{
+		Name:    path.Base(remote),
+		Mode:    unixMode(mode),
+		Size:    int64(binary.LittleEndian.Uint32(rest[0:])),
+		ModTime: time.Unix(int64(binary.LittleEndian.Uint32(rest[4:])), 0),
+	}, nil
+}
+
+// List returns the entries of a remote directory (without "." and "..").
+func (y *Sync) List(remote string) ([]FileInfo, error) {
+	if err := y.request("LIST", []byte(remote)); err != nil {
+		return nil, err
+	}
+	var entries []FileInfo
+	for {
+		id, mode, err := y.response()
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case "DONE":
+			// DONE carries the same 12 trailing bytes as DENT.
+			if _, err := io.CopyN(io.Discard, y.r, 12); err != nil {
+				return nil, err
+			}
+			return entries, nil
+		case "DENT":
+			var rest [12]byte
+			if _, err := io.ReadFull(y.r, rest[:]); err != nil {
+				return nil, err
+			}
+			name := make([]byte, binary.LittleEndian.Uint32(rest[8:]))
+			if _, err := io.ReadFull(y.r, name); err != nil {
+				return nil, err
+			}
+			if n := string(name); n != "." && n != ".." {
+				entries = append(entries, FileInfo{
+					Name:    n,
+					Mode:    unixMode(mode),
+					Size:    int64(binary.LittleEndian.Uint32(rest[0:])),
+					ModTime: time.Unix(int64(binary.LittleEndian.Uint32(rest[4:])), 0),
+				})
+			}
+		case "FAIL":
+			return nil, y.failure(mode)
+		default:
+			return nil, fmt.Errorf("unexpected sync response %q", id)
+		}
+	}
+}
+
+// Push writes r to the remote path with the given permission bits and modification time.
+// progress, when set, is called with the number of bytes sent in each chunk.
+func (y *Sync) Push(r io.Reader, remote string, perm os.FileMode, mtime time.Time, progress func(int)) error {
+	spec := fmt.Sprintf("%s,%d", remote, 0100000|uint32(perm.Perm()))
+	if err := y.request("SEND", []byte(spec)); err != nil {
+		return err
+	}
+	buf := make([]byte, syncChunk)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if err := y.request("DATA", buf[:n]); err != nil {
+				return err
+			}
+			if progress != nil {
+				progress(n)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	var done [8]byte
+	copy(done[:], "DONE")
+	binary.LittleEndian.PutUint32(done[4:], uint32(mtime.Unix()))
+	if _, err := y.s.Write(done[:]); err != nil {
+		return err
+	}
+	id, n, err := y.response()
+	if err != nil {
+		return err
+	}
+	switch id {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		return y.failure(n)
+	default:
+		return fmt.Errorf("unexpected sync response %q", id)
+	}
+}
+
+// Pull copies the remote file to w. progress, when set, is called with the number of
+// bytes received in each chunk.
+func (y *Sync) Pull(remote string, w io.Writer, progress func(int)) error {
+	if err := y.request("RECV", []byte(remote)); err != nil {
+		return err
+	}
+	for {
+		id, n, err := y.response()
+		if err != nil {
+			return err
+		}
+		switch id {
+		case "DATA":
+			if _, err := io.CopyN(w, y.r, int64(n)); err != nil {
+				return err
+			}
+			if progress != nil {
+				progress(int(n))
+			}
+		case "DONE":
+			return nil
+		case "FAIL":
+			return y.failure(n)
+		default:
+			return fmt.Errorf("unexpected sync response %q", id)
+		}
+	}
+}
+
+// unixMode converts st_mode bits to an os.FileMode.
+func unixMode(m uint32) os.FileMode {
+	mode := os.FileMode(m & 0777)
+	switch m & 0170000 {
+	case 0040000:
+		mode |= os.ModeDir
+	case 0120000:
+		mode |= os.ModeSymlink
+	case 0010000:
+		mode |= os.ModeNamedPipe
+	case 0140000:
+		mode |= os.ModeSocket
+	case 0020000:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case 0060000:
+		mode |= os.ModeDevice
+	}
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
@@ -0,0 +1,416 @@
+// Package bundle moves whole reddock instances between hosts: the config entry, the data
+// directory and optionally the image.
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"reddock/pkg/config"
+	"reddock/pkg/container"
+	"reddock/pkg/image"
+	"reddock/pkg/ui"
+)
+
+// Version is the instance bundle format reddock reads and writes.
+const Version = 1
+
+// An instance bundle is an uncompressed tar of the metadata, the data archive, the image
+// bundle when included, and a SHA256SUMS manifest last (so entries can be hashed while
+// they are written).
+const (
+	metadataName  = "reddock-instance.json"
+	checksumsName = "SHA256SUMS"
+	dataPrefix    = "data.tar"
+	imageName     = "image.rdimg"
+)
+
+// Metadata describes the exported instance.
+type Metadata struct {
+	Version       int              `json:"version"`
+	Created       time.Time        `json:"created"`
+	Instance      config.Container `json:"instance"`
+	ImageIncluded bool             `json:"image_included"`
+}
+
+// Export packs the instance into output. A running instance is stopped while its data is
+// archived and started again afterwards.
+func Export(name, output string, includeImage bool) error {
+	if err := container.CheckRoot(); err != nil {
+		return err
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	c := cfg.GetContainer(name)
+	if c == nil {
+		return fmt.Errorf("Container '%s' not found", name)
+	}
+	if !c.Initialized {
+		return fmt.Errorf("Container '%s' is not initialized", name)
+	}
+
+	stage, err := os.MkdirTemp(filepath.Dir(output), ".reddock-export-")
+	if err != nil {
+		return fmt.Errorf("Failed to create staging directory: %v", err)
+	}
+	defer os.RemoveAll(stage)
+
+	runtime := container.NewRuntime()
+	if runtime.IsRunning(name) {
+		fmt.Printf("Stopping '%s' for a consistent export...\n", name)
+		mgr := container.NewManagerForContainer(name)
+		if err := mgr.Stop(); err != nil {
+			return err
+		}
+		defer func() {
+			if err := mgr.Start(false); err != nil {
+				fmt.Printf("Warning: could not restart '%s': %v\n", name, err)
+			}
+		}()
+	}
+
+	if err := container.MountStorage(c); err != nil {
+		return err
+	}
+	s := ui.NewSpinner(fmt.Sprintf("Archiving %s...", c.GetDataPath()))
+	s.Start()
+	dataArchive, err := container.ArchiveDataDir(c.GetDataPath(), filepath.Join(stage, dataPrefix))
+	if err != nil {
+		s.Finish("Archiving failed")
+		return err
+	}
+	s.Finish("Data archived")
+
+	files := []string{dataArchive}
+	if includeImage {
+		imageBundle := filepath.Join(stage, imageName)
+		// The image the instance actually runs, not whatever the tag points to now.
+		if _, err := image.ExportImageBundle(runtime, c.ImageURL, c.PinnedImage(), imageBundle); err != nil {
+			return err
+		}
+		files = append(files, imageBundle)
+	}
+
+	// Host-specific state does not travel: snapshots stay behind and the GPU node is re-resolved.
+	inst := *c
+	inst.Snapshots = nil
+	inst.PreviousImage = nil
+	inst.GPUNode = ""
+	md := Metadata{Version: Version, Created: time.Now().UTC(), Instance: inst, ImageIncluded: includeImage}
+	mdData, err := json.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	partial := output + ".partial"
+	if err := writeBundle(partial, mdData, files); err != nil {
+		os.Remove(partial)
+		return err
+	}
+	if err := os.Rename(partial, output); err != nil {
+		os.Remove(partial)
+		return fmt.Errorf("Failed to write bundle: %v", err)
+	}
+	return nil
+}
+
+func writeBundle(path string, metadata []byte, files []string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Failed to create bundle: %v", err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	sums := make(map[string]string)
+
+	add := func(name string, size int64, r io.Reader) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: size, ModTime: time.Now()}); err != nil {
+			return fmt.Errorf("Failed to write bundle: %v", err)
+		}
+		h := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(tw, h), r); err != nil {
+			return fmt.Errorf("Failed to write bundle: %v", err)
+		}
+		sums[name] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	}
+
+	if err := add(metadataName, int64(len(metadata)), bytes.NewReader(metadata)); err != nil {
+		return err
+	}
+	for _, file := range files {
+		in, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		st, err := in.Stat()
+		if err == nil {
+			err = add(filepath.Base(file), st.Size(), in)
+		}
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var manifest strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&manifest, "%s  %s\n", sums[name], name)
+	}
+	hdr := &tar.Header{Name: checksumsName, Mode: 0644, Size: int64(manifest.Len()), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("Failed to write bundle: %v", err)
+	}
+	if _, err := io.WriteString(tw, manifest.String()); err != nil {
+		return fmt.Errorf("Failed to write bundle: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("Failed to write bundle: %v", err)
+	}
+	return f.Close()
+}
+
+// Import recreates the instance from a bundle under name (the exported name when empty).
+// The data directory and port are chosen for this host. The instance is initialized from
+// the image it was exported with (the embedded image, or its pinned digest); when that
+// cannot be fetched, import refuses unless allowMovedImage accepts the image the tag
+// points to now, which must still match the exported architecture and Android major version.
+func Import(path, name string, allowMovedImage bool) (*config.Container, error) {
+	if err := container.CheckRoot(); err != nil {
+		return nil, err
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open bundle: %v", err)
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != metadataName {
+		return nil, fmt.Errorf("Not a reddock instance bundle (missing %s)", metadataName)
+	}
+	h := sha256.New()
+	mdData, err := io.ReadAll(io.TeeReader(io.LimitReader(tr, 1<<20), h))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read bundle metadata: %v", err)
+	}
+	hashes := map[string]string{metadataName: hex.EncodeToString(h.Sum(nil))}
+	var md Metadata
+	if err := json.Unmarshal(mdData, &md); err != nil {
+		return nil, fmt.Errorf("Failed to parse bundle metadata: %v", err)
+	}
+	if md.Version == 0 || md.Version > Version {
+		return nil, fmt.Errorf("Unsupported bundle version %d (this reddock reads version %d)", md.Version, Version)
+	}
+
+	src := md.Instance
+	if name == "" {
+		name = src.Name
+	}
+	if cfg.GetContainer(name) != nil {
+		return nil, fmt.Errorf("Container '%s' already exists; import under another name with --name", name)
+	}
+	dataPath := config.GetDefaultDataPath(name)
+	if entries, err := os.ReadDir(dataPath); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("Data directory %s already exists and is not empty", dataPath)
+	}
+	// A failed import removes the backend again, so it must not pick up existing storage.
+	switch src.StorageBackend() {
+	case config.StorageImage:
+		target := config.Container{Name: name}
+		if _, err := os.Stat(target.StorageImagePath()); err == nil {
+			return nil, fmt.Errorf("Data image %s already exists", target.StorageImagePath())
+		}
+	case config.StorageVolume:
+		volume := container.DataVolumeName(name)
+		if err := container.NewRuntime().Command("volume", "inspect", volume).Run(); err == nil {
+			return nil, fmt.Errorf("Docker volume %s already exists", volume)
+		}
+	}
+	if arch, _, _ := strings.Cut(src.ImageArch, "/"); arch != "" {
+		if err := container.CheckImageArch(src.ImageURL, []string{arch}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dataPath), 0755); err != nil {
+		return nil, err
+	}
+	stage, err := os.MkdirTemp(filepath.Dir(dataPath), ".reddock-import-")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create staging directory: %v", err)
+	}
+	defer os.RemoveAll(stage)
+
+	s := ui.NewSpinner(fmt.Sprintf("Unpacking %s...", path))
+	s.Start()
+	var dataArchive, imageBundle string
+	var sums map[string]string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.Finish("Unpacking failed")
+			return nil, fmt.Errorf("Failed to read bundle: %v", err)
+		}
+		if hdr.Name == checksumsName {
+			data, err := io.ReadAll(io.LimitReader(tr, 1<<20))
+			if err != nil {
+				s.Finish("Unpacking failed")
+				return nil, err
+			}
+			sums = image.ParseChecksums(string(data))
+			continue
+		}
+		if hdr.Name != imageName && !strings.HasPrefix(hdr.Name, dataPrefix) {
+			continue
+		}
+		staged := filepath.Join(stage, filepath.Base(hdr.Name))
+		sum, err := stageEntry(tr, staged)
+		if err != nil {
+			s.Finish("Unpacking failed")
+			return nil, err
+		}
+		hashes[hdr.Name] = sum
+		if hdr.Name == imageName {
+			imageBundle = staged
+		} else {
+			dataArchive = staged
+		}
+	}
+	if sums == nil || dataArchive == "" {
+		s.Finish("Unpacking failed")
+		return nil, fmt.Errorf("Bundle is incomplete (missing %s or the data archive)", checksumsName)
+	}
+	for entry, sum := range hashes {
+		if sums[entry] != sum {
+			s.Finish("Unpacking failed")
+			return nil, fmt.Errorf("Checksum mismatch for %s; the bundle is corrupt", entry)
+		}
+	}
+	s.Finish("Bundle verified")
+
+	// The data goes back into the same kind of backend, so an image-backed instance keeps
+	// its size cap.
+	opts := container.InitOptions{
+		SecurityProfile: src.SecurityProfile,
+		GPUMode:         src.GPUMode,
+		Storage:         src.Storage,
+		StorageSize:     src.StorageSize,
+	}
+	if src.BinderMode == config.BinderModeShared {
+		opts.BinderMode = config.BinderModeShared
+	}
+	runtime := container.NewRuntime()
+	if imageBundle != "" {
+		loaded, err := image.ImportBundle(runtime, imageBundle, false)
+		if err != nil {
+			return nil, err
+		}
+		if loaded.ID != "" {
+			opts.PinnedImage = loaded.ID
+		}
+		opts.LocalImage = true
+	} else if pinned := src.PinnedImage(); pinned != src.ImageURL {
+		// Restore onto the build the data came from, not wherever the tag points now.
+		if _, err := image.InspectOrPull(runtime, pinned); err == nil {
+			opts.PinnedImage = pinned
+			opts.LocalImage = true
+		} else if !allowMovedImage {
+			return nil, fmt.Errorf("The image the instance was exported with (%s) is not available: %v. "+
+				"Re-export with --image, or pass --allow-moved-image to restore onto the current %s", pinned, err, src.ImageURL)
+		} else {
+			fmt.Printf("Warning: %s is not available (%v); restoring onto the current %s as requested.\n", pinned, err, src.ImageURL)
+		}
+	}
+
+	if err := container.NewInitializer(name, src.ImageURL, opts).Initialize(); err != nil {
+		forget(name)
+		return nil, err
+	}
+	cfg, err = config.Load()
+	if err != nil {
+		return nil, err
+	}
+	c := cfg.GetContainer(name)
+	if c == nil {
+		return nil, fmt.Errorf("Container '%s' was not initialized", name)
+	}
+
+	if major(c.AndroidVersion) != major(src.AndroidVersion) && c.AndroidVersion != "" && src.AndroidVersion != "" {
+		forget(name)
+		return nil, fmt.Errorf("Image %s is now Android %s but the exported data is from Android %s; "+
+			"re-export with --image or import on a host that has the original image", src.ImageURL, c.AndroidVersion, src.AndroidVersion)
+	}
+
+	s = ui.NewSpinner(fmt.Sprintf("Restoring data into %s...", c.GetDataPath()))
+	s.Start()
+	if err := container.ExtractDataDir(dataArchive, c.GetDataPath()); err != nil {
+		s.Finish("Restore failed")
+		forget(name)
+		return nil, err
+	}
+	s.Finish("Data restored")
+
+	if c.Port != src.Port {
+		fmt.Printf("ADB port changed from %d to %d on this host\n", src.Port, c.Port)
+	}
+	return c, nil
+}
+
+func stageEntry(r io.Reader, path string) (string, error) {
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, h), r); err != nil {
+		return "", fmt.Errorf("Failed to unpack %s: %v", filepath.Base(path), err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), out.Close()
+}
+
+// forget drops a half-imported instance from the config and removes its data backend,
+// which Import required to be empty, along with anything partially restored into it.
+func forget(name string) {
+	cfg, err := config.Load()
+	if err != nil {
+		return
+	}
+	if c := cfg.GetContainer(name); c != nil {
+		container.RemoveStorage(c)
+		cfg.RemoveContainer(name)
+		config.Save(cfg)
+	}
+}
+
+func major(version string) string {
+	m, _, _ := strings.Cut(version, ".")
+	return m
+}
@@ -0,0 +1,227 @@
+package config
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CatalogVersion is the catalog file format reddock reads and writes.
+const CatalogVersion = 1
+
+// SystemCatalogPath is the host-wide catalog shared by all users.
+const SystemCatalogPath = "/etc/reddock/catalog.json"
+
+// Catalog sources, in merge order (later ones override earlier entries with the same URL).
+const (
+	CatalogSourceBuiltin = "builtin"
+	CatalogSourceSystem  = "system"
+	CatalogSourceUser    = "user"
+)
+
+//go:embed catalog.json
+var builtinCatalog []byte
+
+// CatalogImage describes one image offered by `reddock init`.
+type CatalogImage struct {
+	Name           string   `json:"name"`
+	URL            string   `json:"url"`
+	AndroidVersion string   `json:"android_version,omitempty"`
+	Arch           []string `json:"arch,omitempty"`
+	Is64Only       bool     `json:"64only,omitempty"`
+	GApps          bool     `json:"gapps,omitempty"`
+	Magisk         bool     `json:"magisk,omitempty"`
+	NativeBridge   string   `json:"native_bridge,omitempty"`
+	BootArgs       []string `json:"boot_args,omitempty"`
+	// Removed hides an entry from a lower-priority catalog.
+	Removed bool `json:"removed,omitempty"`
+
+	Source string `json:"-"`
+}
+
+type Catalog struct {
+	Version int            `json:"version"`
+	Images  []CatalogImage `json:"images"`
+}
+
+// GetUserCatalogPath is the per-user catalog edited by `reddock image catalog`.
+func GetUserCatalogPath() string {
+	return filepath.Join(GetConfigDir(), "catalog.json")
+}
+
+// SupportsArch reports whether the image runs on arch; entries without arch info match all.
+func (img CatalogImage) SupportsArch(arch string) bool {
+	if len(img.Arch) == 0 {
+		return true
+	}
+	for _, a := range img.Arch {
+		if a == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// Features is a short comma-separated description of the image flags.
+func (img CatalogImage) Features() string {
+	var f []string
+	if img.Is64Only {
+		f = append(f, "64only")
+	}
+	if img.GApps {
+		f = append(f, "gapps")
+	}
+	if img.Magisk {
+		f = append(f, "magisk")
+	}
+	if img.NativeBridge != "" {
+		f = append(f, img.NativeBridge)
+	}
+	return strings.Join(f, ",")
+}
+
+// ParseCatalog decodes and validates a catalog file.
+func ParseCatalog(data []byte) (*Catalog, error) {
+	var cat Catalog
+	if err := json.Unmarshal(data, &cat); err != nil {
+		return nil, fmt.Errorf("Failed to parse catalog: %v", err)
+	}
+	if cat.Version == 0 || cat.Version > CatalogVersion {
+		return nil, fmt.Errorf("Unsupported catalog version %d (this reddock reads version %d)", cat.Version, CatalogVersion)
+	}
+	for _, img := range cat.Images {
+		if img.URL == "" {
+			return nil, fmt.Errorf("Catalog entry %q has no url", img.Name)
+		}
+	}
+	return &cat, nil
+}
+
+// ReadCatalogFile loads a catalog file; a missing file is an empty catalog.
+func ReadCatalogFile(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Catalog{Version: CatalogVersion}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read catalog %s: %v", path, err)
+	}
+	cat, err := ParseCatalog(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cat, nil
+}
+
+// WriteCatalogFile saves cat to path, creating parent directories.
+func WriteCatalogFile(path string, cat *Catalog) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("Failed to create catalog directory: %v", err)
+	}
+	cat.Version = CatalogVersion
+	data, err := json.MarshalIndent(cat, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to marshal catalog: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("Failed to write catalog: %v", err)
+	}
+	catalogOnce = sync.Once{}
+	return nil
+}
+
+type catalogLayer struct {
+	source string
+	cat    *Catalog
+}
+
+var (
+	catalogOnce   sync.Once
+	catalogImages []CatalogImage
+)
+
+// LoadCatalog merges the built-in catalog with the system and user files. Unreadable
+// files are reported as warnings and skipped so a broken catalog never blocks init. The
+// result is read once per process (WriteCatalogFile invalidates it); callers get a copy.
+func LoadCatalog() []CatalogImage {
+	catalogOnce.Do(func() { catalogImages = loadCatalog() })
+	return append([]CatalogImage(nil), catalogImages...)
+}
+
+func loadCatalog() []CatalogImage {
+	builtin, err := ParseCatalog(builtinCatalog)
+	if err != nil {
+		panic(fmt.Sprintf("built-in catalog is invalid: %v", err))
+	}
+	layers := []catalogLayer{{CatalogSourceBuiltin, builtin}}
+
+	for _, f := range []struct{ source, path string }{
+		{CatalogSourceSystem, SystemCatalogPath},
+		{CatalogSourceUser, GetUserCatalogPath()},
+	} {
+		cat, err := ReadCatalogFile(f.path)
+		if err != nil {
+			fmt.Printf("Warning: ignoring %s catalog: %v\n", f.source, err)
+			continue
+		}
+		layers = append(layers, catalogLayer{f.source, cat})
+	}
+
+	var merged []CatalogImage
+	index := make(map[string]int)
+	for _, layer := range layers {
+		for _, img := range layer.cat.Images {
+			img.Source = layer.source
+			if i, ok := index[img.URL]; ok {
+				merged[i] = img
+				continue
+			}
+			index[img.URL] = len(merged)
+			merged = append(merged, img)
+		}
+	}
+
+	var images []CatalogImage
+	for _, img := range merged {
+		if !img.Removed {
+			images = append(images, img)
+		}
+	}
+	return images
+}
+
+// LookupCatalogImage returns the merged catalog entry for imageURL.
+func LookupCatalogImage(imageURL string) (CatalogImage, bool) {
+	for _, img := range LoadCatalog() {
+		if img.URL == imageURL {
+			return img, true
+		}
+	}
+	return CatalogImage{}, false
+}
+
+// Upsert adds img or replaces the entry with the same URL.
+func (cat *Catalog) Upsert(img CatalogImage) {
+	for i := range cat.Images {
+		if cat.Images[i].URL == img.URL {
+			cat.Images[i] = img
+			return
+		}
+	}
+	cat.Images = append(cat.Images, img)
+}
+
+// Remove drops the entry for url and reports whether it was present.
+func (cat *Catalog) Remove(url string) bool {
+	for i := range cat.Images {
+		if cat.Images[i].URL == url {
+			cat.Images = append(cat.Images[:i], cat.Images[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
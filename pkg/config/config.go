@@ -5,53 +5,103 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"reddock/pkg/registry"
 )
 
 const (
 	DefaultGPUMode = "auto"
+
+	// GPUModeHost renders on a host DRM render node passed into the container.
+	GPUModeHost = "host"
+	// GPUModeGuest renders in software inside the container.
+	GPUModeGuest = "guest"
+	// GPUModeAuto uses host rendering when a hardware render node exists, else guest.
+	GPUModeAuto = "auto"
+
+	// BinderModeShared uses the host's /dev/binder* nodes (shared by every instance).
+	BinderModeShared = "shared"
+	// BinderModeBinderFS gives the instance its own binderfs mount and devices.
+	BinderModeBinderFS = "binderfs"
+
+	// SecurityProfilePrivileged runs the container with --privileged (default).
+	SecurityProfilePrivileged = "privileged"
+	// SecurityProfileRestricted passes only the devices, capabilities and sysctls redroid needs.
+	SecurityProfileRestricted = "restricted"
 )
 
-type RedroidImage struct {
-	Name      string
-	URL       string
-	Is64Only  bool
-	IsARMOnly bool
-}
-
-var AvailableImages = []RedroidImage{
-	{"Android 8.1", "redroid/redroid:8.1.0-latest", false, false},
-	{"Android 9", "redroid/redroid:9.0.0-latest", false, false},
-	{"Android 10", "redroid/redroid:10.0.0-latest", false, false},
-	{"Android 11", "redroid/redroid:11.0.0-latest", false, false},
-	{"Android 11 (64bit only)", "redroid/redroid:11.0.0_64only-latest", true, false},
-	{"Android 11 (ARM64 only)", "abing7k/redroid:a11_arm", false, true},
-	{"Android 11 (Magisk - ARM64)", "abing7k/redroid:a11_magisk_arm", false, true},
-	{"Android 11 (GApps - ARM64)", "abing7k/redroid:a11_gapps_arm", false, true},
-	{"Android 11 (GApps & Magisk - ARM64)", "abing7k/redroid:a11_gapps_magisk_arm", false, true},
-	{"Android 11 (LibNDK only - AMD64/x86_64)", "abing7k/redroid:a11_ndk_amd", true, false},
-	{"Android 11 (Magisk & LibNDK - AMD64/x86_64)", "abing7k/redroid:a11_magisk_ndk_amd", true, false},
-	{"Android 11 (GApps & LibNDK - AMD64/x86_64)", "abing7k/redroid:a11_gapps_ndk_amd", true, false},
-	{"Android 11 (GApps & Magisk & LibNDK - AMD64/x86_64)", "abing7k/redroid:a11_gapps_magisk_ndk_amd", true, false},
-	{"Android 11 (GApps & Libhoudini - AMD64/x86_64)", "teddynight/redroid:latest", true, false},
-	{"Android 11 (NDK ChromeOS - AMD64/x86_64)", "erstt/redroid:11.0.0_ndk_ChromeOS", true, false},
-	{"Android 12", "redroid/redroid:12.0.0-latest", false, false},
-	{"Android 12 (64bit only)", "redroid/redroid:12.0.0_64only-latest", true, false},
-	{"Android 12 (Fahaddz - GApps & Magisk)", "fahaddz/redroid:13", false, false},
-	{"Android 12 (NDK ChromeOS - AMD64/x86_64)", "erstt/redroid:12.0.0_ndk_ChromeOS", true, false},
-	{"Android 13", "redroid/redroid:13.0.0-latest", false, false},
-	{"Android 13 (64bit only)", "redroid/redroid:13.0.0_64only-latest", true, false},
-	{"Android 13 (NDK ChromeOS - AMD64/x86_64)", "erstt/redroid:13.0.0_ndk_ChromeOS", true, false},
+type Container struct {
+	Name            string `json:"name"`
+	ImageURL        string `json:"image_url"`
+	ImageDigest     string `json:"image_digest,omitempty"`
+	ImageID         string `json:"image_id,omitempty"`
+	ImageArch       string `json:"image_arch,omitempty"`
+	AndroidVersion  string `json:"android_version,omitempty"`
+	DataPath        string `json:"data_path"`
+	LogFile         string `json:"log_file"`
+	Port            int    `json:"port"`
+	GPUMode         string `json:"gpu_mode"`
+	GPUNode         string `json:"gpu_node,omitempty"`
+	BinderMode      string `json:"binder_mode,omitempty"`
+	SecurityProfile string `json:"security_profile,omitempty"`
+	Initialized     bool   `json:"initialized"`
+
+	// Storage is the /data backend (StorageDir when empty). Volume is the Docker volume of
+	// StorageVolume instances and StorageSize the fixed size in bytes of StorageImage ones.
+	Storage     string `json:"storage,omitempty"`
+	Volume      string `json:"volume,omitempty"`
+	StorageSize int64  `json:"storage_size,omitempty"`
+
+	// PreviousImage is what the instance ran before its last upgrade.
+	PreviousImage *ImageRecord `json:"previous_image,omitempty"`
+	Snapshots     []Snapshot   `json:"snapshots,omitempty"`
 }
 
-type Container struct {
-	Name        string `json:"name"`
-	ImageURL    string `json:"image_url"`
-	DataPath    string `json:"data_path"`
-	LogFile     string `json:"log_file"`
-	Port        int    `json:"port"`
-	GPUMode     string `json:"gpu_mode"`
-	Initialized bool   `json:"initialized"`
+// ImageRecord is one image an instance ran, plus the ID of the data snapshot taken before
+// it was replaced (upgrade rollback restores both).
+type ImageRecord struct {
+	ImageURL       string    `json:"image_url"`
+	ImageDigest    string    `json:"image_digest,omitempty"`
+	ImageID        string    `json:"image_id,omitempty"`
+	ImageArch      string    `json:"image_arch,omitempty"`
+	AndroidVersion string    `json:"android_version,omitempty"`
+	Snapshot       string    `json:"snapshot,omitempty"`
+	ReplacedAt     time.Time `json:"replaced_at"`
+}
+
+// Storage backends for /data: a host directory bind mount, a Docker named volume, or a
+// sparse ext4 image file loop-mounted at the data path (fixed size).
+const (
+	StorageDir    = "dir"
+	StorageVolume = "volume"
+	StorageImage  = "image"
+)
+
+// DefaultStorageSize is the size of image-backed data volumes when none is given.
+const DefaultStorageSize int64 = 16 << 30
+
+// Snapshot methods: a compressed tar archive, or a native filesystem snapshot when the data
+// directory is a btrfs subvolume or a ZFS dataset.
+const (
+	SnapshotMethodTar   = "tar"
+	SnapshotMethodBtrfs = "btrfs"
+	SnapshotMethodZFS   = "zfs"
+)
+
+// Snapshot is a saved copy of an instance's data directory.
+type Snapshot struct {
+	ID             string    `json:"id"`
+	Created        time.Time `json:"created"`
+	Method         string    `json:"method"`
+	Location       string    `json:"location"` // archive file, subvolume path or dataset@snapshot
+	Size           int64     `json:"size,omitempty"`
+	Label          string    `json:"label,omitempty"`
+	ImageURL       string    `json:"image_url,omitempty"`
+	AndroidVersion string    `json:"android_version,omitempty"`
 }
 
 type Config struct {
@@ -72,6 +122,11 @@ func GetDefaultDataPath(containerName string) string {
 	return filepath.Join(home, "data-"+containerName)
 }
 
+// GetLogDir is the instance's log directory (saved logcat and container logs).
+func GetLogDir(containerName string) string {
+	return filepath.Join(GetConfigDir(), "logs", containerName)
+}
+
 func GetDefault() *Config {
 	return &Config{
 		Containers: make(map[string]*Container),
@@ -122,6 +177,19 @@ func Save(cfg *Config) error {
 	return nil
 }
 
+// StorageBackend returns the /data backend, treating an unset value as StorageDir.
+func (c *Container) StorageBackend() string {
+	if c.Storage == "" {
+		return StorageDir
+	}
+	return c.Storage
+}
+
+// StorageImagePath is the ext4 image file of StorageImage instances, next to the data path.
+func (c *Container) StorageImagePath() string {
+	return strings.TrimRight(c.GetDataPath(), "/") + ".img"
+}
+
 func (c *Container) GetDataPath() string {
 	if c.DataPath != "" {
 		return c.DataPath
@@ -129,6 +197,59 @@ func (c *Container) GetDataPath() string {
 	return GetDefaultDataPath(c.Name)
 }
 
+// LogFilePath is where container logs are saved: LogFile when absolute, otherwise LogFile
+// (default <name>.log) in the instance's log directory.
+func (c *Container) LogFilePath() string {
+	name := c.LogFile
+	if name == "" {
+		name = c.Name + ".log"
+	}
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(GetLogDir(c.Name), name)
+}
+
+// CurrentImage captures the image fields of the instance.
+func (c *Container) CurrentImage() ImageRecord {
+	return ImageRecord{
+		ImageURL:       c.ImageURL,
+		ImageDigest:    c.ImageDigest,
+		ImageID:        c.ImageID,
+		ImageArch:      c.ImageArch,
+		AndroidVersion: c.AndroidVersion,
+	}
+}
+
+// SetImage switches the instance's image fields to r.
+func (c *Container) SetImage(r ImageRecord) {
+	c.ImageURL = r.ImageURL
+	c.ImageDigest = r.ImageDigest
+	c.ImageID = r.ImageID
+	c.ImageArch = r.ImageArch
+	c.AndroidVersion = r.AndroidVersion
+}
+
+// FindSnapshot returns the instance's snapshot with the given ID.
+func (c *Container) FindSnapshot(id string) *Snapshot {
+	for i := range c.Snapshots {
+		if c.Snapshots[i].ID == id {
+			return &c.Snapshots[i]
+		}
+	}
+	return nil
+}
+
+// RemoveSnapshot drops the snapshot record with the given ID.
+func (c *Container) RemoveSnapshot(id string) {
+	for i := range c.Snapshots {
+		if c.Snapshots[i].ID == id {
+			c.Snapshots = append(c.Snapshots[:i], c.Snapshots[i+1:]...)
+			return
+		}
+	}
+}
+
 // HostADBPort is the host-side TCP port published for ADB (maps to container 5555).
 func (c *Container) HostADBPort() int {
 	if c == nil || c.Port == 0 {
@@ -163,68 +284,159 @@ func (cfg *Config) ListContainers() []*Container {
 	return containers
 }
 
+// ReferencedImages lists every image reference instances depend on: the configured
+// reference, the pinned digest, the local image ID recorded at init and the image kept
+// for upgrade rollback.
+func (cfg *Config) ReferencedImages() []string {
+	var refs []string
+	for _, c := range cfg.Containers {
+		refs = append(refs, c.ImageURL)
+		if pinned := c.PinnedImage(); pinned != c.ImageURL {
+			refs = append(refs, pinned)
+		}
+		if c.ImageID != "" {
+			refs = append(refs, c.ImageID)
+		}
+		if prev := c.PreviousImage; prev != nil {
+			refs = append(refs, prev.ImageURL)
+			if prev.ImageID != "" {
+				refs = append(refs, prev.ImageID)
+			}
+		}
+	}
+	return refs
+}
+
+// Is64OnlyImage reports whether the image ships without 32-bit support, from the catalog
+// when the image is listed there.
 func Is64OnlyImage(imageURL string) bool {
-	return strings.Contains(imageURL, "64only") ||
-		strings.Contains(imageURL, "ndk_amd") ||
-		strings.Contains(imageURL, "ndk_ChromeOS") ||
-		strings.EqualFold(imageURL, "teddynight/redroid:latest")
+	if img, ok := LookupCatalogImage(imageURL); ok {
+		return img.Is64Only
+	}
+	return strings.Contains(imageURL, "64only")
 }
 
+// ExtractVersionFromImage returns the tag of imageURL without the "-latest" suffix. A
+// digest is not a version: repo@sha256:... gives "" and repo:tag@sha256:... gives the tag.
 func ExtractVersionFromImage(imageURL string) string {
-	parts := strings.Split(imageURL, ":")
-	if len(parts) < 2 {
+	imageURL, _, _ = strings.Cut(imageURL, "@")
+	i := strings.LastIndex(imageURL, ":")
+	if i < 0 || i < strings.LastIndex(imageURL, "/") {
 		return ""
 	}
+	return strings.TrimSuffix(imageURL[i+1:], "-latest")
+}
 
-	versionPart := parts[1]
+var androidVersionPattern = regexp.MustCompile(`\d+(\.\d+)*`)
 
-	versionPart = strings.TrimSuffix(versionPart, "-latest")
+// AndroidVersionFromImage returns the catalog's Android version for imageURL, or guesses it
+// from the tag (13.0.0-latest -> 13.0.0, a11_gapps_arm -> 11). Empty when unknown.
+func AndroidVersionFromImage(imageURL string) string {
+	if img, ok := LookupCatalogImage(imageURL); ok && img.AndroidVersion != "" {
+		return img.AndroidVersion
+	}
+	return androidVersionPattern.FindString(ExtractVersionFromImage(imageURL))
+}
+
+func ValidateGPUMode(mode string) error {
+	switch mode {
+	case GPUModeHost, GPUModeGuest, GPUModeAuto:
+		return nil
+	default:
+		return fmt.Errorf("Invalid GPU mode: %s (use %s, %s or %s)", mode, GPUModeHost, GPUModeGuest, GPUModeAuto)
+	}
+}
+
+// ValidateGPUNode rejects a render node for guest mode, which renders in software and
+// would silently ignore it.
+func ValidateGPUNode(mode, node string) error {
+	if node != "" && mode == GPUModeGuest {
+		return fmt.Errorf("--gpu-node selects a host render node and cannot be combined with GPU mode '%s'", GPUModeGuest)
+	}
+	return nil
+}
 
-	if strings.Contains(versionPart, "_") {
-		return versionPart
+func ValidateBinderMode(mode string) error {
+	switch mode {
+	case BinderModeShared, BinderModeBinderFS:
+		return nil
+	default:
+		return fmt.Errorf("Invalid binder mode: %s (use %s or %s)", mode, BinderModeShared, BinderModeBinderFS)
 	}
+}
 
-	knownVersions := []string{
-		"8.1.0",
-		"9.0.0",
-		"10.0.0",
-		"11.0.0",
-		"a11_arm",
-		"a11_magisk_arm",
-		"a11_gapps_arm",
-		"a11_gapps_magisk_arm",
-		"a11_ndk_amd",
-		"a11_magisk_ndk_amd",
-		"a11_gapps_ndk_amd",
-		"a11_gapps_magisk_ndk_amd",
-		"11.0.0_ndk_ChromeOS",
-		"12.0.0",
-		"12.0.0_64only",
-		"12.0.0_ndk_ChromeOS",
-		"13.0.0",
-		"13.0.0_64only",
-		"13.0.0_ndk_ChromeOS",
+func ValidateStorage(storage string) error {
+	switch storage {
+	case StorageDir, StorageVolume, StorageImage:
+		return nil
+	default:
+		return fmt.Errorf("Invalid storage backend: %s (use %s, %s or %s)", storage, StorageDir, StorageVolume, StorageImage)
 	}
+}
 
-	for _, v := range knownVersions {
-		if strings.HasPrefix(versionPart, v) {
-			return v
+// ParseSize reads sizes such as 512M, 16G or 1.5T (binary units; a trailing "B" or "iB"
+// is accepted). A plain number is bytes.
+func ParseSize(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "B"), "I")
+	mult := int64(1)
+	if n := len(v); n > 0 {
+		switch v[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
 		}
+		if mult > 1 {
+			v = v[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("Invalid size %q (use e.g. 512M, 16G)", s)
 	}
+	return int64(f * float64(mult)), nil
+}
 
-	return versionPart
+func ValidateSecurityProfile(profile string) error {
+	switch profile {
+	case SecurityProfilePrivileged, SecurityProfileRestricted:
+		return nil
+	default:
+		return fmt.Errorf("Invalid security profile: %s (use %s or %s)", profile, SecurityProfilePrivileged, SecurityProfileRestricted)
+	}
 }
 
-func ValidateImageName(name string) error {
-	if name == "" {
-		return fmt.Errorf("Image name cannot be empty")
+// containerNamePattern is what docker accepts for container names (also used as hostname).
+var containerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+func ValidateContainerName(name string) error {
+	if !containerNamePattern.MatchString(name) {
+		return fmt.Errorf("Invalid container name: %q (use letters, digits and . _ -, starting with a letter or digit)", name)
 	}
-	// Basic Docker image name validation (lowercase letters, digits, separators)
-	// Simplified check for now
-	for _, r := range name {
-		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '.' && r != '_' && r != '-' && r != ':' && r != '/' {
-			return fmt.Errorf("Invalid character in image name: %c. Use format: NAMESPACE/REPOSITORY[:TAG] (Avoid HOST[:PORT]/ for local images)", r)
+	return nil
+}
+
+func ValidateImageName(name string) error {
+	_, err := registry.ParseReference(name)
+	return err
+}
+
+// PinnedImage is the image reference `start` runs: the repository pinned to the digest
+// recorded at init, the local image ID for images without a registry digest, or ImageURL
+// for instances initialized before digests were recorded.
+func (c *Container) PinnedImage() string {
+	if c.ImageDigest != "" {
+		if ref, err := registry.ParseReference(c.ImageURL); err == nil {
+			return ref.Pinned(c.ImageDigest)
 		}
 	}
-	return nil
+	if c.ImageID != "" {
+		return c.ImageID
+	}
+	return c.ImageURL
 }
@@ -0,0 +1,190 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"reddock/pkg/config"
+	"reddock/pkg/sysinfo"
+)
+
+// binderfsRoot holds one private binderfs mount per instance (tmpfs-backed, gone after reboot).
+const binderfsRoot = "/run/reddock/binderfs"
+
+// binderDeviceNames are the nodes redroid expects under /dev inside the container.
+var binderDeviceNames = []string{"binder", "hwbinder", "vndbinder"}
+
+// binderCtlAdd is BINDER_CTL_ADD: _IOWR('b', 1, struct binderfs_device).
+const binderCtlAdd = 0xC1086201
+
+// binderfsDevice mirrors struct binderfs_device from <linux/android/binderfs.h>.
+type binderfsDevice struct {
+	Name  [256]byte
+	Major uint32
+	Minor uint32
+}
+
+// BinderFSMountPath is where the private binderfs instance of containerName is mounted.
+func BinderFSMountPath(containerName string) string {
+	return filepath.Join(binderfsRoot, containerName)
+}
+
+// DefaultBinderMode picks binderfs isolation when the kernel supports it, else shared host nodes.
+func DefaultBinderMode() string {
+	if sysinfo.ProbeBinderHost().BinderFSInProcFS {
+		return config.BinderModeBinderFS
+	}
+	return config.BinderModeShared
+}
+
+// SetupBinderFS mounts a private binderfs for the instance (idempotent) and makes sure the
+// binder, hwbinder and vndbinder devices exist in it.
+func SetupBinderFS(containerName string) (string, error) {
+	mnt := BinderFSMountPath(containerName)
+	if err := os.MkdirAll(mnt, 0755); err != nil {
+		return "", fmt.Errorf("Failed to create binderfs mount point: %v", err)
+	}
+
+	if !isCharDev(filepath.Join(mnt, "binder-control")) {
+		if err := syscall.Mount("binder", mnt, "binder", 0, ""); err != nil {
+			return "", fmt.Errorf("Failed to mount binderfs at %s: %v", mnt, err)
+		}
+	}
+
+	for _, name := range binderDeviceNames {
+		if isCharDev(filepath.Join(mnt, name)) {
+			continue
+		}
+		if err := addBinderDevice(mnt, name); err != nil {
+			return "", err
+		}
+	}
+	return mnt, nil
+}
+
+// TeardownBinderFS unmounts the private binderfs of the instance, if any.
+func TeardownBinderFS(containerName string) error {
+	mnt := BinderFSMountPath(containerName)
+	if isCharDev(filepath.Join(mnt, "binder-control")) {
+		if err := syscall.Unmount(mnt, syscall.MNT_DETACH); err != nil {
+			return fmt.Errorf("Failed to unmount binderfs at %s: %v", mnt, err)
+		}
+	}
+	if err := os.Remove(mnt); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// BinderDevices lists the binder devices owned by the instance. For shared mode these are the
+// host-wide nodes visible to every privileged container.
+func BinderDevices(c *config.Container) []string {
+	if c.BinderMode == config.BinderModeBinderFS {
+		mnt := BinderFSMountPath(c.Name)
+		entries, err := os.ReadDir(mnt)
+		if err != nil {
+			return nil
+		}
+		var devs []string
+		for _, e := range entries {
+			p := filepath.Join(mnt, e.Name())
+			if e.Name() == "binder-control" || !isCharDev(p) {
+				continue
+			}
+			devs = append(devs, p)
+		}
+		sort.Strings(devs)
+		return devs
+	}
+
+	var devs []string
+	for _, name := range binderDeviceNames {
+		for _, p := range []string{filepath.Join("/dev", name), filepath.Join("/dev/binderfs", name)} {
+			if isCharDev(p) {
+				devs = append(devs, p)
+			}
+		}
+	}
+	return devs
+}
+
+// binderDeviceArgs maps the instance's private binder nodes over /dev/* in the container.
+// --privileged also creates every host device node, including binder_linux's /dev/binder*;
+// bind mounts over the same paths mask those, since device nodes are only created where
+// nothing is mounted yet.
+func binderDeviceArgs(containerName string, privileged bool) []string {
+	mnt := BinderFSMountPath(containerName)
+	var args []string
+	for _, name := range binderDeviceNames {
+		node := filepath.Join(mnt, name)
+		args = append(args, "--device", fmt.Sprintf("%s:/dev/%s", node, name))
+		if privileged {
+			args = append(args, "-v", fmt.Sprintf("%s:/dev/%s", node, name))
+		}
+	}
+	return args
+}
+
+// exposedHostBinderNodes lists the host's binderfs nodes (/dev/binderfs/*) that a privileged
+// container still sees next to its private devices; /dev/binder* are masked.
+func exposedHostBinderNodes() []string {
+	entries, err := os.ReadDir("/dev/binderfs")
+	if err != nil {
+		return nil
+	}
+	var nodes []string
+	for _, e := range entries {
+		if p := filepath.Join("/dev/binderfs", e.Name()); isCharDev(p) {
+			nodes = append(nodes, p)
+		}
+	}
+	return nodes
+}
+
+// privilegedBinderWarning explains when a binderfs instance is not fully isolated because it
+// runs privileged on a host with its own binderfs nodes; "" otherwise.
+func privilegedBinderWarning(c *config.Container) string {
+	if c.BinderMode != config.BinderModeBinderFS || c.SecurityProfile == config.SecurityProfileRestricted {
+		return ""
+	}
+	nodes := exposedHostBinderNodes()
+	if len(nodes) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Warning: '%s' runs --privileged, which also exposes the host's binder nodes (%s); "+
+		"use --profile %s for full binder isolation", c.Name, strings.Join(nodes, ", "), config.SecurityProfileRestricted)
+}
+
+func addBinderDevice(mnt, name string) error {
+	ctl, err := os.OpenFile(filepath.Join(mnt, "binder-control"), os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("Failed to open binder-control: %v", err)
+	}
+	defer ctl.Close()
+
+	var dev binderfsDevice
+	copy(dev.Name[:len(dev.Name)-1], name)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ctl.Fd(), binderCtlAdd, uintptr(unsafe.Pointer(&dev)))
+	if errno != 0 && errno != syscall.EEXIST {
+		return fmt.Errorf("Failed to create binder device %q: %v", name, errno)
+	}
+	return nil
+}
+
+func isCharDev(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// FormatBinderDevices renders a device list for status output.
+func FormatBinderDevices(devs []string) string {
+	if len(devs) == 0 {
+		return "(none)"
+	}
+	return strings.Join(devs, ", ")
+}
@@ -0,0 +1,163 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"reddock/pkg/config"
+	"reddock/pkg/ui"
+)
+
+// deviceIdentityFiles are removed from a cloned /data so Android generates fresh per-device
+// identifiers on first boot: the per-app android_id (SSAID) store and adbd's keys. The
+// hostname comes from the container name and changes by itself.
+var deviceIdentityFiles = []string{
+	"system/users/*/settings_ssaid.xml",
+	"misc/adb/*",
+}
+
+// Cloner copies an initialized instance into new instances.
+type Cloner struct {
+	config  *config.Config
+	runtime Runtime
+	source  string
+}
+
+func NewCloner(source string) *Cloner {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Warning: Failed to load config: %v\n", err)
+		cfg = config.GetDefault()
+	}
+	return &Cloner{config: cfg, runtime: NewRuntime(), source: source}
+}
+
+// CloneNames returns dst for a single clone and dst-1 ... dst-N for count > 1.
+func CloneNames(dst string, count int) []string {
+	if count <= 1 {
+		return []string{dst}
+	}
+	names := make([]string, count)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s-%d", dst, i+1)
+	}
+	return names
+}
+
+// Clone creates one instance per name from the source's data. Btrfs subvolumes are cloned
+// with writable snapshots and other filesystems with `cp --reflink=auto`, so clones are
+// cheap on copy-on-write filesystems. A running source is stopped while it is copied
+// (except for btrfs snapshots, which are atomic) and started again afterwards.
+func (cl *Cloner) Clone(names []string) ([]*config.Container, error) {
+	if err := CheckRoot(); err != nil {
+		return nil, err
+	}
+	src := cl.config.GetContainer(cl.source)
+	if src == nil {
+		return nil, fmt.Errorf("Container '%s' not found", cl.source)
+	}
+	if !src.Initialized {
+		return nil, fmt.Errorf("Container '%s' is not initialized", cl.source)
+	}
+	for _, name := range names {
+		if cl.config.GetContainer(name) != nil {
+			return nil, fmt.Errorf("Container '%s' already exists", name)
+		}
+		dataPath := cloneDataPath(src, name)
+		if _, err := os.Stat(dataPath); err == nil {
+			return nil, fmt.Errorf("Data directory %s already exists", dataPath)
+		}
+	}
+
+	if err := MountStorage(src); err != nil {
+		return nil, err
+	}
+	useBtrfs := SnapshotMethodFor(src.GetDataPath()) == config.SnapshotMethodBtrfs
+	if !useBtrfs && cl.runtime.IsRunning(src.Name) {
+		fmt.Printf("Stopping '%s' while its data is copied...\n", src.Name)
+		mgr := &Manager{runtime: cl.runtime, config: cl.config, containerName: src.Name}
+		if err := mgr.Stop(); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := mgr.Start(false); err != nil {
+				fmt.Printf("Warning: could not restart '%s': %v\n", src.Name, err)
+			}
+		}()
+	}
+
+	bar := ui.NewProgressBar(len(names), fmt.Sprintf("Cloning '%s'...", src.Name))
+	bar.Start()
+	var clones []*config.Container
+	for _, name := range names {
+		bar.SetMessage(fmt.Sprintf("Cloning '%s' into '%s'", src.Name, name))
+		dataPath := cloneDataPath(src, name)
+		if err := copyDataDir(src.GetDataPath(), dataPath, useBtrfs); err != nil {
+			return clones, err
+		}
+		if err := resetDeviceIdentity(dataPath); err != nil {
+			return clones, err
+		}
+
+		clone := *src
+		clone.Name = name
+		clone.DataPath = dataPath
+		clone.LogFile = name + ".log"
+		clone.Port = nextFreePort(cl.config)
+		clone.Snapshots = nil
+		// Clones are plain data directories whatever the source's storage backend.
+		clone.Storage, clone.Volume, clone.StorageSize = "", "", 0
+		clone.PreviousImage = nil
+		cl.config.AddContainer(&clone)
+		if err := config.Save(cl.config); err != nil {
+			return clones, fmt.Errorf("Failed to save the config: %v", err)
+		}
+		clones = append(clones, &clone)
+		bar.Increment()
+	}
+	bar.Finish(fmt.Sprintf("Created %d clone(s) of '%s'", len(clones), src.Name))
+	return clones, nil
+}
+
+// cloneDataPath puts a clone next to the source's data directory: reflinks and btrfs
+// snapshots only work within one filesystem, and the default data directory may be on
+// another one.
+func cloneDataPath(src *config.Container, name string) string {
+	return filepath.Join(filepath.Dir(src.GetDataPath()), filepath.Base(config.GetDefaultDataPath(name)))
+}
+
+func copyDataDir(src, dst string, btrfs bool) error {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return os.MkdirAll(dst, 0755)
+	}
+	var cmd *exec.Cmd
+	if btrfs {
+		cmd = exec.Command("btrfs", "subvolume", "snapshot", src, dst)
+	} else {
+		cmd = exec.Command("cp", "-a", "--reflink=auto", src, dst)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(dst)
+		return fmt.Errorf("Failed to copy %s to %s: %v: %s", src, dst, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// resetDeviceIdentity removes identifiers that must differ between devices.
+func resetDeviceIdentity(dataPath string) error {
+	for _, pattern := range deviceIdentityFiles {
+		matches, err := filepath.Glob(filepath.Join(dataPath, pattern))
+		if err != nil {
+			return err
+		}
+		for _, m := range matches {
+			if err := os.RemoveAll(m); err != nil {
+				return fmt.Errorf("Failed to reset %s: %v", m, err)
+			}
+		}
+	}
+	return nil
+}
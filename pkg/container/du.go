@@ -0,0 +1,220 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"reddock/pkg/config"
+	"reddock/pkg/ui"
+)
+
+// DiskUsage is the space one instance takes. Total counts what belongs to the instance
+// alone (data, writable layer, snapshots); the image is reported separately because its
+// layers can be shared with other images and instances.
+type DiskUsage struct {
+	Container      string `json:"container"`
+	Storage        string `json:"storage"`
+	Data           int64  `json:"data_bytes"`
+	DataCapacity   int64  `json:"data_capacity_bytes,omitempty"`
+	Writable       int64  `json:"writable_layer_bytes"`
+	Snapshots      int64  `json:"snapshots_bytes"`
+	SnapshotCount  int    `json:"snapshot_count"`
+	Image          string `json:"image"`
+	ImageSize      int64  `json:"image_bytes"`
+	ImageShared    int64  `json:"image_shared_bytes"`    // layers shared with other images; -1 if unknown
+	ImageExclusive int64  `json:"image_exclusive_bytes"` // -1 if unknown
+	ImageInstances int    `json:"image_instances"`       // instances using the same image
+	Total          int64  `json:"total_bytes"`
+	Error          string `json:"error,omitempty"`
+}
+
+// MeasureDiskUsage measures every instance concurrently. Image and writable layer sizes
+// come from one Engine API /system/df call, or `docker inspect --size` without the API.
+// Data directories are walked, so this is slow for large instances; storage images are
+// never mounted just to be measured.
+func MeasureDiskUsage(runtime Runtime, containers []*config.Container) []DiskUsage {
+	type imageSize struct{ size, shared int64 }
+	images := make(map[string]imageSize)
+	writable := make(map[string]int64)
+	haveEngine := false
+	if engine, ok := newEngineClient(); ok {
+		if df, err := engine.diskUsage(); err == nil {
+			haveEngine = true
+			for _, img := range df.Images {
+				images[img.ID] = imageSize{img.Size, img.SharedSize}
+			}
+			for _, ctr := range df.Containers {
+				for _, name := range ctr.Names {
+					writable[strings.TrimPrefix(name, "/")] = ctr.SizeRw
+				}
+			}
+		}
+	}
+
+	usage := make([]DiskUsage, len(containers))
+	imageIDs := make([]string, len(containers))
+	users := make(map[string]int)
+	var wg sync.WaitGroup
+	for i, c := range containers {
+		u := &usage[i]
+		u.Container, u.Storage, u.Image = c.Name, c.StorageBackend(), c.ImageURL
+		imageID := c.ImageID
+		if imageID == "" {
+			if meta, err := InspectLocalImage(runtime, c.PinnedImage()); err == nil {
+				imageID = meta.ID
+			}
+		}
+		imageIDs[i] = imageID
+		users[imageID]++
+		if img, ok := images[imageID]; ok {
+			u.ImageSize, u.ImageShared = img.size, img.shared
+		} else {
+			u.ImageSize, u.ImageShared = cliImageSize(runtime, imageID), -1
+		}
+		u.ImageExclusive = -1
+		if u.ImageShared >= 0 {
+			u.ImageExclusive = u.ImageSize - u.ImageShared
+		}
+		if haveEngine {
+			u.Writable = writable[c.Name]
+		} else {
+			u.Writable = cliWritableSize(runtime, c.Name)
+		}
+
+		wg.Add(1)
+		go func(c *config.Container, u *DiskUsage) {
+			defer wg.Done()
+			if s, err := measureStorage(c, false); err != nil {
+				u.Error = err.Error()
+			} else {
+				u.Data, u.DataCapacity = s.Used, s.Capacity
+			}
+			for i := range c.Snapshots {
+				u.Snapshots += snapshotSize(&c.Snapshots[i])
+			}
+			u.SnapshotCount = len(c.Snapshots)
+			u.Total = u.Data + u.Writable + u.Snapshots
+		}(c, u)
+	}
+	wg.Wait()
+
+	for i, id := range imageIDs {
+		if id != "" {
+			usage[i].ImageInstances = users[id]
+		}
+	}
+	return usage
+}
+
+// snapshotSize is the archive size for tar snapshots, the space a ZFS snapshot holds, and
+// the referenced size of a btrfs snapshot (which shares extents with the data directory).
+func snapshotSize(snap *config.Snapshot) int64 {
+	switch snap.Method {
+	case config.SnapshotMethodBtrfs:
+		size, _ := DirSize(snap.Location)
+		return size
+	case config.SnapshotMethodZFS:
+		out, err := exec.Command("zfs", "get", "-Hp", "-o", "value", "used", snap.Location).Output()
+		if err != nil {
+			return 0
+		}
+		size, _ := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+		return size
+	default:
+		if st, err := os.Stat(snap.Location); err == nil {
+			return st.Size()
+		}
+		return snap.Size
+	}
+}
+
+func cliImageSize(runtime Runtime, imageID string) int64 {
+	if imageID == "" {
+		return 0
+	}
+	out, err := runtime.Command("image", "inspect", "--format", "{{.Size}}", imageID).Output()
+	if err != nil {
+		return 0
+	}
+	size, _ := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	return size
+}
+
+func cliWritableSize(runtime Runtime, name string) int64 {
+	out, err := runtime.Command("inspect", "--size", "--format", "{{.SizeRw}}", name).Output()
+	if err != nil {
+		return 0
+	}
+	size, _ := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	return size
+}
+
+// DiskUsage prints the usage of the named instances (all when names is empty) as a table
+// or, for monitoring, as JSON.
+func (l *Lister) DiskUsage(names []string, asJSON bool) error {
+	// Android's /data is owned by many uids; only root can measure all of it.
+	if err := CheckRoot(); err != nil {
+		return err
+	}
+	containers := l.config.ListContainers()
+	if len(names) > 0 {
+		containers = nil
+		for _, name := range names {
+			c := l.config.GetContainer(name)
+			if c == nil {
+				return fmt.Errorf("Container '%s' not found", name)
+			}
+			containers = append(containers, c)
+		}
+	}
+	usage := MeasureDiskUsage(NewRuntime(), containers)
+
+	if asJSON {
+		if usage == nil {
+			usage = []DiskUsage{}
+		}
+		out, err := json.MarshalIndent(usage, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	if len(usage) == 0 {
+		fmt.Println("No Reddock containers found.")
+		return nil
+	}
+	fmt.Printf("%-20s %-8s %-18s %-10s %-16s %-24s %-10s\n", "NAME", "STORAGE", "DATA", "WRITABLE", "SNAPSHOTS", "IMAGE (EXCL/SHARED)", "TOTAL")
+	fmt.Println(strings.Repeat("-", 112))
+	var total int64
+	for _, u := range usage {
+		data := ui.FormatBytes(u.Data)
+		if u.DataCapacity > 0 {
+			data += "/" + ui.FormatBytes(u.DataCapacity)
+		}
+		if u.Error != "" {
+			data = "?"
+		}
+		image := ui.FormatBytes(u.ImageSize)
+		if u.ImageExclusive >= 0 {
+			image += fmt.Sprintf(" (%s/%s)", ui.FormatBytes(u.ImageExclusive), ui.FormatBytes(u.ImageShared))
+		}
+		snapshots := fmt.Sprintf("%s (%d)", ui.FormatBytes(u.Snapshots), u.SnapshotCount)
+		fmt.Printf("%-20s %-8s %-18s %-10s %-16s %-24s %-10s\n", u.Container, u.Storage, data,
+			ui.FormatBytes(u.Writable), snapshots, image, ui.FormatBytes(u.Total))
+		total += u.Total
+	}
+	fmt.Printf("\nTotal (excluding images): %s\n", ui.FormatBytes(total))
+	for _, u := range usage {
+		if u.Error != "" {
+			fmt.Printf("Warning: %s: %s\n", u.Container, u.Error)
+		}
+	}
+	return nil
+}
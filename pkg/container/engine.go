@@ -0,0 +1,136 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const defaultDockerSocket = "/var/run/docker.sock"
+
+// engineClient talks to the Docker Engine API over the daemon's unix socket. It is used
+// where the docker CLI output is not machine-readable (pull progress, disk usage).
+type engineClient struct {
+	http *http.Client
+}
+
+// engineMessage is one line of the Engine API JSON progress stream.
+type engineMessage struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// newEngineClient returns a client when the daemon is reachable through a local unix socket
+// (DOCKER_HOST=unix://... or the default socket).
+func newEngineClient() (*engineClient, bool) {
+	socket := defaultDockerSocket
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		if !strings.HasPrefix(host, "unix://") {
+			return nil, false
+		}
+		socket = strings.TrimPrefix(host, "unix://")
+	}
+	if st, err := os.Stat(socket); err != nil || st.Mode()&os.ModeSocket == 0 {
+		return nil, false
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &engineClient{http: &http.Client{Transport: transport}}, true
+}
+
+// pullImage streams POST /images/create and calls onMessage for each progress message.
+// registryAuth is the base64 X-Registry-Auth header value ("" for anonymous pulls).
+func (e *engineClient) pullImage(image, registryAuth string, onMessage func(engineMessage)) error {
+	q := url.Values{}
+	q.Set("fromImage", image)
+	req, err := http.NewRequest(http.MethodPost, "http://docker/images/create?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if registryAuth != "" {
+		req.Header.Set("X-Registry-Auth", registryAuth)
+	}
+
+	resp, err := e.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("Docker Engine API request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return engineError(resp)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg engineMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Failed to read pull progress: %v", err)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("%s", msg.Error)
+		}
+		onMessage(msg)
+	}
+}
+
+// engineDiskUsage is the part of GET /system/df that reddock reports.
+type engineDiskUsage struct {
+	Images []struct {
+		ID         string `json:"Id"`
+		Size       int64  `json:"Size"`
+		SharedSize int64  `json:"SharedSize"`
+	} `json:"Images"`
+	Containers []struct {
+		Names  []string `json:"Names"`
+		SizeRw int64    `json:"SizeRw"`
+	} `json:"Containers"`
+}
+
+// diskUsage asks the daemon for image and container sizes (volumes are skipped, which
+// daemons older than API 1.42 ignore).
+func (e *engineClient) diskUsage() (*engineDiskUsage, error) {
+	resp, err := e.http.Get("http://docker/system/df?type=image&type=container")
+	if err != nil {
+		return nil, fmt.Errorf("Docker Engine API request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, engineError(resp)
+	}
+	var du engineDiskUsage
+	if err := json.NewDecoder(resp.Body).Decode(&du); err != nil {
+		return nil, fmt.Errorf("Failed to read disk usage: %v", err)
+	}
+	return &du, nil
+}
+
+func engineError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	var apiErr struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+		return fmt.Errorf("%s", apiErr.Message)
+	}
+	return fmt.Errorf("Docker Engine API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,94 @@
+package container
+
+import (
+	"fmt"
+	"strings"
+
+	"reddock/pkg/config"
+	"reddock/pkg/sysinfo"
+)
+
+// GPUSelection is the effective GPU setup for one start of an instance.
+type GPUSelection struct {
+	Mode string // host or guest after resolving auto
+	Node *sysinfo.RenderNode
+}
+
+// ResolveGPU checks the configured GPU mode against the host's render nodes.
+// host fails without a hardware node; auto degrades to guest (software rendering).
+func ResolveGPU(c *config.Container) (GPUSelection, error) {
+	mode := c.GPUMode
+	if mode == "" {
+		mode = config.DefaultGPUMode
+	}
+	if err := config.ValidateGPUMode(mode); err != nil {
+		return GPUSelection{}, err
+	}
+	if err := config.ValidateGPUNode(mode, c.GPUNode); err != nil {
+		return GPUSelection{}, err
+	}
+	if mode == config.GPUModeGuest {
+		return GPUSelection{Mode: config.GPUModeGuest}, nil
+	}
+
+	nodes := sysinfo.ProbeRenderNodes()
+	var node sysinfo.RenderNode
+	var found bool
+	if c.GPUNode != "" {
+		node, found = sysinfo.FindRenderNode(nodes, c.GPUNode)
+		if !found {
+			return GPUSelection{}, fmt.Errorf("Configured GPU node %s not found (available: %s)",
+				c.GPUNode, sysinfo.RenderNodesSummary(nodes))
+		}
+	} else {
+		node, found = sysinfo.SelectRenderNode(nodes, sysinfo.GetCPUVendor())
+	}
+
+	if !found || !node.IsHardware() {
+		if mode == config.GPUModeHost {
+			return GPUSelection{}, fmt.Errorf("GPU mode 'host' needs a hardware DRM render node, but the host has: %s. "+
+				"Use --gpu guest for software rendering", sysinfo.RenderNodesSummary(nodes))
+		}
+		return GPUSelection{Mode: config.GPUModeGuest}, nil
+	}
+	return GPUSelection{Mode: config.GPUModeHost, Node: &node}, nil
+}
+
+// gpuRunArgs passes the selected render node into the container with a device cgroup rule
+// for its char device number, so it stays usable however Android re-creates or relabels
+// /dev/dri. The node keeps the host's owner and mode (usually root:render 0660), which
+// Android's graphics processes (surfaceflinger, app uids) do not match; --group-add would
+// only cover the container's init user. grantRenderNode opens it up after the start.
+func gpuRunArgs(sel GPUSelection) []string {
+	if sel.Node == nil {
+		return nil
+	}
+	return []string{
+		"--device", fmt.Sprintf("%s:%s:rwm", sel.Node.Path, sel.Node.Path),
+		"--device-cgroup-rule", fmt.Sprintf("c %d:%d rwm", sel.Node.Major, sel.Node.Minor),
+	}
+}
+
+// grantRenderNode makes the container's copy of the render node world read/writable, as
+// Android expects of /dev/dri (ueventd.rc gives it 0666). The node lives in the
+// container's own /dev, so the host's node and its permissions are untouched. This runs
+// right after every start, before the graphics services come up a few seconds into boot.
+func grantRenderNode(runtime Runtime, containerName string, sel GPUSelection) error {
+	if sel.Node == nil {
+		return nil
+	}
+	out, err := runtime.Command("exec", containerName, "chmod", "0666", sel.Node.Path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Failed to open up %s in the container: %v: %s", sel.Node.Path, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// gpuBootArgs are the redroid boot properties for the selection.
+func gpuBootArgs(sel GPUSelection) []string {
+	args := []string{fmt.Sprintf("androidboot.redroid_gpu_mode=%s", sel.Mode)}
+	if sel.Node != nil {
+		args = append(args, fmt.Sprintf("androidboot.redroid_gpu_node=%s", sel.Node.Path))
+	}
+	return args
+}
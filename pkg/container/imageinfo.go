@@ -0,0 +1,185 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"reddock/pkg/config"
+	"reddock/pkg/registry"
+	"reddock/pkg/sysinfo"
+	"reddock/pkg/ui"
+)
+
+// ImageMeta is the subset of `docker image inspect` reddock records per instance.
+type ImageMeta struct {
+	ID           string
+	RepoDigests  []string
+	Architecture string
+	Variant      string
+	OS           string
+	Labels       map[string]string
+}
+
+// InspectLocalImage reads architecture, digests and labels of a locally stored image.
+func InspectLocalImage(runtime Runtime, image string) (*ImageMeta, error) {
+	out, err := runtime.Command("image", "inspect", "--format", "{{json .}}", image).Output()
+	if err != nil {
+		return nil, fmt.Errorf("Image does not exist locally")
+	}
+	var raw struct {
+		ID           string   `json:"Id"`
+		RepoDigests  []string `json:"RepoDigests"`
+		Architecture string   `json:"Architecture"`
+		Variant      string   `json:"Variant"`
+		OS           string   `json:"Os"`
+		Config       struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"Config"`
+	}
+	if err := json.Unmarshal(out, &raw); err != nil {
+		return nil, fmt.Errorf("Failed to parse image inspect output: %v", err)
+	}
+	return &ImageMeta{
+		ID:           raw.ID,
+		RepoDigests:  raw.RepoDigests,
+		Architecture: raw.Architecture,
+		Variant:      raw.Variant,
+		OS:           raw.OS,
+		Labels:       raw.Config.Labels,
+	}, nil
+}
+
+// Digest returns the registry digest of image's repository, or "" for images that were
+// built locally or only pulled under another name.
+func (m *ImageMeta) Digest(image string) string {
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return ""
+	}
+	if ref.Digest != "" {
+		return ref.Digest
+	}
+	for _, d := range m.RepoDigests {
+		name, digest, ok := strings.Cut(d, "@")
+		if !ok {
+			continue
+		}
+		if r, err := registry.ParseReference(name); err == nil && r.Name() == ref.Name() {
+			return digest
+		}
+	}
+	return ""
+}
+
+// isRegistryImage reports whether init should pull image rather than expect it locally:
+// official redroid images, references naming a registry host, and digest-pinned references.
+func isRegistryImage(image string) bool {
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return false
+	}
+	if ref.Domain != registry.DefaultDomain || ref.Digest != "" {
+		return true
+	}
+	return ref.Path == "redroid/redroid"
+}
+
+func shortImageID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// ensureImage makes image available locally: registry images, and any other reference not
+// present locally, are pulled (after a platform check) when allowPull is set; local builds
+// must already exist.
+func ensureImage(runtime Runtime, image string, allowPull bool) error {
+	_, localErr := InspectLocalImage(runtime, image)
+	if allowPull && (isRegistryImage(image) || localErr != nil) {
+		if err := checkRemoteImageArch(image); err != nil {
+			return err
+		}
+		if err := runtime.PullImage(image); err != nil {
+			if _, lerr := InspectLocalImage(runtime, image); lerr != nil {
+				return fmt.Errorf("Failed to pull image: %v", err)
+			}
+			fmt.Printf("Warning: pull failed (%v); using the local copy of %s\n", err, image)
+		}
+		return nil
+	}
+
+	s := ui.NewSpinner("Verifying custom image availability...")
+	s.Start()
+	if _, err := InspectLocalImage(runtime, image); err != nil {
+		s.Finish("Image verification failed")
+		return fmt.Errorf("Image '%s' not found locally. Please build or pull it first.\n"+
+			"Error: %v", image, err)
+	}
+	s.Finish("Custom image verified")
+	return nil
+}
+
+// CheckImageArch refuses architectures the host can neither run natively nor emulate.
+func CheckImageArch(image string, archs []string) error {
+	host := sysinfo.HostImageArch()
+	for _, a := range archs {
+		if a == host {
+			return nil
+		}
+	}
+	for _, a := range archs {
+		if sysinfo.EmulationAvailable(a) {
+			fmt.Printf("Warning: image '%s' is %s; running it on this %s host under qemu-user emulation (slow).\n",
+				image, a, host)
+			return nil
+		}
+	}
+	return fmt.Errorf("Image '%s' is built for %s, but this host is %s and no binfmt/qemu emulation is registered for it. "+
+		"Pick an image for %s or install qemu-user-static with binfmt support",
+		image, strings.Join(archs, ", "), host, host)
+}
+
+// checkRemoteImageArch asks the registry which platforms ref provides before pulling.
+// Registry errors only produce a warning; the local check after the pull still applies.
+func checkRemoteImageArch(image string) error {
+	info, err := registry.NewClient().Inspect(image)
+	if err != nil {
+		fmt.Printf("Warning: could not verify image architecture with the registry: %v\n", err)
+		return nil
+	}
+	var archs []string
+	for _, p := range info.Platforms {
+		archs = append(archs, p.Architecture)
+	}
+	if len(archs) == 0 {
+		return nil
+	}
+	return CheckImageArch(image, archs)
+}
+
+// recordImageMeta stores the resolved architecture, digest, image ID and Android version on
+// the instance; start runs the pinned digest so a re-tagged image never changes it silently.
+func recordImageMeta(c *config.Container, meta *ImageMeta) {
+	c.ImageArch = meta.Architecture
+	if meta.Variant != "" {
+		c.ImageArch += "/" + meta.Variant
+	}
+	c.ImageID = meta.ID
+	c.ImageDigest = meta.Digest(c.ImageURL)
+	c.AndroidVersion = androidVersionFromLabels(meta.Labels)
+	if c.AndroidVersion == "" {
+		c.AndroidVersion = config.AndroidVersionFromImage(c.ImageURL)
+	}
+}
+
+func androidVersionFromLabels(labels map[string]string) string {
+	for _, k := range []string{"io.reddock.android.version", "android.version"} {
+		if v := strings.TrimSpace(labels[k]); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -5,19 +5,43 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"reddock/pkg/config"
+	"reddock/pkg/registry"
 	"reddock/pkg/sysinfo"
 	"reddock/pkg/ui"
 )
 
 type Initializer struct {
-	config    *config.Config
-	container *config.Container
-	runtime   Runtime
+	config      *config.Config
+	container   *config.Container
+	runtime     Runtime
+	localImage  bool
+	pinnedImage string
 }
 
-func NewInitializer(containerName, image string) *Initializer {
+// InitOptions carries optional per-instance settings given on the init command line.
+// Empty fields keep the stored value (or the default for new instances).
+type InitOptions struct {
+	BinderMode      string
+	SecurityProfile string
+	GPUMode         string
+	GPUNode         string
+	// DataPath overrides $HOME/data-<name> for new instances; use `move-data` afterwards.
+	DataPath string
+	// Storage and StorageSize pick the /data backend of new instances (see config.StorageDir).
+	Storage     string
+	StorageSize int64
+	// LocalImage skips the registry pull, e.g. for images just loaded from a bundle.
+	LocalImage bool
+	// PinnedImage, a digest reference or image ID, is the exact image to initialize from;
+	// the instance still records image as its tag. Import uses it to restore data onto the
+	// build it was exported from.
+	PinnedImage string
+}
+
+func NewInitializer(containerName, image string, opts InitOptions) *Initializer {
 	cfg, err := config.Load()
 	if err != nil {
 		fmt.Printf("Warning: Failed to load config: %v\n", err)
@@ -26,36 +50,88 @@ func NewInitializer(containerName, image string) *Initializer {
 
 	container := cfg.GetContainer(containerName)
 	if container == nil {
-		port := 5555
-		for _, c := range cfg.Containers {
-			if c.Port >= port {
-				port = c.Port + 1
-			}
-		}
+		port := nextFreePort(cfg)
 
+		dataPath := opts.DataPath
+		if dataPath == "" {
+			dataPath = config.GetDefaultDataPath(containerName)
+		}
 		container = &config.Container{
-			Name:        containerName,
-			ImageURL:    image,
-			DataPath:    config.GetDefaultDataPath(containerName),
-			LogFile:     containerName + ".log",
-			GPUMode:     config.DefaultGPUMode,
-			Port:        port,
-			Initialized: false,
+			Name:            containerName,
+			ImageURL:        image,
+			DataPath:        dataPath,
+			LogFile:         containerName + ".log",
+			GPUMode:         config.DefaultGPUMode,
+			GPUNode:         opts.GPUNode,
+			Port:            port,
+			BinderMode:      opts.BinderMode,
+			SecurityProfile: opts.SecurityProfile,
+			Storage:         opts.Storage,
+			StorageSize:     opts.StorageSize,
+			Initialized:     false,
+		}
+		if container.BinderMode == "" {
+			container.BinderMode = DefaultBinderMode()
+		}
+		if container.SecurityProfile == "" {
+			container.SecurityProfile = config.SecurityProfilePrivileged
+		}
+		if opts.GPUMode != "" {
+			container.GPUMode = opts.GPUMode
 		}
 		cfg.AddContainer(container)
 		config.Save(cfg)
 	} else {
 		container.ImageURL = image
+		if opts.BinderMode != "" {
+			container.BinderMode = opts.BinderMode
+		}
+		if opts.SecurityProfile != "" {
+			container.SecurityProfile = opts.SecurityProfile
+		}
+		if opts.GPUMode != "" {
+			container.GPUMode = opts.GPUMode
+			if opts.GPUMode == config.GPUModeGuest {
+				container.GPUNode = ""
+			}
+		}
+		if opts.GPUNode != "" {
+			container.GPUNode = opts.GPUNode
+		}
+		if !container.Initialized {
+			if opts.DataPath != "" {
+				container.DataPath = opts.DataPath
+			}
+			if opts.Storage != "" {
+				container.Storage = opts.Storage
+			}
+			if opts.StorageSize != 0 {
+				container.StorageSize = opts.StorageSize
+			}
+		}
 		config.Save(cfg)
 	}
 
 	return &Initializer{
-		config:    cfg,
-		container: container,
-		runtime:   NewRuntime(),
+		config:      cfg,
+		container:   container,
+		runtime:     NewRuntime(),
+		localImage:  opts.LocalImage,
+		pinnedImage: opts.PinnedImage,
 	}
 }
 
+// nextFreePort is the first ADB port above every port already assigned.
+func nextFreePort(cfg *config.Config) int {
+	port := 5555
+	for _, c := range cfg.Containers {
+		if c.Port >= port {
+			port = c.Port + 1
+		}
+	}
+	return port
+}
+
 func (i *Initializer) Initialize() error {
 	fmt.Println("Initiating the Reddock container...")
 	fmt.Printf("Container: %s\n", i.container.Name)
@@ -75,32 +151,72 @@ func (i *Initializer) Initialize() error {
 	if err := i.checkKernelModules(); err != nil {
 		return fmt.Errorf("Kernel module check failed: %v", err)
 	}
+	if i.container.BinderMode == config.BinderModeBinderFS && !sysinfo.ProbeBinderHost().BinderFSInProcFS {
+		s1.Finish("System requirements check failed")
+		return fmt.Errorf("Binder mode '%s' needs binderfs support in the kernel (not listed in /proc/filesystems). "+
+			"Use --binder %s instead", config.BinderModeBinderFS, config.BinderModeShared)
+	}
+	gpu, err := ResolveGPU(i.container)
+	if err != nil {
+		s1.Finish("System requirements check failed")
+		return err
+	}
 	s1.Finish("System requirements met")
+	if gpu.Node != nil {
+		fmt.Printf("GPU: %s rendering on %s\n", gpu.Mode, gpu.Node)
+	} else {
+		fmt.Printf("GPU: %s (software) rendering\n", gpu.Mode)
+	}
 	sysinfo.PrintHostLSMWarnings(os.Stdout, sysinfo.ProbeHostLSM())
+	if warning := privilegedBinderWarning(i.container); warning != "" {
+		fmt.Println(warning)
+	}
 
-	if strings.HasPrefix(i.container.ImageURL, "redroid/redroid:") {
-		fmt.Printf("Pulling official Redroid image %s...\n", i.container.ImageURL)
-		if err := i.pullImage(); err != nil {
-			return fmt.Errorf("Failed to pull image: %v", err)
+	if i.container.SecurityProfile == config.SecurityProfileRestricted {
+		if problems := RestrictedProfileProblems(i.runtime); len(problems) > 0 {
+			fmt.Println("\nWarning: this host may not support the restricted security profile:")
+			for _, p := range problems {
+				fmt.Printf("  - %s\n", p)
+			}
+			fmt.Println("reddock start will fall back to --privileged if the restricted boot fails.")
 		}
-		fmt.Println("Image pulled successfully")
-	} else {
-		s2 := ui.NewSpinner("Verifying custom image availability...")
-		s2.Start()
+	}
 
-		if err := i.verifyImageExists(); err != nil {
-			s2.Finish("Image verification failed")
-			return fmt.Errorf("Image '%s' not found locally. Please build or pull it first.\n"+
-				"Error: %v", i.container.ImageURL, err)
-		}
-		s2.Finish("Custom image verified")
+	image := i.container.ImageURL
+	if i.pinnedImage != "" {
+		image = i.pinnedImage
 	}
+	if err := ensureImage(i.runtime, image, !i.localImage); err != nil {
+		return err
+	}
+
+	meta, err := InspectLocalImage(i.runtime, image)
+	if err != nil {
+		return fmt.Errorf("Failed to inspect image '%s': %v", image, err)
+	}
+	if err := CheckImageArch(image, []string{meta.Architecture}); err != nil {
+		return err
+	}
+	recordImageMeta(i.container, meta)
+	if digest := meta.Digest(image); digest != "" {
+		i.container.ImageDigest = digest
+	}
+	fmt.Printf("Image: %s", i.container.ImageArch)
+	if i.container.ImageDigest != "" {
+		fmt.Printf(", digest %s", i.container.ImageDigest)
+	} else {
+		fmt.Printf(", local image %s", shortImageID(i.container.ImageID))
+	}
+	if i.container.AndroidVersion != "" {
+		fmt.Printf(", Android %s", i.container.AndroidVersion)
+	}
+	fmt.Println()
 
 	s3 := ui.NewSpinner("Setting up container environment...")
 	s3.Start()
 
-	if err := i.createDataDirectory(); err != nil {
-		return fmt.Errorf("Failed to create data directory: %v", err)
+	if err := createStorage(i.runtime, i.container); err != nil {
+		return fmt.Errorf("Failed to create data storage: %v", err)
 	}
 	s3.Finish("Environment setup complete")
 
@@ -163,24 +279,8 @@ func tryModprobeAshmem() {
 	_ = exec.Command("modprobe", "ashmem").Run()
 }
 
-func (i *Initializer) pullImage() error {
-	return i.runtime.PullImage(i.container.ImageURL)
-}
-
-func (i *Initializer) verifyImageExists() error {
-	cmd := i.runtime.Command("image", "inspect", i.container.ImageURL)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("Image does not exist locally")
-	}
-	return nil
-}
-
-func (i *Initializer) createDataDirectory() error {
-	if err := os.MkdirAll(i.container.DataPath, 0755); err != nil {
-		return fmt.Errorf("Failed to create data directory: %v", err)
-	}
-	return nil
-}
+// listRegistryTimeout keeps `list` responsive when the registry is slow or unreachable.
+const listRegistryTimeout = 5 * time.Second
 
 type Lister struct {
 	config *config.Config
@@ -195,17 +295,44 @@ func NewLister() *Lister {
 	return &Lister{config: cfg}
 }
 
-func (l *Lister) ListReddockContainers() error {
+// ListReddockContainers prints every instance; unless offline, the UPDATE column compares
+// each instance's digest with the registry. withSize adds the SIZE column, which walks
+// every data directory like `reddock du`.
+func (l *Lister) ListReddockContainers(offline, withSize bool) error {
 	containers := l.config.ListContainers()
 	if len(containers) == 0 {
 		fmt.Println("No Reddock containers found.")
 		return nil
 	}
 
-	fmt.Printf("%-20s %-40s %-10s\n", "NAME", "IMAGE", "STATUS")
-	fmt.Println(strings.Repeat("-", 70))
-
 	runtime := NewRuntime()
+	updates := make(map[string]string)
+	if !offline {
+		client := registry.NewClient()
+		client.HTTP.Timeout = listRegistryTimeout
+		for _, u := range CheckImageUpdates(runtime, client, containers) {
+			updates[u.Container] = u.State
+		}
+	}
+
+	// Sizes need root to read all of /data (see `reddock du` for the breakdown).
+	sizes := make(map[string]string)
+	if withSize {
+		if err := CheckRoot(); err != nil {
+			return err
+		}
+		for _, u := range MeasureDiskUsage(runtime, containers) {
+			sizes[u.Container] = ui.FormatBytes(u.Total)
+		}
+	}
+
+	header := fmt.Sprintf("%-20s %-40s %-10s %-10s", "NAME", "IMAGE", "STATUS", "UPDATE")
+	if withSize {
+		header += fmt.Sprintf(" %-10s", "SIZE")
+	}
+	fmt.Println(header)
+	fmt.Println(strings.Repeat("-", len(header)))
+
 	for _, c := range containers {
 		status := "Initiated"
 		if s, err := runtime.Inspect(c.Name, "{{.State.Status}}"); err == nil {
@@ -213,7 +340,19 @@ func (l *Lister) ListReddockContainers() error {
 		} else {
 			status = "Stopped"
 		}
-		fmt.Printf("%-20s %-40s %-10s\n", c.Name, c.ImageURL, status)
+		update := updates[c.Name]
+		if update == "" {
+			update = "-"
+		}
+		line := fmt.Sprintf("%-20s %-40s %-10s %-10s", c.Name, c.ImageURL, status, update)
+		if withSize {
+			size := sizes[c.Name]
+			if size == "" {
+				size = "-"
+			}
+			line += fmt.Sprintf(" %-10s", size)
+		}
+		fmt.Println(line)
 	}
 
 	return nil
@@ -5,8 +5,10 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"reddock/pkg/config"
+	"reddock/pkg/logfile"
 	"reddock/pkg/ui"
 )
 
@@ -43,72 +45,77 @@ func (m *Manager) Start(verbose bool) error {
 		return fmt.Errorf("Container '%s' is not initialized. Run 'reddock init %s' first", m.containerName, m.containerName)
 	}
 
-	// #region agent log
-	agentDebugLog("manager.go:Start", "container config loaded", "H5", map[string]any{
-		"containerName": m.containerName,
-		"port":          container.Port,
-		"imageURL":      container.ImageURL,
-		"dataPath":      container.GetDataPath(),
-	})
-	// #endregion
-
 	if m.runtime.IsRunning(m.containerName) {
 		fmt.Printf("Container '%s' is already running\n", m.containerName)
 		return nil
 	}
 
+	if warning := privilegedBinderWarning(container); warning != "" {
+		fmt.Println(warning)
+	}
+
 	spinner := ui.NewSpinner(fmt.Sprintf("Starting container '%s'...", m.containerName))
 	spinner.Start()
 
-	// #region agent log
-	versOut, versErr := m.runtime.Command("version", "--format", "{{.Client.Version}}").CombinedOutput()
-	// #endregion
-
 	exists := m.runtime.Exists(m.containerName)
-	// #region agent log
-	agentDebugLog("manager.go:Start", "docker probe + exists", "H3", map[string]any{
-		"runtimeBinary": m.runtime.Name(),
-		"versionErr":    fmt.Sprintf("%v", versErr),
-		"versionOut":    strings.TrimSpace(string(versOut)),
-		"exists":        exists,
-	})
-	// #endregion
+
+	if container.BinderMode == config.BinderModeBinderFS {
+		if _, err := SetupBinderFS(m.containerName); err != nil {
+			spinner.Finish(fmt.Sprintf("Failed to start container '%s'", m.containerName))
+			return fmt.Errorf("Failed to set up private binderfs: %v", err)
+		}
+	}
+
+	if err := MountStorage(container); err != nil {
+		spinner.Finish(fmt.Sprintf("Failed to start container '%s'", m.containerName))
+		return err
+	}
+
+	profile := container.SecurityProfile
+	if profile == "" {
+		profile = config.SecurityProfilePrivileged
+	}
 
 	var err error
 	if exists {
 		err = m.runtime.StartExisting(m.containerName)
 		if err != nil {
 			spinner.Finish(fmt.Sprintf("Failed to start container '%s'", m.containerName))
-			return fmt.Errorf("Failed to start existing container: %v", err)
+			return fmt.Errorf("Failed to start existing container: %v%s", err, m.captureFailedStart(container, err.Error()))
 		}
 	} else {
-		args := m.buildRunArgs(container)
-		// #region agent log
-		agentDebugLog("manager.go:Start", "docker run args", "H4", map[string]any{"args": args})
-		// #endregion
-		cmd := m.runtime.Command(args...)
-		output, runErr := cmd.CombinedOutput()
+		output, runErr := m.runContainer(container, profile)
+		if runErr != nil && profile == config.SecurityProfileRestricted {
+			spinner.Finish(fmt.Sprintf("Restricted start of '%s' failed", m.containerName))
+			profile = config.SecurityProfilePrivileged
+			output, runErr = m.fallbackToPrivileged(container, fmt.Errorf("%v: %s", runErr, strings.TrimSpace(output)))
+			spinner = ui.NewSpinner(fmt.Sprintf("Starting container '%s' (privileged)...", m.containerName))
+			spinner.Start()
+		}
 		if runErr != nil {
 			spinner.Finish(fmt.Sprintf("Failed to start container '%s'", m.containerName))
-			return fmt.Errorf("Failed to start container: %s\n%s", runErr, string(output))
+			return fmt.Errorf("Failed to start container: %s\n%s%s", runErr, output,
+				m.captureFailedStart(container, fmt.Sprintf("%v: %s", runErr, strings.TrimSpace(output))))
 		}
 	}
 
-	// #region agent log
-	st, stErr := m.runtime.Inspect(m.containerName, "{{.State.Status}}")
+	st, _ := m.runtime.Inspect(m.containerName, "{{.State.Status}}")
 	exitStr, _ := m.runtime.Inspect(m.containerName, "{{.State.ExitCode}}")
-	runRaw, runErr := m.runtime.Inspect(m.containerName, "{{.State.Running}}")
 	runningNow := m.runtime.IsRunning(m.containerName)
-	agentDebugLog("manager.go:Start", "post-start state", "H1", map[string]any{
-		"usedStartExisting":       exists,
-		"IsRunning":               runningNow,
-		"StateStatus":             st,
-		"StateRunningTemplateOut": strings.TrimSpace(runRaw),
-		"inspectStatusErr":        fmt.Sprintf("%v", stErr),
-		"inspectRunningErr":       fmt.Sprintf("%v", runErr),
-		"ExitCodeStr":             exitStr,
-	})
-	// #endregion
+
+	if !runningNow && !exists && profile == config.SecurityProfileRestricted {
+		spinner.Finish(fmt.Sprintf("Restricted start of '%s' failed", m.containerName))
+		cause := fmt.Errorf("container exited (docker state: %q, exit code: %s)", strings.TrimSpace(st), strings.TrimSpace(exitStr))
+		if output, err := m.fallbackToPrivileged(container, cause); err != nil {
+			return fmt.Errorf("Failed to start container: %s\n%s%s", err, output,
+				m.captureFailedStart(container, fmt.Sprintf("%v: %s", err, strings.TrimSpace(output))))
+		}
+		spinner = ui.NewSpinner(fmt.Sprintf("Starting container '%s' (privileged)...", m.containerName))
+		spinner.Start()
+		st, _ = m.runtime.Inspect(m.containerName, "{{.State.Status}}")
+		exitStr, _ = m.runtime.Inspect(m.containerName, "{{.State.ExitCode}}")
+		runningNow = m.runtime.IsRunning(m.containerName)
+	}
 
 	if !runningNow {
 		logOut, logErr := m.runtime.Command("logs", "--tail", "60", m.containerName).CombinedOutput()
@@ -117,14 +124,21 @@ func (m *Manager) Start(verbose bool) error {
 		if logErr != nil {
 			logBlock = fmt.Sprintf("(docker logs failed: %v)\n%s", logErr, logBlock)
 		}
+		saved := m.captureFailedStart(container, fmt.Sprintf("container exited (docker state: %q, exit code: %s)", strings.TrimSpace(st), strings.TrimSpace(exitStr)))
 		return fmt.Errorf(
 			"container is not running (docker state: %q, exit code: %s). "+
 				"Check binder (binder_linux /dev/binder* or binderfs /dev/binderfs/*), ashmem/memfd, and image compatibility; see redroid-doc troubleshooting. "+
-				"If the host uses SELinux (enforcing) or AppArmor, run `reddock status %s` for remediation hints.\n\nLast container logs:\n%s",
-			strings.TrimSpace(st), strings.TrimSpace(exitStr), m.containerName, strings.TrimSpace(logBlock),
+				"If the host uses SELinux (enforcing) or AppArmor, run `reddock status %s` for remediation hints.\n\nLast container logs:\n%s%s",
+			strings.TrimSpace(st), strings.TrimSpace(exitStr), m.containerName, strings.TrimSpace(logBlock), saved,
 		)
 	}
 
+	if gpu, err := ResolveGPU(container); err == nil {
+		if err := grantRenderNode(m.runtime, m.containerName, gpu); err != nil {
+			fmt.Printf("\nWarning: %v\n", err)
+		}
+	}
+
 	spinner.Finish(fmt.Sprintf("Container '%s' started successfully", m.containerName))
 
 	fmt.Println("\nContainer started!")
@@ -138,31 +152,73 @@ func (m *Manager) Start(verbose bool) error {
 	return nil
 }
 
-func (m *Manager) buildRunArgs(container *config.Container) []string {
+// runContainer creates and starts a fresh container under the given security profile.
+func (m *Manager) runContainer(container *config.Container, profile string) (string, error) {
+	args, err := m.buildRunArgs(container, profile)
+	if err != nil {
+		return "", err
+	}
+	output, err := m.runtime.Command(args...).CombinedOutput()
+	return string(output), err
+}
+
+// fallbackToPrivileged reports why the restricted profile failed and retries with --privileged.
+// The stored profile is left unchanged so the next start tries the restricted profile again.
+func (m *Manager) fallbackToPrivileged(container *config.Container, cause error) (string, error) {
+	fmt.Println()
+	fmt.Println(restrictedBootDiagnostics(m.runtime, m.containerName, cause))
+	fmt.Println("\nWarning: falling back to the privileged profile for this start.")
+	_ = m.runtime.Remove(m.containerName, true)
+	return m.runContainer(container, config.SecurityProfilePrivileged)
+}
+
+func (m *Manager) buildRunArgs(container *config.Container, profile string) ([]string, error) {
 	args := []string{
 		"run",
 		"-d",
-		"--privileged",
+	}
+
+	if profile == config.SecurityProfileRestricted {
+		restricted, err := restrictedRunArgs(container)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, restricted...)
+	} else {
+		args = append(args, "--privileged")
+	}
+
+	args = append(args,
 		"--name", m.containerName,
 		"--hostname", m.containerName,
-		"-v", fmt.Sprintf("%s:/data:z", container.GetDataPath()),
+		"-v", dataMountArg(container),
 		"-p", fmt.Sprintf("%d:5555", container.Port),
+	)
+
+	// Private binder devices keep instances from sharing binder contexts
+	if container.BinderMode == config.BinderModeBinderFS {
+		args = append(args, binderDeviceArgs(m.containerName, profile != config.SecurityProfileRestricted)...)
 	}
 
-	// Add GPU mode if specified
-	gpuMode := container.GPUMode
-	if gpuMode == "" {
-		gpuMode = "auto"
+	gpu, err := ResolveGPU(container)
+	if err != nil {
+		return nil, err
 	}
+	args = append(args, gpuRunArgs(gpu)...)
 
-	// Image
-	args = append(args, container.ImageURL)
+	// Image, pinned to the digest resolved at init
+	args = append(args, container.PinnedImage())
 
 	// Boot arguments (use_memfd helps on kernels without ashmem, e.g. many openSUSE/5.18+ setups)
-	args = append(args, fmt.Sprintf("androidboot.redroid_gpu_mode=%s", gpuMode))
+	args = append(args, gpuBootArgs(gpu)...)
 	args = append(args, "androidboot.use_memfd=true")
 
-	return args
+	// Recommended boot arguments from the image catalog
+	if img, ok := config.LookupCatalogImage(container.ImageURL); ok {
+		args = append(args, img.BootArgs...)
+	}
+
+	return args, nil
 }
 
 func (m *Manager) Stop() error {
@@ -193,6 +249,10 @@ func (m *Manager) Stop() error {
 		}
 	}
 
+	if err := TeardownBinderFS(m.containerName); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+
 	return nil
 }
 
@@ -245,6 +305,35 @@ func (m *Manager) FormatStoppedDiagnostics() string {
 	return b.String()
 }
 
+// captureFailedStart appends the reason and the full container logs to the instance's log
+// file, so a failed boot can be inspected after the container is recreated. It returns a
+// note naming the file for the error message, or "" when nothing could be saved.
+func (m *Manager) captureFailedStart(c *config.Container, reason string) string {
+	f, err := logfile.Open(c.LogFilePath(), logfile.DefaultMaxSize, logfile.DefaultKeep)
+	if err != nil {
+		fmt.Printf("Warning: Could not save the container logs: %v\n", err)
+		return ""
+	}
+	defer f.Close()
+	var b strings.Builder
+	fmt.Fprintf(&b, "===== %s: start of '%s' failed (%s) =====\n", time.Now().Format(time.RFC3339), c.Name, c.ImageURL)
+	fmt.Fprintf(&b, "%s\n", strings.TrimSpace(reason))
+	if m.runtime.Exists(m.containerName) {
+		logs, err := m.runtime.Command("logs", "--timestamps", m.containerName).CombinedOutput()
+		if err != nil {
+			fmt.Fprintf(&b, "(docker logs failed: %v)\n", err)
+		}
+		if text := strings.TrimRight(string(logs), "\n"); text != "" {
+			fmt.Fprintf(&b, "%s\n", text)
+		}
+	}
+	if _, err := f.Write([]byte(b.String())); err != nil {
+		fmt.Printf("Warning: Could not save the container logs: %v\n", err)
+		return ""
+	}
+	return fmt.Sprintf("\n\nFull container logs saved to %s", c.LogFilePath())
+}
+
 func (m *Manager) showLogs() error {
 	cmd := exec.Command(m.runtime.Name(), "logs", "-f", m.containerName)
 	cmd.Stdout = os.Stdout
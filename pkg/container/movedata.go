@@ -0,0 +1,310 @@
+package container
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"reddock/pkg/config"
+	"reddock/pkg/ui"
+)
+
+// DataMover relocates an instance's data directory.
+type DataMover struct {
+	config        *config.Config
+	runtime       Runtime
+	containerName string
+}
+
+func NewDataMover(containerName string) *DataMover {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Warning: Failed to load config: %v\n", err)
+		cfg = config.GetDefault()
+	}
+	return &DataMover{config: cfg, runtime: NewRuntime(), containerName: containerName}
+}
+
+// Move stops the instance and moves its data directory (and snapshot directory) to dst.
+// On the same filesystem the directory is renamed; otherwise it is copied with `cp -a`,
+// which keeps ownership, ACLs, xattrs and SELinux labels, and the copy is compared with
+// the original; the original is only removed once the config points at the copy. The Docker container is recreated with
+// the new bind mount, and started again if it was running.
+func (m *DataMover) Move(dst string) error {
+	if err := CheckRoot(); err != nil {
+		return err
+	}
+	c := m.config.GetContainer(m.containerName)
+	if c == nil {
+		return fmt.Errorf("Container '%s' not found", m.containerName)
+	}
+	if err := requireDirStorage(c, "move the data directory"); err != nil {
+		return err
+	}
+	dst, err := filepath.Abs(dst)
+	if err != nil {
+		return err
+	}
+	src := filepath.Clean(c.GetDataPath())
+	if dst == src {
+		return fmt.Errorf("'%s' already stores its data in %s", c.Name, src)
+	}
+	if strings.HasPrefix(dst, src+"/") {
+		return fmt.Errorf("Cannot move %s into itself", src)
+	}
+	if err := prepareMoveTarget(dst); err != nil {
+		return err
+	}
+	if err := prepareMoveTarget(dst + ".snapshots"); err != nil {
+		return err
+	}
+
+	running := m.runtime.IsRunning(c.Name)
+	if m.runtime.Exists(c.Name) {
+		mgr := &Manager{runtime: m.runtime, config: m.config, containerName: c.Name}
+		if err := mgr.Stop(); err != nil {
+			return err
+		}
+	}
+
+	copied := false
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		fmt.Printf("Data directory %s does not exist yet; nothing to move\n", src)
+	} else if sameFilesystem(src, filepath.Dir(dst)) {
+		if err := m.rename(c, src, dst); err != nil {
+			return err
+		}
+	} else {
+		if err := m.copy(c, src, dst); err != nil {
+			return err
+		}
+		copied = true
+	}
+
+	c.DataPath = dst
+	if err := config.Save(m.config); err != nil {
+		return fmt.Errorf("Failed to save the config: %v", err)
+	}
+	fmt.Printf("Data of '%s' is now in %s\n", c.Name, dst)
+	if copied {
+		os.RemoveAll(src + ".snapshots")
+		if err := os.RemoveAll(src); err != nil {
+			fmt.Printf("Warning: could not remove the old data directory %s: %v\n", src, err)
+		}
+	}
+
+	if running {
+		mgr := &Manager{runtime: m.runtime, config: m.config, containerName: c.Name}
+		return mgr.Start(false)
+	}
+	return nil
+}
+
+func (m *DataMover) rename(c *config.Container, src, dst string) error {
+	if err := os.Rename(src, dst); err != nil {
+		return fmt.Errorf("Failed to rename %s to %s: %v", src, dst, err)
+	}
+	if _, err := os.Stat(src + ".snapshots"); err == nil {
+		if err := os.Rename(src+".snapshots", dst+".snapshots"); err != nil {
+			// Put the data back where the config still expects it.
+			if rerr := os.Rename(dst, src); rerr != nil {
+				return fmt.Errorf("Failed to move snapshots to %s.snapshots (%v) and to restore %s (%v); the data is now in %s",
+					dst, err, src, rerr, dst)
+			}
+			return fmt.Errorf("Failed to move snapshots to %s.snapshots: %v", dst, err)
+		}
+		relocateSnapshots(c, src+".snapshots", dst+".snapshots")
+	}
+	fmt.Printf("Renamed %s to %s\n", src, dst)
+	return nil
+}
+
+func (m *DataMover) copy(c *config.Container, src, dst string) error {
+	// btrfs subvolume snapshots and ZFS snapshots belong to the source filesystem.
+	for _, snap := range c.Snapshots {
+		if snap.Method != config.SnapshotMethodTar {
+			return fmt.Errorf("Snapshot %s is a %s snapshot and cannot move to another filesystem; delete it first (reddock snapshot delete %s %s)",
+				snap.ID, snap.Method, c.Name, snap.ID)
+		}
+	}
+
+	s := ui.NewSpinner(fmt.Sprintf("Copying %s to %s...", src, dst))
+	s.Start()
+	if err := copyTree(src, dst); err != nil {
+		s.Finish("Copy failed")
+		return err
+	}
+	s.Finish(fmt.Sprintf("Copied %s to %s", src, dst))
+
+	s = ui.NewSpinner("Verifying the copy (metadata and file contents)...")
+	s.Start()
+	if err := verifyCopy(src, dst); err != nil {
+		s.Finish("Verification failed")
+		os.RemoveAll(dst)
+		return fmt.Errorf("The copy does not match the original (%v); %s was left untouched", err, src)
+	}
+	s.Finish("Copy verified")
+
+	if _, err := os.Stat(src + ".snapshots"); err == nil {
+		s = ui.NewSpinner("Copying and verifying snapshots...")
+		s.Start()
+		if err := copyTree(src+".snapshots", dst+".snapshots"); err != nil {
+			s.Finish("Copy failed")
+			os.RemoveAll(dst)
+			return err
+		}
+		if err := verifyCopy(src+".snapshots", dst+".snapshots"); err != nil {
+			s.Finish("Verification failed")
+			os.RemoveAll(dst + ".snapshots")
+			os.RemoveAll(dst)
+			return fmt.Errorf("The snapshot copy does not match the original (%v); %s was left untouched", err, src)
+		}
+		s.Finish("Snapshots copied and verified")
+		relocateSnapshots(c, src+".snapshots", dst+".snapshots")
+	}
+	return nil
+}
+
+// prepareMoveTarget accepts a path that does not exist or is an empty directory (which is
+// removed so it can be renamed or copied onto).
+func prepareMoveTarget(path string) error {
+	entries, err := os.ReadDir(path)
+	if os.IsNotExist(err) {
+		return os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err != nil {
+		return fmt.Errorf("%s exists and is not a directory", path)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", path)
+	}
+	return os.Remove(path)
+}
+
+func sameFilesystem(a, b string) bool {
+	var sa, sb syscall.Stat_t
+	if syscall.Stat(a, &sa) != nil || syscall.Stat(b, &sb) != nil {
+		return false
+	}
+	return sa.Dev == sb.Dev
+}
+
+func copyTree(src, dst string) error {
+	if out, err := exec.Command("cp", "-a", src, dst).CombinedOutput(); err != nil {
+		os.RemoveAll(dst)
+		return fmt.Errorf("Failed to copy %s to %s: %v: %s", src, dst, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// relocateSnapshots rewrites snapshot locations below the old snapshot directory.
+func relocateSnapshots(c *config.Container, oldDir, newDir string) {
+	for i := range c.Snapshots {
+		if loc := c.Snapshots[i].Location; strings.HasPrefix(loc, oldDir+"/") {
+			c.Snapshots[i].Location = newDir + strings.TrimPrefix(loc, oldDir)
+		}
+	}
+}
+
+// verifyCopy compares type, permissions, ownership, symlink targets and xattrs (including
+// security.selinux) of every entry below src with its counterpart in dst, and the size and
+// SHA-256 of regular files.
+func verifyCopy(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		var a, b syscall.Stat_t
+		if err := syscall.Lstat(path, &a); err != nil {
+			return err
+		}
+		if err := syscall.Lstat(target, &b); err != nil {
+			return fmt.Errorf("%s is missing", rel)
+		}
+		switch {
+		case a.Mode != b.Mode:
+			return fmt.Errorf("%s: mode %o != %o", rel, a.Mode, b.Mode)
+		case a.Uid != b.Uid || a.Gid != b.Gid:
+			return fmt.Errorf("%s: owner %d:%d != %d:%d", rel, a.Uid, a.Gid, b.Uid, b.Gid)
+		case a.Mode&syscall.S_IFMT == syscall.S_IFREG && a.Size != b.Size:
+			return fmt.Errorf("%s: size %d != %d", rel, a.Size, b.Size)
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			la, _ := os.Readlink(path)
+			lb, _ := os.Readlink(target)
+			if la != lb {
+				return fmt.Errorf("%s: link target %q != %q", rel, la, lb)
+			}
+			return nil // syscall has no lgetxattr; the xattrs below would follow the link
+		}
+		if !bytes.Equal(readXattrs(path), readXattrs(target)) {
+			return fmt.Errorf("%s: extended attributes differ", rel)
+		}
+		if a.Mode&syscall.S_IFMT == syscall.S_IFREG {
+			ha, err := fileSHA256(path)
+			if err != nil {
+				return err
+			}
+			hb, err := fileSHA256(target)
+			if err != nil {
+				return err
+			}
+			if ha != hb {
+				return fmt.Errorf("%s: content differs", rel)
+			}
+		}
+		return nil
+	})
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readXattrs returns all extended attribute names and values of path in a comparable form.
+func readXattrs(path string) []byte {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil
+	}
+	names := make([]byte, size)
+	if size, err = syscall.Listxattr(path, names); err != nil {
+		return nil
+	}
+	list := strings.Split(strings.TrimRight(string(names[:size]), "\x00"), "\x00")
+	sort.Strings(list)
+	var out []byte
+	for _, name := range list {
+		n, err := syscall.Getxattr(path, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, n)
+		n, _ = syscall.Getxattr(path, name, value)
+		out = append(out, name...)
+		out = append(out, '=')
+		out = append(out, value[:n]...)
+		out = append(out, 0)
+	}
+	return out
+}
@@ -0,0 +1,116 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"time"
+
+	"reddock/pkg/registry"
+	"reddock/pkg/ui"
+)
+
+const maxPullAttempts = 4
+
+// transientPullErrors are registry/network failures worth retrying.
+var transientPullErrors = []string{
+	"tls handshake timeout",
+	"i/o timeout",
+	"connection reset",
+	"connection refused",
+	"unexpected eof",
+	"too many requests",
+	"toomanyrequests",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+	"net/http: request canceled",
+	"client.timeout exceeded",
+}
+
+func isTransientPullError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, t := range transientPullErrors {
+		if strings.Contains(msg, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// pullWithProgress pulls image, rendering one ui.Progress bar per layer through a
+// ui.ProgressGroup and retrying transient registry errors with exponential backoff.
+func pullWithProgress(r *GenericRuntime, image string) error {
+	progress := ui.NewProgressGroup(fmt.Sprintf("Pulling %s", image))
+	progress.Start()
+
+	auth := registry.EngineAuthHeader(image)
+	var err error
+	for attempt := 1; attempt <= maxPullAttempts; attempt++ {
+		if engine, ok := newEngineClient(); ok {
+			err = engine.pullImage(image, auth, func(msg engineMessage) {
+				reportPullStatus(progress, msg.ID, msg.Status, msg.ProgressDetail.Current, msg.ProgressDetail.Total)
+			})
+		} else {
+			err = pullWithCLI(r, image, progress)
+		}
+		if err == nil {
+			progress.Finish(fmt.Sprintf("Pulled %s", image))
+			return nil
+		}
+		if !isTransientPullError(err) || attempt == maxPullAttempts {
+			break
+		}
+		backoff := time.Duration(1<<attempt) * time.Second
+		fmt.Printf("Transient registry error: %v (retry %d/%d in %s)\n", err, attempt, maxPullAttempts-1, backoff)
+		time.Sleep(backoff)
+		progress.Reset()
+	}
+	return err
+}
+
+// reportPullStatus maps one pull status line onto the layer's progress bar. Only download
+// bytes count towards the total; extraction progress just updates the status text.
+func reportPullStatus(progress *ui.ProgressGroup, id, status string, current, total int64) {
+	if id == "" || strings.HasPrefix(status, "Pulling from") {
+		return
+	}
+	switch status {
+	case "Downloading":
+		progress.Update(id, status, current, total, false)
+	case "Download complete", "Already exists", "Pull complete":
+		progress.Update(id, status, 0, 0, true)
+	default:
+		progress.Update(id, status, 0, 0, false)
+	}
+}
+
+// pullWithCLI runs docker pull and parses its line-oriented output ("<layer>: <status>"),
+// used when the Engine API socket is not reachable (e.g. remote DOCKER_HOST).
+func pullWithCLI(r *GenericRuntime, image string, progress *ui.ProgressGroup) error {
+	cmd := r.Command("pull", image)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	sc := bufio.NewScanner(stdout)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if id, status, ok := strings.Cut(line, ": "); ok && !strings.Contains(id, " ") {
+			reportPullStatus(progress, id, status, 0, 0)
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s", msg)
+		}
+		return err
+	}
+	return nil
+}
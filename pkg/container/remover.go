@@ -58,14 +58,21 @@ func (r *Remover) Remove(removeImage bool) error {
 				if err := r.runtime.Remove(container.Name, true); err != nil {
 					fmt.Printf("\nWarning: Failed to remove container: %v\n", err)
 				}
+				if err := TeardownBinderFS(container.Name); err != nil {
+					fmt.Printf("\nWarning: %v\n", err)
+				}
 				return nil
 			},
 		},
 		{
-			name: fmt.Sprintf("Removing data directory: %s", container.GetDataPath()),
+			name: fmt.Sprintf("Removing %s storage, %d snapshot(s) and logs: %s", container.StorageBackend(), len(container.Snapshots), container.GetDataPath()),
 			fn: func() error {
-				if err := os.RemoveAll(container.GetDataPath()); err != nil {
-					fmt.Printf("\nWarning: Could not remove data directory: %v\n", err)
+				deleteAllSnapshots(container)
+				if err := removeStorage(r.runtime, container); err != nil {
+					fmt.Printf("\nWarning: Could not remove data storage: %v\n", err)
+				}
+				if err := os.RemoveAll(config.GetLogDir(container.Name)); err != nil {
+					fmt.Printf("\nWarning: Could not remove logs: %v\n", err)
 				}
 				return nil
 			},
@@ -0,0 +1,119 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"reddock/pkg/config"
+)
+
+// Renamer changes an instance's name, which is also its Docker container name, hostname,
+// binderfs mount and (by default) data directory name.
+type Renamer struct {
+	config  *config.Config
+	runtime Runtime
+}
+
+func NewRenamer() *Renamer {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Warning: Failed to load config: %v\n", err)
+		cfg = config.GetDefault()
+	}
+	return &Renamer{config: cfg, runtime: NewRuntime()}
+}
+
+// Rename moves instance oldName to newName. The Docker container is recreated on the next
+// start so the hostname follows the new name. A running instance is refused unless force
+// is set, in which case it is stopped and started again under the new name. With moveData,
+// a data directory at the default location (data-<old>) is renamed to data-<new>.
+func (r *Renamer) Rename(oldName, newName string, force, moveData bool) error {
+	if err := CheckRoot(); err != nil {
+		return err
+	}
+	if err := config.ValidateContainerName(newName); err != nil {
+		return err
+	}
+	c := r.config.GetContainer(oldName)
+	if c == nil {
+		return fmt.Errorf("Container '%s' not found", oldName)
+	}
+	if r.config.GetContainer(newName) != nil || r.runtime.Exists(newName) {
+		return fmt.Errorf("Container '%s' already exists", newName)
+	}
+
+	if moveData {
+		if err := requireDirStorage(c, "move the data directory"); err != nil {
+			return err
+		}
+	}
+
+	running := r.runtime.IsRunning(oldName)
+	if running && !force {
+		return fmt.Errorf("Container '%s' is running; stop it first or pass --force to stop and restart it", oldName)
+	}
+	if r.runtime.Exists(oldName) {
+		old := &Manager{runtime: r.runtime, config: r.config, containerName: oldName}
+		if err := old.Stop(); err != nil {
+			return err
+		}
+	}
+
+	if moveData {
+		if err := r.moveDefaultDataDir(c, newName); err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(config.GetLogDir(oldName)); err == nil {
+		if err := os.Rename(config.GetLogDir(oldName), config.GetLogDir(newName)); err != nil {
+			fmt.Printf("Warning: Could not move the log directory: %v\n", err)
+		}
+	}
+
+	r.config.RemoveContainer(oldName)
+	c.Name = newName
+	if c.LogFile == oldName+".log" {
+		c.LogFile = newName + ".log"
+	}
+	r.config.AddContainer(c)
+	if err := config.Save(r.config); err != nil {
+		return fmt.Errorf("Failed to save the config: %v", err)
+	}
+	fmt.Printf("Renamed '%s' to '%s' (data: %s)\n", oldName, newName, c.GetDataPath())
+
+	if running {
+		mgr := &Manager{runtime: r.runtime, config: r.config, containerName: newName}
+		return mgr.Start(false)
+	}
+	return nil
+}
+
+// moveDefaultDataDir renames data-<old> (and its snapshot directory) to data-<new>. Data
+// directories outside the default location are left where they are.
+func (r *Renamer) moveDefaultDataDir(c *config.Container, newName string) error {
+	oldPath := c.GetDataPath()
+	if oldPath != config.GetDefaultDataPath(c.Name) {
+		fmt.Printf("Data directory %s is not at the default location; leaving it in place (use reddock move-data to move it)\n", oldPath)
+		return nil
+	}
+	newPath := config.GetDefaultDataPath(newName)
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("Data directory %s already exists", newPath)
+	}
+	if err := os.Rename(oldPath, newPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to rename %s to %s: %v", oldPath, newPath, err)
+	}
+	c.DataPath = newPath
+
+	oldSnapshots := strings.TrimRight(oldPath, "/") + ".snapshots"
+	newSnapshots := snapshotDir(c)
+	if _, err := os.Stat(oldSnapshots); err == nil {
+		if err := os.Rename(oldSnapshots, newSnapshots); err != nil {
+			return fmt.Errorf("Failed to move snapshots to %s: %v", newSnapshots, err)
+		}
+		relocateSnapshots(c, oldSnapshots, newSnapshots)
+	}
+	return nil
+}
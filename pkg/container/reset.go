@@ -0,0 +1,181 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"reddock/pkg/config"
+	"reddock/pkg/ui"
+)
+
+// resetStagingDir holds kept paths while the rest of /data is wiped. It lives inside the
+// data directory so moving in and out never crosses a filesystem or btrfs subvolume.
+const resetStagingDir = ".reddock-reset-keep"
+
+// ResetOptions controls `reddock reset`.
+type ResetOptions struct {
+	Keep          []string // paths under /data to preserve, e.g. /data/misc/adb
+	SnapshotFirst bool     // snapshot the data directory before wiping it
+	Yes           bool     // do not ask for confirmation
+}
+
+// Resetter wipes an instance's Android user data while keeping its configuration.
+type Resetter struct {
+	config        *config.Config
+	runtime       Runtime
+	containerName string
+	opts          ResetOptions
+}
+
+func NewResetter(containerName string, opts ResetOptions) *Resetter {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Warning: Failed to load config: %v\n", err)
+		cfg = config.GetDefault()
+	}
+	return &Resetter{config: cfg, runtime: NewRuntime(), containerName: containerName, opts: opts}
+}
+
+// Reset stops the instance, empties its data directory except for the kept paths and
+// starts it again, so Android boots as on first start.
+func (r *Resetter) Reset() error {
+	if err := CheckRoot(); err != nil {
+		return err
+	}
+	c := r.config.GetContainer(r.containerName)
+	if c == nil {
+		return fmt.Errorf("Container '%s' not found", r.containerName)
+	}
+	if !c.Initialized {
+		return fmt.Errorf("Container '%s' is not initialized. Run 'reddock init %s' first", c.Name, c.Name)
+	}
+	keep, err := resetKeepPaths(r.opts.Keep)
+	if err != nil {
+		return err
+	}
+	dataPath := c.GetDataPath()
+
+	if !r.opts.Yes {
+		fmt.Printf("Wipe all Android data of '%s' in %s", c.Name, dataPath)
+		if len(keep) > 0 {
+			fmt.Printf(" (keeping /data/%s)", strings.Join(keep, ", /data/"))
+		}
+		fmt.Print("? [y/N]: ")
+		var response string
+		fmt.Scanln(&response)
+		if response != "y" && response != "Y" && response != "yes" {
+			return fmt.Errorf("Reset aborted")
+		}
+	}
+
+	mgr := &Manager{runtime: r.runtime, config: r.config, containerName: c.Name}
+	if r.runtime.Exists(c.Name) {
+		if err := mgr.Stop(); err != nil {
+			return err
+		}
+	}
+	if err := MountStorage(c); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(dataPath); err == nil {
+		if r.opts.SnapshotFirst {
+			s := ui.NewSpinner(fmt.Sprintf("Snapshotting %s...", dataPath))
+			s.Start()
+			snap, err := createSnapshot(c, SnapshotMethodFor(dataPath), "pre-reset")
+			if err != nil {
+				s.Finish("Snapshot failed")
+				return err
+			}
+			s.Finish(fmt.Sprintf("Data snapshot %s saved to %s", snap.ID, snap.Location))
+			if err := config.Save(r.config); err != nil {
+				return fmt.Errorf("Failed to save the config: %v", err)
+			}
+		}
+
+		s := ui.NewSpinner(fmt.Sprintf("Wiping %s...", dataPath))
+		s.Start()
+		if err := wipeDataDir(dataPath, keep); err != nil {
+			s.Finish("Reset failed")
+			return err
+		}
+		s.Finish("Data wiped")
+	} else if err := os.MkdirAll(dataPath, 0755); err != nil {
+		return fmt.Errorf("Failed to create data directory: %v", err)
+	}
+
+	if err := mgr.Start(false); err != nil {
+		return err
+	}
+	fmt.Printf("\nContainer '%s' was reset; Android is going through its first boot\n", c.Name)
+	return nil
+}
+
+// resetKeepPaths turns /data/misc/adb or misc/adb into paths relative to the data directory.
+func resetKeepPaths(paths []string) ([]string, error) {
+	var keep []string
+	for _, p := range paths {
+		rel := strings.TrimPrefix(filepath.Clean("/"+p), "/")
+		if rel == "data" || strings.HasPrefix(rel, "data/") {
+			rel = strings.TrimPrefix(strings.TrimPrefix(rel, "data"), "/")
+		}
+		if rel == "" {
+			return nil, fmt.Errorf("--keep %s would keep all of /data", p)
+		}
+		keep = append(keep, rel)
+	}
+	return keep, nil
+}
+
+// wipeDataDir empties dataPath except for keep (relative paths), which are moved aside
+// and renamed back afterwards, so they keep their ownership and SELinux labels.
+func wipeDataDir(dataPath string, keep []string) error {
+	staging := filepath.Join(dataPath, resetStagingDir)
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	var kept []string
+	for _, rel := range keep {
+		src := filepath.Join(dataPath, rel)
+		if _, err := os.Lstat(src); os.IsNotExist(err) {
+			fmt.Printf("\nWarning: /data/%s does not exist; nothing to keep\n", rel)
+			continue
+		}
+		dst := filepath.Join(staging, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return err
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return fmt.Errorf("Failed to set aside /data/%s: %v", rel, err)
+		}
+		kept = append(kept, rel)
+	}
+
+	entries, err := os.ReadDir(dataPath)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s: %v", dataPath, err)
+	}
+	for _, e := range entries {
+		if e.Name() == resetStagingDir {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dataPath, e.Name())); err != nil {
+			return fmt.Errorf("Failed to clear %s: %v", dataPath, err)
+		}
+	}
+
+	// Android recreates parent directories with its own owners and labels on boot; only
+	// the kept paths themselves carry their original metadata.
+	for _, rel := range kept {
+		dst := filepath.Join(dataPath, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0771); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(staging, rel), dst); err != nil {
+			return fmt.Errorf("Failed to restore /data/%s (it is in %s): %v", rel, staging, err)
+		}
+	}
+	return os.RemoveAll(staging)
+}
@@ -62,11 +62,9 @@ func (r *GenericRuntime) IsInstalled() bool {
 	return err == nil
 }
 
+// PullImage pulls with parsed per-layer progress and retries on transient registry errors.
 func (r *GenericRuntime) PullImage(image string) error {
-	cmd := r.Command("pull", image)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	return pullWithProgress(r, image)
 }
 
 func (r *GenericRuntime) PushImage(image string) error {
@@ -114,17 +112,8 @@ func (r *GenericRuntime) Inspect(containerName string, format string) (string, e
 func (r *GenericRuntime) Exists(containerName string) bool {
 	// Exact name match via inspect (reliable for Docker and podman-docker; ps --filter name= is engine-specific).
 	cmd := r.Command("inspect", "-f", "{{.Id}}", containerName)
-	output, outErr := cmd.CombinedOutput()
-	matched := outErr == nil && strings.TrimSpace(string(output)) != ""
-	// #region agent log
-	agentDebugLog("runtime.go:Exists", "inspect by name", "H2", map[string]any{
-		"containerName": containerName,
-		"output":        strings.TrimSpace(string(output)),
-		"combinedErr":   fmt.Sprintf("%v", outErr),
-		"matched":       matched,
-	})
-	// #endregion
-	return matched
+	output, err := cmd.Output()
+	return err == nil && strings.TrimSpace(string(output)) != ""
 }
 
 func (r *GenericRuntime) IsRunning(containerName string) bool {
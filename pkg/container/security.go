@@ -0,0 +1,128 @@
+package container
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"reddock/pkg/config"
+	"reddock/pkg/sysinfo"
+)
+
+// seccompRedroidProfile is Docker's default allow-list (including its capability-gated
+// groups, which open up with the capabilities below) plus the keyring syscalls Android
+// init and vold need; everything else fails with EPERM.
+//
+//go:embed profiles/seccomp-redroid.json
+var seccompRedroidProfile []byte
+
+// restrictedCapabilities are added on top of Docker's default set. Android init mounts
+// filesystems, configures networking and adjusts scheduling/limits for its services.
+// SYS_TIME is deliberately absent: the clock is not namespaced, so it would let the guest
+// set the host's time.
+var restrictedCapabilities = []string{
+	"SYS_ADMIN",
+	"NET_ADMIN",
+	"SYS_NICE",
+	"SYS_RESOURCE",
+	"SYS_PTRACE",
+	"DAC_READ_SEARCH",
+	"IPC_LOCK",
+	"WAKE_ALARM",
+	"BLOCK_SUSPEND",
+}
+
+// restrictedSysctls are namespaced sysctls Android sets during boot (netd, ping).
+var restrictedSysctls = []string{
+	"net.ipv4.ip_forward=1",
+	"net.ipv4.ping_group_range=0 2147483647",
+}
+
+// SeccompProfilePath is where the bundled seccomp profile is written for docker run.
+func SeccompProfilePath() string {
+	return filepath.Join(config.GetConfigDir(), "seccomp-redroid.json")
+}
+
+// writeSeccompProfile refreshes the bundled profile on disk so docker can read it.
+func writeSeccompProfile() (string, error) {
+	path := SeccompProfilePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("Failed to create config directory: %v", err)
+	}
+	if err := os.WriteFile(path, seccompRedroidProfile, 0644); err != nil {
+		return "", fmt.Errorf("Failed to write seccomp profile: %v", err)
+	}
+	return path, nil
+}
+
+// restrictedRunArgs replaces --privileged with the device, capability, sysctl and seccomp
+// set redroid needs. The DRI render node is added by gpuRunArgs for either profile.
+func restrictedRunArgs(container *config.Container) ([]string, error) {
+	profile, err := writeSeccompProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	var args []string
+	for _, c := range restrictedCapabilities {
+		args = append(args, "--cap-add", c)
+	}
+	for _, s := range restrictedSysctls {
+		args = append(args, "--sysctl", s)
+	}
+	args = append(args, "--security-opt", "seccomp="+profile)
+	if sysinfo.ProbeHostLSM().AppArmorMayAffectDocker() {
+		// docker-default denies mount(2), which Android init cannot boot without
+		args = append(args, "--security-opt", "apparmor=unconfined")
+	}
+
+	if container.BinderMode != config.BinderModeBinderFS {
+		for _, dev := range BinderDevices(container) {
+			args = append(args, "--device", fmt.Sprintf("%s:/dev/%s", dev, filepath.Base(dev)))
+		}
+	}
+	if isCharDev("/dev/ashmem") {
+		args = append(args, "--device", "/dev/ashmem:/dev/ashmem")
+	}
+
+	return args, nil
+}
+
+// RestrictedProfileProblems lists host features missing for the restricted profile.
+// An empty result means the host should be able to boot redroid without --privileged.
+func RestrictedProfileProblems(runtime Runtime) []string {
+	var problems []string
+
+	opts, err := runtime.Command("info", "--format", "{{json .SecurityOptions}}").Output()
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("could not query docker security options: %v", err))
+	} else if !strings.Contains(string(opts), "seccomp") {
+		problems = append(problems, "the Docker daemon does not report seccomp support")
+	}
+
+	b := sysinfo.ProbeBinderHost()
+	if !b.BinderFSInProcFS && !b.LegacyBinderCharDevs && !b.BinderFSBinderDevs {
+		problems = append(problems, "no binder device nodes to pass with --device and no binderfs support")
+	}
+
+	return problems
+}
+
+// restrictedBootDiagnostics explains a failed boot under the restricted profile.
+func restrictedBootDiagnostics(runtime Runtime, containerName string, cause error) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Container '%s' failed to boot with the restricted security profile: %v\n", containerName, cause)
+	if problems := RestrictedProfileProblems(runtime); len(problems) > 0 {
+		b.WriteString("Host problems detected:\n")
+		for _, p := range problems {
+			fmt.Fprintf(&b, "  - %s\n", p)
+		}
+	} else {
+		b.WriteString("The host looks compatible; the image may need a capability or device not in the restricted set.\n")
+	}
+	fmt.Fprintf(&b, "Run `reddock doctor` for details, or `reddock init %s <image> --profile %s` to switch permanently.",
+		containerName, config.SecurityProfilePrivileged)
+	return b.String()
+}
@@ -0,0 +1,476 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"reddock/pkg/config"
+	"reddock/pkg/ui"
+)
+
+// statfs f_type values of filesystems with native snapshots.
+const (
+	btrfsSuperMagic = 0x9123683e
+	zfsSuperMagic   = 0x2fc12fc1
+	// btrfsSubvolumeIno is the inode number of every btrfs subvolume root.
+	btrfsSubvolumeIno = 256
+)
+
+// tarDataFlags keep ownership, ACLs, xattrs and SELinux contexts of Android's /data.
+var tarDataFlags = []string{"--xattrs", "--xattrs-include=*", "--acls", "--numeric-owner"}
+
+// SnapshotManager creates, restores and deletes data directory snapshots of one instance.
+type SnapshotManager struct {
+	config        *config.Config
+	runtime       Runtime
+	containerName string
+}
+
+func NewSnapshotManager(containerName string) *SnapshotManager {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Warning: Failed to load config: %v\n", err)
+		cfg = config.GetDefault()
+	}
+	return &SnapshotManager{config: cfg, runtime: NewRuntime(), containerName: containerName}
+}
+
+func (s *SnapshotManager) instance() (*config.Container, error) {
+	c := s.config.GetContainer(s.containerName)
+	if c == nil {
+		return nil, fmt.Errorf("Container '%s' not found", s.containerName)
+	}
+	return c, nil
+}
+
+func (s *SnapshotManager) manager() *Manager {
+	return &Manager{runtime: s.runtime, config: s.config, containerName: s.containerName}
+}
+
+// Create snapshots the data directory. method is "" (pick the best for the filesystem) or
+// one of the config.SnapshotMethod* values. Archives need a stopped instance, so a running
+// instance is stopped and started again afterwards.
+func (s *SnapshotManager) Create(label, method string) (*config.Snapshot, error) {
+	if err := CheckRoot(); err != nil {
+		return nil, err
+	}
+	c, err := s.instance()
+	if err != nil {
+		return nil, err
+	}
+	if method == "" {
+		method = SnapshotMethodFor(c.GetDataPath())
+	}
+
+	restart := false
+	if method == config.SnapshotMethodTar && s.runtime.IsRunning(c.Name) {
+		fmt.Printf("Stopping '%s' for a consistent snapshot...\n", c.Name)
+		if err := s.manager().Stop(); err != nil {
+			return nil, err
+		}
+		restart = true
+	}
+
+	sp := ui.NewSpinner(fmt.Sprintf("Creating %s snapshot of %s...", method, c.GetDataPath()))
+	sp.Start()
+	snap, err := createSnapshot(c, method, label)
+	if err != nil {
+		sp.Finish("Snapshot failed")
+	} else {
+		sp.Finish(fmt.Sprintf("Snapshot %s created (%s)", snap.ID, snap.Location))
+		err = config.Save(s.config)
+	}
+
+	if restart {
+		if startErr := s.manager().Start(false); startErr != nil {
+			fmt.Printf("Warning: could not restart '%s': %v\n", c.Name, startErr)
+		}
+	}
+	return snap, err
+}
+
+// List prints the snapshots of the instance, oldest first.
+func (s *SnapshotManager) List() error {
+	c, err := s.instance()
+	if err != nil {
+		return err
+	}
+	if len(c.Snapshots) == 0 {
+		fmt.Printf("No snapshots for '%s'. Create one with: reddock snapshot create %s\n", c.Name, c.Name)
+		return nil
+	}
+	fmt.Printf("%-17s %-17s %-7s %10s  %-10s %s\n", "ID", "CREATED", "METHOD", "SIZE", "ANDROID", "LABEL")
+	fmt.Println(strings.Repeat("-", 90))
+	for _, snap := range c.Snapshots {
+		size := "-"
+		if snap.Size > 0 {
+			size = ui.FormatBytes(snap.Size)
+		}
+		android := snap.AndroidVersion
+		if android == "" {
+			android = "-"
+		}
+		fmt.Printf("%-17s %-17s %-7s %10s  %-10s %s\n", snap.ID, snap.Created.Local().Format("2006-01-02 15:04"),
+			snap.Method, size, android, snap.Label)
+	}
+	return nil
+}
+
+// Restore replaces the data directory with snapshot id. The instance is stopped for the
+// restore and started again if it was running.
+func (s *SnapshotManager) Restore(id string, yes bool) error {
+	if err := CheckRoot(); err != nil {
+		return err
+	}
+	c, err := s.instance()
+	if err != nil {
+		return err
+	}
+	snap := c.FindSnapshot(id)
+	if snap == nil {
+		return fmt.Errorf("Snapshot '%s' not found for '%s' (see reddock snapshot list %s)", id, c.Name, c.Name)
+	}
+
+	from, okFrom := androidMajor(snap.AndroidVersion)
+	to, okTo := androidMajor(c.AndroidVersion)
+	if okFrom && okTo && from > to {
+		fmt.Printf("Warning: snapshot %s was taken on Android %d but '%s' now runs Android %d; older Android\n", snap.ID, from, c.Name, to)
+		fmt.Println("releases cannot read newer /data. Switch the image back (reddock upgrade) after restoring.")
+	}
+	if !yes {
+		fmt.Printf("Replace %s with snapshot %s? Current data will be lost. [y/N]: ", c.GetDataPath(), snap.ID)
+		var response string
+		fmt.Scanln(&response)
+		if response != "y" && response != "Y" && response != "yes" {
+			return fmt.Errorf("Restore aborted")
+		}
+	}
+
+	restart := s.runtime.IsRunning(c.Name)
+	if s.runtime.Exists(c.Name) {
+		if err := s.manager().Stop(); err != nil {
+			return err
+		}
+	}
+
+	sp := ui.NewSpinner(fmt.Sprintf("Restoring snapshot %s...", snap.ID))
+	sp.Start()
+	if err := restoreSnapshot(c, snap); err != nil {
+		sp.Finish("Restore failed")
+		return err
+	}
+	sp.Finish(fmt.Sprintf("Restored %s from snapshot %s", c.GetDataPath(), snap.ID))
+
+	if restart {
+		return s.manager().Start(false)
+	}
+	return nil
+}
+
+// Delete removes snapshot id and its record.
+func (s *SnapshotManager) Delete(id string) error {
+	if err := CheckRoot(); err != nil {
+		return err
+	}
+	c, err := s.instance()
+	if err != nil {
+		return err
+	}
+	snap := c.FindSnapshot(id)
+	if snap == nil {
+		return fmt.Errorf("Snapshot '%s' not found for '%s'", id, c.Name)
+	}
+	if err := deleteSnapshot(c, snap); err != nil {
+		return err
+	}
+	if prev := c.PreviousImage; prev != nil && prev.Snapshot == id {
+		prev.Snapshot = ""
+	}
+	c.RemoveSnapshot(id)
+	if err := config.Save(s.config); err != nil {
+		return fmt.Errorf("Failed to save the config: %v", err)
+	}
+	fmt.Printf("Snapshot %s deleted\n", id)
+	return nil
+}
+
+// SnapshotMethodFor picks a native snapshot when the data directory is a btrfs subvolume
+// or a ZFS dataset mountpoint, and a tar archive otherwise (including overlayfs, which has
+// no snapshot primitive of its own).
+func SnapshotMethodFor(dataPath string) string {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dataPath, &st); err != nil {
+		return config.SnapshotMethodTar
+	}
+	switch uint32(st.Type) {
+	case btrfsSuperMagic:
+		if isBtrfsSubvolume(dataPath) {
+			if _, err := exec.LookPath("btrfs"); err == nil {
+				return config.SnapshotMethodBtrfs
+			}
+		}
+	case zfsSuperMagic:
+		if dataset, _ := zfsDataset(dataPath); dataset != "" {
+			return config.SnapshotMethodZFS
+		}
+	}
+	return config.SnapshotMethodTar
+}
+
+func isBtrfsSubvolume(path string) bool {
+	var st syscall.Stat_t
+	return syscall.Stat(path, &st) == nil && st.Ino == btrfsSubvolumeIno
+}
+
+// zfsDataset returns the dataset mounted exactly at path and its mountpoint.
+func zfsDataset(path string) (string, string) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", ""
+	}
+	out, err := exec.Command("zfs", "list", "-H", "-o", "name,mountpoint").Output()
+	if err != nil {
+		return "", ""
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		name, mountpoint, ok := strings.Cut(scanner.Text(), "\t")
+		if ok && filepath.Clean(mountpoint) == abs {
+			return name, mountpoint
+		}
+	}
+	return "", ""
+}
+
+// snapshotDir holds archives and btrfs snapshots next to the data directory.
+func snapshotDir(c *config.Container) string {
+	return strings.TrimRight(c.GetDataPath(), "/") + ".snapshots"
+}
+
+func newSnapshotID(c *config.Container, now time.Time) string {
+	id := now.Format("20060102-150405")
+	for n := 2; c.FindSnapshot(id) != nil; n++ {
+		id = fmt.Sprintf("%s-%d", now.Format("20060102-150405"), n)
+	}
+	return id
+}
+
+// createSnapshot snapshots the data directory with method and records it on c. The caller
+// stops the instance first for tar snapshots and saves the config.
+func createSnapshot(c *config.Container, method, label string) (*config.Snapshot, error) {
+	dataPath := c.GetDataPath()
+	if err := MountStorage(c); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dataPath); err != nil {
+		return nil, fmt.Errorf("Data directory %s does not exist", dataPath)
+	}
+	now := time.Now().UTC()
+	snap := config.Snapshot{
+		ID:             newSnapshotID(c, now),
+		Created:        now,
+		Method:         method,
+		Label:          label,
+		ImageURL:       c.ImageURL,
+		AndroidVersion: c.AndroidVersion,
+	}
+	if err := os.MkdirAll(snapshotDir(c), 0700); err != nil {
+		return nil, fmt.Errorf("Failed to create snapshot directory: %v", err)
+	}
+
+	switch method {
+	case config.SnapshotMethodBtrfs:
+		snap.Location = filepath.Join(snapshotDir(c), snap.ID)
+		if out, err := exec.Command("btrfs", "subvolume", "snapshot", "-r", dataPath, snap.Location).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("btrfs snapshot failed: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+	case config.SnapshotMethodZFS:
+		dataset, _ := zfsDataset(dataPath)
+		if dataset == "" {
+			return nil, fmt.Errorf("%s is not a ZFS dataset mountpoint", dataPath)
+		}
+		snap.Location = fmt.Sprintf("%s@reddock-%s-%s", dataset, c.Name, snap.ID)
+		if out, err := exec.Command("zfs", "snapshot", snap.Location).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("zfs snapshot failed: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+	case config.SnapshotMethodTar:
+		archive, err := ArchiveDataDir(dataPath, filepath.Join(snapshotDir(c), snap.ID))
+		if err != nil {
+			return nil, err
+		}
+		snap.Location = archive
+		if st, err := os.Stat(archive); err == nil {
+			snap.Size = st.Size()
+		}
+	default:
+		return nil, fmt.Errorf("Unknown snapshot method '%s' (use %s, %s or %s)", method,
+			config.SnapshotMethodTar, config.SnapshotMethodBtrfs, config.SnapshotMethodZFS)
+	}
+
+	c.Snapshots = append(c.Snapshots, snap)
+	return &c.Snapshots[len(c.Snapshots)-1], nil
+}
+
+// restoreSnapshot replaces the data directory with snap. The instance must be stopped.
+func restoreSnapshot(c *config.Container, snap *config.Snapshot) error {
+	dataPath := strings.TrimRight(c.GetDataPath(), "/")
+	old := dataPath + ".reddock-old"
+	os.RemoveAll(old)
+	if err := MountStorage(c); err != nil {
+		return err
+	}
+
+	switch snap.Method {
+	case config.SnapshotMethodBtrfs:
+		if err := os.Rename(dataPath, old); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to move %s aside: %v", dataPath, err)
+		}
+		if out, err := exec.Command("btrfs", "subvolume", "snapshot", snap.Location, dataPath).CombinedOutput(); err != nil {
+			os.Rename(old, dataPath)
+			return fmt.Errorf("btrfs restore failed: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+		if isBtrfsSubvolume(old) {
+			exec.Command("btrfs", "subvolume", "delete", old).Run()
+		}
+		return os.RemoveAll(old)
+	case config.SnapshotMethodZFS:
+		// Copy out of .zfs/snapshot rather than `zfs rollback`, which would destroy newer snapshots.
+		dataset, mountpoint := zfsDataset(dataPath)
+		name := strings.TrimPrefix(snap.Location, dataset+"@")
+		if dataset == "" || name == snap.Location {
+			return fmt.Errorf("ZFS dataset for snapshot %s is no longer mounted at %s", snap.Location, dataPath)
+		}
+		if err := clearDir(dataPath); err != nil {
+			return err
+		}
+		src := filepath.Join(mountpoint, ".zfs", "snapshot", name) + "/."
+		if out, err := exec.Command("cp", "-a", src, dataPath+"/").CombinedOutput(); err != nil {
+			return fmt.Errorf("ZFS restore failed: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	case config.SnapshotMethodTar:
+		if c.StorageBackend() != config.StorageDir {
+			// Volume directories and image mountpoints cannot be swapped by rename.
+			if err := clearDir(dataPath); err != nil {
+				return err
+			}
+			return ExtractDataDir(snap.Location, dataPath)
+		}
+		staging := dataPath + ".reddock-restore"
+		os.RemoveAll(staging)
+		if err := os.MkdirAll(staging, 0755); err != nil {
+			return err
+		}
+		if err := ExtractDataDir(snap.Location, staging); err != nil {
+			os.RemoveAll(staging)
+			return err
+		}
+		if err := os.Rename(dataPath, old); err != nil && !os.IsNotExist(err) {
+			os.RemoveAll(staging)
+			return fmt.Errorf("Failed to move %s aside: %v", dataPath, err)
+		}
+		if err := os.Rename(staging, dataPath); err != nil {
+			os.Rename(old, dataPath)
+			return fmt.Errorf("Failed to move restored data into place: %v", err)
+		}
+		return os.RemoveAll(old)
+	default:
+		return fmt.Errorf("Unknown snapshot method '%s'", snap.Method)
+	}
+}
+
+// deleteSnapshot removes the snapshot's storage; the caller drops the record.
+func deleteSnapshot(c *config.Container, snap *config.Snapshot) error {
+	switch snap.Method {
+	case config.SnapshotMethodBtrfs:
+		if _, err := os.Stat(snap.Location); os.IsNotExist(err) {
+			return nil
+		}
+		if out, err := exec.Command("btrfs", "subvolume", "delete", snap.Location).CombinedOutput(); err != nil {
+			return fmt.Errorf("btrfs subvolume delete failed: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+	case config.SnapshotMethodZFS:
+		if out, err := exec.Command("zfs", "destroy", snap.Location).CombinedOutput(); err != nil &&
+			!strings.Contains(string(out), "could not find") {
+			return fmt.Errorf("zfs destroy failed: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+	default:
+		if err := os.Remove(snap.Location); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to delete %s: %v", snap.Location, err)
+		}
+	}
+	// Drop the snapshot directory once it is empty.
+	os.Remove(snapshotDir(c))
+	return nil
+}
+
+// deleteAllSnapshots removes every snapshot of c (used when the instance is removed).
+func deleteAllSnapshots(c *config.Container) {
+	for i := range c.Snapshots {
+		if err := deleteSnapshot(c, &c.Snapshots[i]); err != nil {
+			fmt.Printf("\nWarning: %v\n", err)
+		}
+	}
+	c.Snapshots = nil
+}
+
+// clearDir empties dir without removing it (it may be a mountpoint).
+func clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s: %v", dir, err)
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return fmt.Errorf("Failed to clear %s: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+// ArchiveDataDir writes a compressed tar of dataPath to base+".tar.zst" (".tar.gz" when
+// zstd is not installed), keeping ownership, ACLs, xattrs and SELinux contexts. It returns
+// the archive path.
+func ArchiveDataDir(dataPath, base string) (string, error) {
+	compress, ext := "--zstd", ".tar.zst"
+	if _, err := exec.LookPath("zstd"); err != nil {
+		fmt.Println("\nWarning: zstd not found; falling back to gzip compression.")
+		compress, ext = "--gzip", ".tar.gz"
+	}
+	archive := base + ext
+	args := append([]string{"--create", compress, "--file", archive}, tarDataFlags...)
+	args = append(args, tarSELinuxFlags()...)
+	args = append(args, "-C", dataPath, ".")
+	if out, err := exec.Command("tar", args...).CombinedOutput(); err != nil {
+		os.Remove(archive)
+		return "", fmt.Errorf("tar failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return archive, nil
+}
+
+// ExtractDataDir unpacks an archive written by ArchiveDataDir into dst (tar detects the
+// compression).
+func ExtractDataDir(archive, dst string) error {
+	args := append([]string{"--extract", "--file", archive, "--same-permissions"}, tarDataFlags...)
+	args = append(args, tarSELinuxFlags()...)
+	args = append(args, "-C", dst)
+	if out, err := exec.Command("tar", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("tar extract failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// tarSELinuxFlags adds --selinux when the host tar was built with SELinux support.
+func tarSELinuxFlags() []string {
+	out, err := exec.Command("tar", "--help").Output()
+	if err == nil && strings.Contains(string(out), "--selinux") {
+		return []string{"--selinux"}
+	}
+	return nil
+}
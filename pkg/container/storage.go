@@ -0,0 +1,393 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"reddock/pkg/config"
+	"reddock/pkg/ui"
+)
+
+// StorageUsage reports how much of an instance's /data backend is used. Capacity is zero
+// for backends without a fixed size.
+type StorageUsage struct {
+	Backend   string
+	Location  string // data directory, Docker volume name or image file
+	Capacity  int64
+	Used      int64
+	Allocated int64 // host disk space taken by the image file (it is sparse)
+}
+
+// StorageManager resizes and reports on an instance's /data backend.
+type StorageManager struct {
+	config        *config.Config
+	runtime       Runtime
+	containerName string
+}
+
+func NewStorageManager(containerName string) *StorageManager {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Warning: Failed to load config: %v\n", err)
+		cfg = config.GetDefault()
+	}
+	return &StorageManager{config: cfg, runtime: NewRuntime(), containerName: containerName}
+}
+
+func (s *StorageManager) instance() (*config.Container, error) {
+	c := s.config.GetContainer(s.containerName)
+	if c == nil {
+		return nil, fmt.Errorf("Container '%s' not found", s.containerName)
+	}
+	return c, nil
+}
+
+// Usage returns the backend's capacity and usage.
+func (s *StorageManager) Usage() (*StorageUsage, error) {
+	c, err := s.instance()
+	if err != nil {
+		return nil, err
+	}
+	return GetStorageUsage(c)
+}
+
+// Resize grows or shrinks an image-backed data volume. The instance must be stopped;
+// the filesystem is checked and resized offline. Shrinking fails (and leaves the image
+// untouched) when the data does not fit.
+func (s *StorageManager) Resize(size int64) error {
+	if err := CheckRoot(); err != nil {
+		return err
+	}
+	c, err := s.instance()
+	if err != nil {
+		return err
+	}
+	if c.StorageBackend() != config.StorageImage {
+		return fmt.Errorf("'%s' uses %s storage, which has no fixed size; only image storage can be resized", c.Name, c.StorageBackend())
+	}
+	if s.runtime.IsRunning(c.Name) {
+		return fmt.Errorf("Container '%s' is running; stop it first", c.Name)
+	}
+	size = alignStorageSize(size)
+	img := c.StorageImagePath()
+
+	sp := ui.NewSpinner(fmt.Sprintf("Resizing %s to %s...", img, ui.FormatBytes(size)))
+	sp.Start()
+	err = s.resizeImage(c, size)
+	if mountErr := MountStorage(c); err == nil {
+		err = mountErr
+	}
+	if err != nil {
+		sp.Finish("Resize failed")
+		return err
+	}
+	sp.Finish(fmt.Sprintf("Data volume of '%s' is now %s", c.Name, ui.FormatBytes(size)))
+
+	c.StorageSize = size
+	if err := config.Save(s.config); err != nil {
+		return fmt.Errorf("Failed to save the config: %v", err)
+	}
+	return nil
+}
+
+func (s *StorageManager) resizeImage(c *config.Container, size int64) error {
+	img := c.StorageImagePath()
+	st, err := os.Stat(img)
+	if err != nil {
+		return fmt.Errorf("Data image %s not found: %v", img, err)
+	}
+	if err := unmountStorage(c); err != nil {
+		return err
+	}
+	if err := checkExt4(img); err != nil {
+		return err
+	}
+	if size > st.Size() {
+		if err := os.Truncate(img, size); err != nil {
+			return fmt.Errorf("Failed to grow %s: %v", img, err)
+		}
+	}
+	if out, err := exec.Command("resize2fs", img, fmt.Sprintf("%dK", size>>10)).CombinedOutput(); err != nil {
+		if size > st.Size() {
+			os.Truncate(img, st.Size())
+		}
+		return fmt.Errorf("resize2fs failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	if size < st.Size() {
+		if err := os.Truncate(img, size); err != nil {
+			return fmt.Errorf("Failed to shrink %s: %v", img, err)
+		}
+	}
+	return nil
+}
+
+// DataVolumeName is the Docker volume new volume-backed instances keep /data in.
+func DataVolumeName(name string) string {
+	return "reddock-" + name + "-data"
+}
+
+// createStorage prepares a new instance's backend and sets its data path on the host.
+func createStorage(runtime Runtime, c *config.Container) error {
+	switch c.StorageBackend() {
+	case config.StorageVolume:
+		if c.Volume == "" {
+			c.Volume = DataVolumeName(c.Name)
+		}
+		if out, err := runtime.Command("volume", "create", "--label", "reddock.instance="+c.Name, c.Volume).CombinedOutput(); err != nil {
+			return fmt.Errorf("Failed to create volume %s: %v: %s", c.Volume, err, strings.TrimSpace(string(out)))
+		}
+		out, err := runtime.Command("volume", "inspect", "--format", "{{.Mountpoint}}", c.Volume).Output()
+		if err != nil {
+			return fmt.Errorf("Failed to inspect volume %s: %v", c.Volume, err)
+		}
+		// Host-side operations (snapshots, reset, export) work on the volume's directory.
+		c.DataPath = strings.TrimSpace(string(out))
+		return nil
+	case config.StorageImage:
+		if c.StorageSize == 0 {
+			c.StorageSize = config.DefaultStorageSize
+		}
+		c.StorageSize = alignStorageSize(c.StorageSize)
+		img := c.StorageImagePath()
+		if _, err := os.Stat(img); os.IsNotExist(err) {
+			if err := createExt4Image(img, c.StorageSize); err != nil {
+				return err
+			}
+		}
+		return MountStorage(c)
+	default:
+		return os.MkdirAll(c.GetDataPath(), 0755)
+	}
+}
+
+func createExt4Image(img string, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(img), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(img, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to create %s: %v", img, err)
+	}
+	f.Close()
+	// Sparse: blocks are allocated as Android writes data.
+	if err := os.Truncate(img, size); err != nil {
+		os.Remove(img)
+		return fmt.Errorf("Failed to size %s: %v", img, err)
+	}
+	if out, err := exec.Command("mkfs.ext4", "-q", "-F", "-L", "reddock-data", img).CombinedOutput(); err != nil {
+		os.Remove(img)
+		return fmt.Errorf("mkfs.ext4 failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// MountStorage loop-mounts the data image of image-backed instances at their data path
+// (after a reboot, for example). Other backends need no preparation.
+func MountStorage(c *config.Container) error {
+	if c.StorageBackend() != config.StorageImage {
+		return nil
+	}
+	dataPath := c.GetDataPath()
+	if isMountpoint(dataPath) {
+		return nil
+	}
+	if err := os.MkdirAll(dataPath, 0755); err != nil {
+		return fmt.Errorf("Failed to create mountpoint %s: %v", dataPath, err)
+	}
+	if out, err := exec.Command("mount", "-o", "loop", c.StorageImagePath(), dataPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to mount %s on %s: %v: %s", c.StorageImagePath(), dataPath, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func unmountStorage(c *config.Container) error {
+	if c.StorageBackend() != config.StorageImage || !isMountpoint(c.GetDataPath()) {
+		return nil
+	}
+	if out, err := exec.Command("umount", c.GetDataPath()).CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to unmount %s: %v: %s", c.GetDataPath(), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// RemoveStorage deletes the instance's data backend and everything in it.
+func RemoveStorage(c *config.Container) error {
+	return removeStorage(NewRuntime(), c)
+}
+
+// removeStorage deletes the backend and everything in it.
+func removeStorage(runtime Runtime, c *config.Container) error {
+	switch c.StorageBackend() {
+	case config.StorageVolume:
+		if out, err := runtime.Command("volume", "rm", "--force", c.Volume).CombinedOutput(); err != nil {
+			return fmt.Errorf("Failed to remove volume %s: %v: %s", c.Volume, err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	case config.StorageImage:
+		if err := unmountStorage(c); err != nil {
+			return err
+		}
+		if err := os.Remove(c.StorageImagePath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return os.RemoveAll(c.GetDataPath())
+	default:
+		return os.RemoveAll(c.GetDataPath())
+	}
+}
+
+// dataMountArg is the `docker run -v` value for /data.
+func dataMountArg(c *config.Container) string {
+	if c.StorageBackend() == config.StorageVolume {
+		return c.Volume + ":/data"
+	}
+	return c.GetDataPath() + ":/data:z"
+}
+
+// requireDirStorage refuses operations that move the data directory of other backends.
+func requireDirStorage(c *config.Container, action string) error {
+	if b := c.StorageBackend(); b != config.StorageDir {
+		return fmt.Errorf("Cannot %s: '%s' keeps its data in %s storage", action, c.Name, b)
+	}
+	return nil
+}
+
+// GetStorageUsage measures the backend: filesystem usage of image-backed instances (which
+// are mounted first), and the allocated size of the data directory otherwise.
+func GetStorageUsage(c *config.Container) (*StorageUsage, error) {
+	return measureStorage(c, true)
+}
+
+// measureStorage is GetStorageUsage; without mount, an image that is not mounted reports
+// the space its sparse file takes on the host as used and its size as capacity.
+func measureStorage(c *config.Container, mount bool) (*StorageUsage, error) {
+	u := &StorageUsage{Backend: c.StorageBackend(), Location: c.GetDataPath()}
+	switch u.Backend {
+	case config.StorageVolume:
+		u.Location = c.Volume
+	case config.StorageImage:
+		u.Location = c.StorageImagePath()
+		if !mount && !isMountpoint(c.GetDataPath()) {
+			var fst syscall.Stat_t
+			if err := syscall.Stat(u.Location, &fst); err != nil {
+				return nil, err
+			}
+			u.Capacity, u.Allocated = fst.Size, fst.Blocks*512
+			u.Used = u.Allocated
+			return u, nil
+		}
+		if err := MountStorage(c); err != nil {
+			return nil, err
+		}
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(c.GetDataPath(), &st); err != nil {
+			return nil, err
+		}
+		u.Capacity = int64(st.Blocks) * st.Bsize
+		u.Used = int64(st.Blocks-st.Bfree) * st.Bsize
+		var fst syscall.Stat_t
+		if err := syscall.Stat(u.Location, &fst); err == nil {
+			u.Allocated = fst.Blocks * 512
+		}
+		return u, nil
+	}
+	used, err := DirSize(c.GetDataPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	u.Used = used
+	return u, nil
+}
+
+// DirSize is the disk space allocated to the files below path (hard links counted once).
+func DirSize(path string) (int64, error) {
+	var total int64
+	seen := make(map[uint64]bool)
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == path {
+				return err
+			}
+			return nil
+		}
+		var st syscall.Stat_t
+		if syscall.Lstat(p, &st) != nil {
+			return nil
+		}
+		if st.Nlink > 1 && st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+			if seen[st.Ino] {
+				return nil
+			}
+			seen[st.Ino] = true
+		}
+		total += st.Blocks * 512
+		return nil
+	})
+	return total, err
+}
+
+// alignStorageSize rounds up to a whole MiB, which keeps resize2fs and truncate in step.
+func alignStorageSize(size int64) int64 {
+	const mib = 1 << 20
+	return (size + mib - 1) / mib * mib
+}
+
+// checkExt4 runs a forced e2fsck, which resize2fs requires. Exit codes 1 and 2 mean
+// errors were corrected.
+func checkExt4(img string) error {
+	out, err := exec.Command("e2fsck", "-f", "-y", img).CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() <= 2 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("e2fsck failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// isMountpoint reports whether path is listed as a mountpoint in /proc/self/mounts.
+func isMountpoint(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && unescapeMountField(fields[1]) == abs {
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeMountField decodes the octal escapes (\040 for a space etc.) used in /proc/mounts.
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			var v byte
+			if _, err := fmt.Sscanf(s[i+1:i+4], "%03o", &v); err == nil {
+				b.WriteByte(v)
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
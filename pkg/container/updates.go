@@ -0,0 +1,97 @@
+package container
+
+import (
+	"sort"
+	"sync"
+
+	"reddock/pkg/config"
+	"reddock/pkg/registry"
+)
+
+// Image update states reported by CheckImageUpdates.
+const (
+	UpdateCurrent   = "up to date"
+	UpdateAvailable = "outdated"
+	UpdatePinned    = "pinned"  // the reference itself names a digest
+	UpdateLocal     = "local"   // built or loaded locally, no registry digest to compare
+	UpdateUnknown   = "unknown" // registry not reachable or reference not found
+)
+
+// ImageUpdate compares the digest an instance runs with the registry's current digest
+// for the instance's tag.
+type ImageUpdate struct {
+	Container    string
+	ImageURL     string
+	LocalDigest  string
+	RemoteDigest string
+	State        string
+	Err          error
+}
+
+// CheckImageUpdates resolves every tag once (concurrently) and compares it with the
+// digest recorded for each instance.
+func CheckImageUpdates(runtime Runtime, client *registry.Client, containers []*config.Container) []ImageUpdate {
+	updates := make([]ImageUpdate, len(containers))
+	type result struct {
+		digest string
+		err    error
+	}
+	remote := make(map[string]*result)
+	for i, c := range containers {
+		u := ImageUpdate{Container: c.Name, ImageURL: c.ImageURL, LocalDigest: instanceDigest(runtime, c)}
+		ref, err := registry.ParseReference(c.ImageURL)
+		switch {
+		case err != nil:
+			u.State, u.Err = UpdateUnknown, err
+		case ref.Digest != "":
+			u.State = UpdatePinned
+		case u.LocalDigest == "":
+			u.State = UpdateLocal
+		default:
+			remote[c.ImageURL] = &result{}
+		}
+		updates[i] = u
+	}
+
+	var wg sync.WaitGroup
+	for ref, res := range remote {
+		wg.Add(1)
+		go func(ref string, res *result) {
+			defer wg.Done()
+			res.digest, res.err = client.Digest(ref)
+		}(ref, res)
+	}
+	wg.Wait()
+
+	for i := range updates {
+		u := &updates[i]
+		res, ok := remote[u.ImageURL]
+		if !ok || u.State != "" {
+			continue
+		}
+		u.RemoteDigest, u.Err = res.digest, res.err
+		switch {
+		case res.err != nil:
+			u.State = UpdateUnknown
+		case res.digest == u.LocalDigest:
+			u.State = UpdateCurrent
+		default:
+			u.State = UpdateAvailable
+		}
+	}
+	sort.Slice(updates, func(i, j int) bool { return updates[i].Container < updates[j].Container })
+	return updates
+}
+
+// instanceDigest is the registry digest recorded at init, or the one of the local image
+// for instances initialized before digests were recorded.
+func instanceDigest(runtime Runtime, c *config.Container) string {
+	if c.ImageDigest != "" {
+		return c.ImageDigest
+	}
+	meta, err := InspectLocalImage(runtime, c.ImageURL)
+	if err != nil {
+		return ""
+	}
+	return meta.Digest(c.ImageURL)
+}
@@ -0,0 +1,285 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"reddock/pkg/config"
+	"reddock/pkg/ui"
+)
+
+// DefaultBootTimeout is how long upgrade waits for sys.boot_completed.
+const DefaultBootTimeout = 5 * time.Minute
+
+// UpgradeOptions controls `reddock upgrade`.
+type UpgradeOptions struct {
+	Yes        bool          // do not ask before an Android major-version downgrade
+	NoRollback bool          // leave a failed upgrade in place for debugging
+	Timeout    time.Duration // boot wait; DefaultBootTimeout when zero
+}
+
+// Upgrader moves an instance to a new image, keeping a data snapshot (see snapshot.go) and
+// the previous image so a failed boot can be rolled back.
+type Upgrader struct {
+	config        *config.Config
+	runtime       Runtime
+	containerName string
+	opts          UpgradeOptions
+}
+
+func NewUpgrader(containerName string, opts UpgradeOptions) *Upgrader {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Warning: Failed to load config: %v\n", err)
+		cfg = config.GetDefault()
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultBootTimeout
+	}
+	return &Upgrader{
+		config:        cfg,
+		runtime:       NewRuntime(),
+		containerName: containerName,
+		opts:          opts,
+	}
+}
+
+func (u *Upgrader) instance() (*config.Container, error) {
+	c := u.config.GetContainer(u.containerName)
+	if c == nil {
+		return nil, fmt.Errorf("Container '%s' not found", u.containerName)
+	}
+	if !c.Initialized {
+		return nil, fmt.Errorf("Container '%s' is not initialized. Run 'reddock init %s' first", u.containerName, u.containerName)
+	}
+	return c, nil
+}
+
+func (u *Upgrader) manager() *Manager {
+	return &Manager{runtime: u.runtime, config: u.config, containerName: u.containerName}
+}
+
+// Upgrade switches the instance to image and waits for Android to boot. On failure the
+// previous image and data snapshot are restored unless NoRollback is set.
+func (u *Upgrader) Upgrade(image string) error {
+	if err := CheckRoot(); err != nil {
+		return err
+	}
+	c, err := u.instance()
+	if err != nil {
+		return err
+	}
+	if err := config.ValidateImageName(image); err != nil {
+		return fmt.Errorf("Invalid image name: %v", err)
+	}
+
+	if err := ensureImage(u.runtime, image, true); err != nil {
+		return err
+	}
+	meta, err := InspectLocalImage(u.runtime, image)
+	if err != nil {
+		return fmt.Errorf("Failed to inspect image '%s': %v", image, err)
+	}
+	if err := CheckImageArch(image, []string{meta.Architecture}); err != nil {
+		return err
+	}
+	next := config.Container{Name: c.Name, ImageURL: image}
+	recordImageMeta(&next, meta)
+	target := next.CurrentImage()
+
+	if target.ImageID == c.ImageID && target.ImageURL == c.ImageURL {
+		fmt.Printf("Container '%s' already runs %s\n", c.Name, image)
+		return nil
+	}
+	if err := u.confirmVersionChange(c.AndroidVersion, target.AndroidVersion); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nUpgrading '%s': %s -> %s\n\n", c.Name, describeImage(c.CurrentImage()), describeImage(target))
+
+	mgr := u.manager()
+	if u.runtime.Exists(c.Name) {
+		if err := mgr.Stop(); err != nil {
+			return err
+		}
+	}
+
+	prev := c.CurrentImage()
+	prev.ReplacedAt = time.Now().UTC()
+	if _, err := os.Stat(c.GetDataPath()); err == nil {
+		s := ui.NewSpinner(fmt.Sprintf("Snapshotting %s...", c.GetDataPath()))
+		s.Start()
+		snap, err := createSnapshot(c, SnapshotMethodFor(c.GetDataPath()), "pre-upgrade to "+image)
+		if err != nil {
+			s.Finish("Snapshot failed")
+			return err
+		}
+		s.Finish(fmt.Sprintf("Data snapshot %s saved to %s", snap.ID, snap.Location))
+		prev.Snapshot = snap.ID
+	}
+	older := c.PreviousImage
+	c.PreviousImage = &prev
+	c.SetImage(target)
+	if err := config.Save(u.config); err != nil {
+		return fmt.Errorf("Failed to save the config: %v", err)
+	}
+
+	bootErr := u.bootAndWait(mgr)
+	if bootErr == nil {
+		// Only the most recent upgrade can be rolled back; the older pre-upgrade snapshot is
+		// dropped once the new image has booted, so a failed upgrade never loses it.
+		if older != nil && older.Snapshot != "" {
+			if snap := c.FindSnapshot(older.Snapshot); snap != nil {
+				if err := deleteSnapshot(c, snap); err != nil {
+					fmt.Printf("Warning: could not remove old snapshot %s: %v\n", snap.ID, err)
+				} else {
+					c.RemoveSnapshot(snap.ID)
+					if err := config.Save(u.config); err != nil {
+						fmt.Printf("Warning: failed to save the config: %v\n", err)
+					}
+				}
+			}
+		}
+		fmt.Printf("\nContainer '%s' upgraded to %s\n", c.Name, image)
+		if prev.Snapshot != "" {
+			fmt.Printf("The pre-upgrade data snapshot %s is kept (reddock snapshot list %s)\n", prev.Snapshot, c.Name)
+		}
+		fmt.Printf("Roll back with: reddock upgrade %s --rollback\n", c.Name)
+		return nil
+	}
+
+	fmt.Printf("\nUpgrade of '%s' failed: %v\n", c.Name, bootErr)
+	if u.opts.NoRollback {
+		return fmt.Errorf("Upgrade failed; '%s' was left on %s for debugging. Roll back with: reddock upgrade %s --rollback",
+			c.Name, image, c.Name)
+	}
+	fmt.Printf("Rolling back to %s...\n\n", prev.ImageURL)
+	if err := u.Rollback(); err != nil {
+		return fmt.Errorf("Upgrade failed (%v) and the rollback failed too: %v", bootErr, err)
+	}
+	return fmt.Errorf("Upgrade to %s failed and '%s' was rolled back to %s: %v", image, c.Name, prev.ImageURL, bootErr)
+}
+
+// Rollback restores the image and data snapshot recorded by the last upgrade.
+func (u *Upgrader) Rollback() error {
+	if err := CheckRoot(); err != nil {
+		return err
+	}
+	c, err := u.instance()
+	if err != nil {
+		return err
+	}
+	prev := c.PreviousImage
+	if prev == nil {
+		return fmt.Errorf("Container '%s' has no previous image to roll back to", c.Name)
+	}
+
+	mgr := u.manager()
+	if u.runtime.Exists(c.Name) {
+		if err := mgr.Stop(); err != nil {
+			return err
+		}
+	}
+
+	if snap := c.FindSnapshot(prev.Snapshot); snap != nil {
+		s := ui.NewSpinner(fmt.Sprintf("Restoring data from snapshot %s...", snap.ID))
+		s.Start()
+		if err := restoreSnapshot(c, snap); err != nil {
+			s.Finish("Restore failed")
+			return err
+		}
+		s.Finish("Data restored")
+		if err := deleteSnapshot(c, snap); err != nil {
+			fmt.Printf("Warning: could not remove snapshot %s: %v\n", snap.ID, err)
+		} else {
+			c.RemoveSnapshot(snap.ID)
+		}
+	} else {
+		fmt.Println("Warning: no data snapshot was recorded; keeping the current data directory.")
+	}
+
+	c.SetImage(*prev)
+	c.PreviousImage = nil
+	if err := config.Save(u.config); err != nil {
+		return fmt.Errorf("Failed to save the config: %v", err)
+	}
+	if err := u.bootAndWait(mgr); err != nil {
+		return err
+	}
+	fmt.Printf("\nContainer '%s' rolled back to %s\n", c.Name, prev.ImageURL)
+	return nil
+}
+
+func (u *Upgrader) bootAndWait(mgr *Manager) error {
+	if err := mgr.Start(false); err != nil {
+		return err
+	}
+	s := ui.NewSpinner(fmt.Sprintf("Waiting for Android to boot (up to %s)...", u.opts.Timeout))
+	s.Start()
+	if err := WaitForBoot(u.runtime, u.containerName, u.opts.Timeout); err != nil {
+		s.Finish("Boot did not complete")
+		return err
+	}
+	s.Finish("Android boot completed")
+	return nil
+}
+
+// confirmVersionChange warns about Android major-version changes. Android cannot read
+// /data written by a newer major release, so downgrades need confirmation.
+func (u *Upgrader) confirmVersionChange(from, to string) error {
+	fromMajor, okFrom := androidMajor(from)
+	toMajor, okTo := androidMajor(to)
+	if !okFrom || !okTo || fromMajor == toMajor {
+		return nil
+	}
+	if toMajor > fromMajor {
+		fmt.Printf("Note: Android %d -> %d; the first boot migrates /data and can take several minutes.\n", fromMajor, toMajor)
+		return nil
+	}
+
+	fmt.Printf("\nWarning: Android %d -> %d is a downgrade. Android does not support reading /data written by a\n", fromMajor, toMajor)
+	fmt.Println("newer major version; the instance will most likely bootloop and be rolled back.")
+	if u.opts.Yes {
+		return nil
+	}
+	fmt.Print("Continue anyway? [y/N]: ")
+	var response string
+	fmt.Scanln(&response)
+	if response != "y" && response != "Y" && response != "yes" {
+		return fmt.Errorf("Upgrade aborted")
+	}
+	return nil
+}
+
+// WaitForBoot polls sys.boot_completed until Android reports a finished boot.
+func WaitForBoot(runtime Runtime, containerName string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if !runtime.IsRunning(containerName) {
+			return fmt.Errorf("container '%s' exited during boot", containerName)
+		}
+		out, err := runtime.Command("exec", containerName, "getprop", "sys.boot_completed").Output()
+		if err == nil && strings.TrimSpace(string(out)) == "1" {
+			return nil
+		}
+		time.Sleep(3 * time.Second)
+	}
+	return fmt.Errorf("Android in '%s' did not finish booting within %s", containerName, timeout)
+}
+
+func androidMajor(version string) (int, bool) {
+	major, _, _ := strings.Cut(version, ".")
+	n, err := strconv.Atoi(major)
+	return n, err == nil
+}
+
+func describeImage(r config.ImageRecord) string {
+	s := r.ImageURL
+	if r.AndroidVersion != "" {
+		s += fmt.Sprintf(" (Android %s)", r.AndroidVersion)
+	}
+	return s
+}
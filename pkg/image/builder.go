@@ -0,0 +1,341 @@
+// Package image builds and catalogs redroid images on top of the docker CLI.
+package image
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"reddock/pkg/config"
+	"reddock/pkg/container"
+	"reddock/pkg/sysinfo"
+	"reddock/pkg/ui"
+)
+
+const (
+	// LabelBuilt marks images produced by `reddock image build`.
+	LabelBuilt = "io.reddock.built"
+	// LabelBase records the official base image of a build.
+	LabelBase = "io.reddock.base"
+	// LabelAddons is the comma-separated list of layered add-ons.
+	LabelAddons = "io.reddock.addons"
+	// LabelAndroidVersion is read back by init to record the instance's Android version.
+	LabelAndroidVersion = "io.reddock.android.version"
+)
+
+// Addon kinds accepted by the builder.
+const (
+	AddonGApps      = "gapps"
+	AddonMagisk     = "magisk"
+	AddonLibNDK     = "libndk"
+	AddonLibHoudini = "libhoudini"
+)
+
+// nativeBridgeABIProps advertise ARM ABIs on an x86_64 base once a translator is present.
+var nativeBridgeABIProps = []string{
+	"ro.product.cpu.abilist=x86_64,x86,arm64-v8a,armeabi-v7a,armeabi",
+	"ro.product.cpu.abilist32=x86,armeabi-v7a,armeabi",
+	"ro.product.cpu.abilist64=x86_64,arm64-v8a",
+	"ro.dalvik.vm.isa.arm=x86",
+	"ro.dalvik.vm.isa.arm64=x86_64",
+	"ro.enable.native.bridge.exec=1",
+	"ro.vendor.enable.native.bridge.exec=1",
+	"ro.vendor.enable.native.bridge.exec64=1",
+}
+
+// addonProps are the build.prop lines each add-on needs.
+var addonProps = map[string][]string{
+	AddonLibNDK: append([]string{
+		"ro.dalvik.vm.native.bridge=libndk_translation.so",
+		"ro.ndk_translation.version=0.2.3",
+	}, nativeBridgeABIProps...),
+	AddonLibHoudini: append([]string{
+		"ro.dalvik.vm.native.bridge=libhoudini.so",
+	}, nativeBridgeABIProps...),
+}
+
+// BuildOptions describes one custom image build. Addons maps an add-on kind to a local
+// archive (zip or tar, optionally compressed) whose contents overlay the image root,
+// e.g. system/priv-app/... for GApps.
+type BuildOptions struct {
+	Base   string
+	Tag    string
+	Addons map[string]string
+}
+
+type Builder struct {
+	runtime container.Runtime
+	opts    BuildOptions
+}
+
+func NewBuilder(opts BuildOptions) *Builder {
+	return &Builder{runtime: container.NewRuntime(), opts: opts}
+}
+
+// addonNames returns the requested add-on kinds in a stable order.
+func (b *Builder) addonNames() []string {
+	var names []string
+	for name := range b.opts.Addons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultTag derives reddock/redroid:<version>-<addons> from the base tag.
+func DefaultTag(base string, addons []string) string {
+	version := strings.TrimSuffix(config.ExtractVersionFromImage(base), "-latest")
+	if version == "" {
+		version = "custom"
+	}
+	return fmt.Sprintf("reddock/redroid:%s-%s", version, strings.Join(addons, "-"))
+}
+
+func (b *Builder) validate() error {
+	if !strings.HasPrefix(b.opts.Base, "redroid/redroid:") {
+		return fmt.Errorf("Base image must be an official redroid/redroid:* image, got '%s'", b.opts.Base)
+	}
+	if len(b.opts.Addons) == 0 {
+		return fmt.Errorf("At least one add-on archive is required (--gapps, --magisk, --libndk or --libhoudini)")
+	}
+	if b.opts.Addons[AddonLibNDK] != "" && b.opts.Addons[AddonLibHoudini] != "" {
+		return fmt.Errorf("--libndk and --libhoudini are both native bridges; pick one")
+	}
+	if (b.opts.Addons[AddonLibNDK] != "" || b.opts.Addons[AddonLibHoudini] != "") && sysinfo.IsARM() {
+		return fmt.Errorf("Native bridges translate ARM code on x86_64; they are not needed on this ARM host")
+	}
+	for name, path := range b.opts.Addons {
+		if _, ok := addonProps[name]; !ok && name != AddonGApps && name != AddonMagisk {
+			return fmt.Errorf("Unknown add-on: %s", name)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("Add-on archive for %s not readable: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Build generates a Docker build context (rootfs overlay + Dockerfile) and tags the result.
+func (b *Builder) Build() (string, error) {
+	if err := container.CheckRoot(); err != nil {
+		return "", err
+	}
+	if err := b.validate(); err != nil {
+		return "", err
+	}
+	addons := b.addonNames()
+	tag := b.opts.Tag
+	if tag == "" {
+		tag = DefaultTag(b.opts.Base, addons)
+	}
+
+	ctxDir, err := os.MkdirTemp("", "reddock-build-")
+	if err != nil {
+		return "", fmt.Errorf("Failed to create build context: %v", err)
+	}
+	defer os.RemoveAll(ctxDir)
+	rootfs := filepath.Join(ctxDir, "rootfs")
+
+	if _, err := InspectOrPull(b.runtime, b.opts.Base); err != nil {
+		return "", err
+	}
+
+	bar := ui.NewProgressBar(len(addons)+2, "Building image...")
+	bar.Start()
+	built := false
+	defer func() {
+		if !built {
+			bar.Finish("Image build failed")
+		}
+	}()
+
+	for _, name := range addons {
+		bar.SetMessage(fmt.Sprintf("Unpacking %s add-on", name))
+		if err := extractArchive(b.opts.Addons[name], rootfs); err != nil {
+			return "", fmt.Errorf("Failed to unpack %s add-on: %v", name, err)
+		}
+		bar.Increment()
+	}
+
+	bar.SetMessage("Writing build properties")
+	var props []string
+	for _, name := range addons {
+		props = append(props, addonProps[name]...)
+	}
+	if len(props) > 0 {
+		if err := b.writeBuildProp(rootfs, props); err != nil {
+			return "", err
+		}
+	}
+	if err := b.writeDockerfile(ctxDir, addons); err != nil {
+		return "", err
+	}
+	bar.Increment()
+
+	bar.SetMessage(fmt.Sprintf("Running docker build for %s", tag))
+	if out, err := b.runtime.Command("build", "-t", tag, ctxDir).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("docker build failed: %v\n%s", err, strings.TrimSpace(string(out)))
+	}
+	built = true
+	bar.Finish(fmt.Sprintf("Built %s from %s (%s)", tag, b.opts.Base, strings.Join(addons, ", ")))
+	return tag, nil
+}
+
+// writeBuildProp copies /system/build.prop out of the base image and appends props,
+// replacing any existing keys.
+func (b *Builder) writeBuildProp(rootfs string, props []string) error {
+	tmpName := fmt.Sprintf("reddock-build-%d", os.Getpid())
+	if out, err := b.runtime.Command("create", "--name", tmpName, b.opts.Base).CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to create base container: %v\n%s", err, strings.TrimSpace(string(out)))
+	}
+	defer b.runtime.Remove(tmpName, true)
+
+	dst := filepath.Join(rootfs, "system", "build.prop")
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if out, err := b.runtime.Command("cp", tmpName+":/system/build.prop", dst).CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to read build.prop from base image: %v\n%s", err, strings.TrimSpace(string(out)))
+	}
+
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]bool)
+	for _, p := range props {
+		k, _, _ := strings.Cut(p, "=")
+		keys[k] = true
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		k, _, _ := strings.Cut(line, "=")
+		if keys[strings.TrimSpace(k)] {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "", "# Added by reddock image build")
+	lines = append(lines, props...)
+	return os.WriteFile(dst, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+func (b *Builder) writeDockerfile(ctxDir string, addons []string) error {
+	var df strings.Builder
+	fmt.Fprintf(&df, "FROM %s\n", b.opts.Base)
+	df.WriteString("COPY rootfs/ /\n")
+	fmt.Fprintf(&df, "LABEL %s=\"true\" %s=%q %s=%q", LabelBuilt, LabelBase, b.opts.Base, LabelAddons, strings.Join(addons, ","))
+	if v := config.AndroidVersionFromImage(b.opts.Base); v != "" {
+		fmt.Fprintf(&df, " %s=%q", LabelAndroidVersion, v)
+	}
+	df.WriteString("\n")
+	return os.WriteFile(filepath.Join(ctxDir, "Dockerfile"), []byte(df.String()), 0644)
+}
+
+// BuiltImage is a locally built image found through its reddock labels.
+type BuiltImage struct {
+	Ref    string
+	Base   string
+	Addons string
+}
+
+// ListBuilt returns images produced by `reddock image build`.
+func ListBuilt(runtime container.Runtime) []BuiltImage {
+	out, err := runtime.Command("images", "--filter", "label="+LabelBuilt+"=true",
+		"--format", "{{.Repository}}:{{.Tag}}").Output()
+	if err != nil {
+		return nil
+	}
+	var images []BuiltImage
+	for _, ref := range strings.Fields(string(out)) {
+		if strings.HasSuffix(ref, ":<none>") {
+			continue
+		}
+		img := BuiltImage{Ref: ref}
+		if meta, err := container.InspectLocalImage(runtime, ref); err == nil {
+			img.Base = meta.Labels[LabelBase]
+			img.Addons = meta.Labels[LabelAddons]
+		}
+		images = append(images, img)
+	}
+	return images
+}
+
+// InspectOrPull makes sure ref is available locally, pulling it when missing.
+func InspectOrPull(runtime container.Runtime, ref string) (*container.ImageMeta, error) {
+	if meta, err := container.InspectLocalImage(runtime, ref); err == nil {
+		return meta, nil
+	}
+	if err := runtime.PullImage(ref); err != nil {
+		return nil, fmt.Errorf("Failed to pull %s: %v", ref, err)
+	}
+	return container.InspectLocalImage(runtime, ref)
+}
+
+// extractArchive unpacks a zip with Go and anything else with tar (which detects compression).
+func extractArchive(archive, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	if strings.HasSuffix(strings.ToLower(archive), ".zip") {
+		return extractZip(archive, dst)
+	}
+	if out, err := exec.Command("tar", "-xf", archive, "-C", dst).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func extractZip(archive, dst string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		target := filepath.Join(dst, f.Name)
+		if !strings.HasPrefix(target, filepath.Clean(dst)+string(os.PathSeparator)) {
+			return fmt.Errorf("Archive entry escapes destination: %s", f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := writeZipEntry(f, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeZipEntry(f *zip.File, target string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	mode := f.Mode().Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,302 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"reddock/pkg/config"
+	"reddock/pkg/container"
+	"reddock/pkg/ui"
+)
+
+// BundleVersion is the image bundle format reddock reads and writes.
+const BundleVersion = 1
+
+// A bundle is an uncompressed tar holding, in order, the metadata, a sha256sum-style
+// checksum manifest and the gzip-compressed `docker save` output.
+const (
+	bundleMetadataName  = "reddock-bundle.json"
+	bundleChecksumsName = "SHA256SUMS"
+	bundleImageName     = "image.tar.gz"
+)
+
+// BundleMetadata describes the image inside a bundle.
+type BundleMetadata struct {
+	Version        int                  `json:"version"`
+	Ref            string               `json:"ref"`
+	ID             string               `json:"id"`
+	Digest         string               `json:"digest,omitempty"`
+	Arch           string               `json:"arch"`
+	AndroidVersion string               `json:"android_version,omitempty"`
+	Created        time.Time            `json:"created"`
+	Catalog        *config.CatalogImage `json:"catalog,omitempty"`
+}
+
+// IsBundle reports whether path is a file that starts with bundle metadata.
+func IsBundle(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if st, err := f.Stat(); err != nil || !st.Mode().IsRegular() {
+		return false
+	}
+	hdr, err := tar.NewReader(f).Next()
+	return err == nil && hdr.Name == bundleMetadataName
+}
+
+// ExportBundle saves ref (pulling it first if needed) with its catalog entry into output.
+func ExportBundle(runtime container.Runtime, ref, output string) (*BundleMetadata, error) {
+	return ExportImageBundle(runtime, ref, ref, output)
+}
+
+// ExportImageBundle saves image, which may be pinned to a digest or an image ID, under the
+// name ref; importing the bundle tags the image as ref again.
+func ExportImageBundle(runtime container.Runtime, ref, image, output string) (*BundleMetadata, error) {
+	if err := container.CheckRoot(); err != nil {
+		return nil, err
+	}
+	meta, err := InspectOrPull(runtime, image)
+	if err != nil {
+		return nil, err
+	}
+	digest := meta.Digest(image)
+	if digest == "" {
+		digest = meta.Digest(ref)
+	}
+
+	md := &BundleMetadata{
+		Version:        BundleVersion,
+		Ref:            ref,
+		ID:             meta.ID,
+		Digest:         digest,
+		Arch:           meta.Architecture,
+		AndroidVersion: meta.Labels[LabelAndroidVersion],
+		Created:        time.Now().UTC(),
+	}
+	if md.AndroidVersion == "" {
+		md.AndroidVersion = config.AndroidVersionFromImage(ref)
+	}
+	if img, ok := config.LookupCatalogImage(ref); ok {
+		img.Source = ""
+		md.Catalog = &img
+	}
+
+	// The tar header needs the compressed size up front, so stage the image next to the
+	// output (same filesystem, usually more space than /tmp).
+	staged, err := os.CreateTemp(filepath.Dir(output), ".reddock-export-")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create staging file: %v", err)
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+
+	s := ui.NewSpinner(fmt.Sprintf("Saving %s...", ref))
+	s.Start()
+	imageSum, err := saveCompressed(runtime, image, staged)
+	if err != nil {
+		s.Finish("Export failed")
+		return nil, err
+	}
+	s.Finish(fmt.Sprintf("Saved %s", ref))
+
+	mdData, err := json.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	mdSum := sha256.Sum256(mdData)
+	sums := fmt.Sprintf("%s  %s\n%s  %s\n", hex.EncodeToString(mdSum[:]), bundleMetadataName, imageSum, bundleImageName)
+
+	partial := output + ".partial"
+	if err := writeBundle(partial, mdData, []byte(sums), staged); err != nil {
+		os.Remove(partial)
+		return nil, err
+	}
+	if err := os.Rename(partial, output); err != nil {
+		os.Remove(partial)
+		return nil, fmt.Errorf("Failed to write bundle: %v", err)
+	}
+	return md, nil
+}
+
+// saveCompressed streams `docker save ref` through gzip into w and returns its sha256.
+func saveCompressed(runtime container.Runtime, ref string, w io.Writer) (string, error) {
+	h := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(w, h))
+	cmd := runtime.Command("save", ref)
+	cmd.Stdout = gz
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("docker save failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if err := gz.Close(); err != nil {
+		return "", fmt.Errorf("Failed to compress image: %v", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func writeBundle(path string, metadata, sums []byte, image *os.File) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Failed to create bundle: %v", err)
+	}
+	defer f.Close()
+
+	st, err := image.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := image.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(f)
+	now := time.Now()
+	for _, e := range []struct {
+		name string
+		size int64
+		r    io.Reader
+	}{
+		{bundleMetadataName, int64(len(metadata)), bytes.NewReader(metadata)},
+		{bundleChecksumsName, int64(len(sums)), bytes.NewReader(sums)},
+		{bundleImageName, st.Size(), image},
+	} {
+		if err := tw.WriteHeader(&tar.Header{Name: e.name, Mode: 0644, Size: e.size, ModTime: now}); err != nil {
+			return fmt.Errorf("Failed to write bundle: %v", err)
+		}
+		if _, err := io.Copy(tw, e.r); err != nil {
+			return fmt.Errorf("Failed to write bundle: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("Failed to write bundle: %v", err)
+	}
+	return f.Close()
+}
+
+// ImportBundle verifies and loads a bundle into docker and merges its catalog entry into
+// the user (or system) catalog. The architecture and the image checksum are both checked
+// before anything is loaded.
+func ImportBundle(runtime container.Runtime, path string, system bool) (*BundleMetadata, error) {
+	if err := container.CheckRoot(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open bundle: %v", err)
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+
+	mdData, err := readBundleEntry(tr, bundleMetadataName)
+	if err != nil {
+		return nil, err
+	}
+	var md BundleMetadata
+	if err := json.Unmarshal(mdData, &md); err != nil {
+		return nil, fmt.Errorf("Failed to parse bundle metadata: %v", err)
+	}
+	if md.Version == 0 || md.Version > BundleVersion {
+		return nil, fmt.Errorf("Unsupported bundle version %d (this reddock reads version %d)", md.Version, BundleVersion)
+	}
+	sumData, err := readBundleEntry(tr, bundleChecksumsName)
+	if err != nil {
+		return nil, err
+	}
+	sums := ParseChecksums(string(sumData))
+	if got := sha256.Sum256(mdData); hex.EncodeToString(got[:]) != sums[bundleMetadataName] {
+		return nil, fmt.Errorf("Bundle metadata checksum mismatch; the bundle is corrupt")
+	}
+	if md.Arch != "" {
+		if err := container.CheckImageArch(md.Ref, []string{md.Arch}); err != nil {
+			return nil, err
+		}
+	}
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != bundleImageName {
+		return nil, fmt.Errorf("Bundle has no %s entry", bundleImageName)
+	}
+
+	// Stage and verify the image before docker sees it, so a corrupt bundle never loads
+	// (or overwrites a tag). Staging prefers the bundle's directory, like export does.
+	staged, err := os.CreateTemp(filepath.Dir(path), ".reddock-import-")
+	if err != nil {
+		staged, err = os.CreateTemp("", ".reddock-import-")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create staging file: %v", err)
+		}
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+
+	s := ui.NewSpinner(fmt.Sprintf("Verifying %s (%s)...", md.Ref, ui.FormatBytes(hdr.Size)))
+	s.Start()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(staged, h), tr); err != nil {
+		s.Finish("Import failed")
+		return nil, fmt.Errorf("Failed to read bundle image: %v", err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != sums[bundleImageName] {
+		s.Finish("Import failed")
+		return nil, fmt.Errorf("Image checksum mismatch; the bundle is corrupt")
+	}
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		s.Finish("Import failed")
+		return nil, err
+	}
+
+	s.SetMessage(fmt.Sprintf("Loading %s...", md.Ref))
+	cmd := runtime.Command("load")
+	cmd.Stdin = staged
+	if out, err := cmd.CombinedOutput(); err != nil {
+		s.Finish("Import failed")
+		return nil, fmt.Errorf("docker load failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	// Images saved by digest or ID load untagged.
+	if md.ID != "" && !strings.Contains(md.Ref, "@") {
+		if out, err := runtime.Command("tag", md.ID, md.Ref).CombinedOutput(); err != nil {
+			s.Finish("Import failed")
+			return nil, fmt.Errorf("Failed to tag %s as %s: %v: %s", md.ID, md.Ref, err, strings.TrimSpace(string(out)))
+		}
+	}
+	s.Finish(fmt.Sprintf("Loaded %s", md.Ref))
+
+	if md.Catalog != nil {
+		if err := AddCatalogImage(*md.Catalog, system); err != nil {
+			fmt.Printf("Warning: could not add %s to the catalog: %v\n", md.Ref, err)
+		}
+	}
+	return &md, nil
+}
+
+func readBundleEntry(tr *tar.Reader, name string) ([]byte, error) {
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != name {
+		return nil, fmt.Errorf("Not a reddock image bundle (missing %s)", name)
+	}
+	return io.ReadAll(io.LimitReader(tr, 1<<20))
+}
+
+// ParseChecksums reads "<hex>  <name>" lines as written by sha256sum, keyed by name.
+func ParseChecksums(s string) map[string]string {
+	sums := make(map[string]string)
+	for _, line := range strings.Split(s, "\n") {
+		if sum, name, ok := strings.Cut(strings.TrimSpace(line), "  "); ok {
+			sums[strings.TrimPrefix(name, "*")] = sum
+		}
+	}
+	return sums
+}
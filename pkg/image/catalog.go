@@ -0,0 +1,128 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"reddock/pkg/config"
+)
+
+// CatalogPath returns the catalog file edited by add/remove/import.
+func CatalogPath(system bool) string {
+	if system {
+		return config.SystemCatalogPath
+	}
+	return config.GetUserCatalogPath()
+}
+
+// AddCatalogImage adds or replaces an entry in the user (or system) catalog.
+func AddCatalogImage(img config.CatalogImage, system bool) error {
+	if err := config.ValidateImageName(img.URL); err != nil {
+		return err
+	}
+	if img.Name == "" {
+		img.Name = img.URL
+	}
+	path := CatalogPath(system)
+	cat, err := config.ReadCatalogFile(path)
+	if err != nil {
+		return err
+	}
+	cat.Upsert(img)
+	return config.WriteCatalogFile(path, cat)
+}
+
+// RemoveCatalogImage drops an entry from the user (or system) catalog. Entries that come
+// from a lower-priority catalog are hidden with a tombstone instead.
+func RemoveCatalogImage(url string, system bool) error {
+	path := CatalogPath(system)
+	cat, err := config.ReadCatalogFile(path)
+	if err != nil {
+		return err
+	}
+	removed := cat.Remove(url)
+	if err := config.WriteCatalogFile(path, cat); err != nil {
+		return err
+	}
+
+	if _, listed := config.LookupCatalogImage(url); listed {
+		cat.Upsert(config.CatalogImage{Name: url, URL: url, Removed: true})
+		return config.WriteCatalogFile(path, cat)
+	}
+	if !removed {
+		return fmt.Errorf("Image '%s' is not in the catalog", url)
+	}
+	return nil
+}
+
+// ImportCatalog merges a shared catalog file (local path or http(s) URL) into the user
+// (or system) catalog and returns the number of imported entries.
+func ImportCatalog(source string, system bool) (int, error) {
+	data, err := readCatalogSource(source)
+	if err != nil {
+		return 0, err
+	}
+	shared, err := config.ParseCatalog(data)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", source, err)
+	}
+	for _, img := range shared.Images {
+		if err := config.ValidateImageName(img.URL); err != nil {
+			return 0, fmt.Errorf("%s: entry %q: %v", source, img.Name, err)
+		}
+	}
+
+	path := CatalogPath(system)
+	cat, err := config.ReadCatalogFile(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, img := range shared.Images {
+		cat.Upsert(img)
+	}
+	if err := config.WriteCatalogFile(path, cat); err != nil {
+		return 0, err
+	}
+	return len(shared.Images), nil
+}
+
+func readCatalogSource(source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read catalog: %v", err)
+		}
+		return data, nil
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(source)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download catalog: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to download catalog: %s returned %d", source, resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+}
+
+// PrintCatalog lists the merged catalog as a table.
+func PrintCatalog(images []config.CatalogImage) {
+	fmt.Printf("%-45s %-50s %-9s %-12s %-24s %-8s\n", "NAME", "URL", "ANDROID", "ARCH", "FEATURES", "SOURCE")
+	fmt.Println(strings.Repeat("-", 153))
+	for _, img := range images {
+		fmt.Printf("%-45s %-50s %-9s %-12s %-24s %-8s\n", img.Name, img.URL, orDash(img.AndroidVersion),
+			orDash(strings.Join(img.Arch, ",")), orDash(img.Features()), img.Source)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,76 @@
+package image
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"reddock/pkg/config"
+	"reddock/pkg/container"
+	"reddock/pkg/registry"
+)
+
+// Outdated reports instances whose tag now points at a different digest in the registry.
+// With pull, the new images are pulled; instances keep their pinned digest until upgraded.
+func Outdated(pull bool) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	containers := cfg.ListContainers()
+	if len(containers) == 0 {
+		fmt.Println("No Reddock containers found.")
+		return nil
+	}
+
+	runtime := container.NewRuntime()
+	updates := container.CheckImageUpdates(runtime, registry.NewClient(), containers)
+
+	fmt.Printf("%-20s %-40s %-12s %-14s %-14s\n", "NAME", "IMAGE", "STATE", "LOCAL", "REGISTRY")
+	fmt.Println(strings.Repeat("-", 104))
+	stale := make(map[string][]string)
+	for _, u := range updates {
+		fmt.Printf("%-20s %-40s %-12s %-14s %-14s\n", u.Container, u.ImageURL, u.State,
+			orDash(shortID(u.LocalDigest)), orDash(shortID(u.RemoteDigest)))
+		if u.State == container.UpdateAvailable {
+			stale[u.ImageURL] = append(stale[u.ImageURL], u.Container)
+		}
+	}
+	for _, u := range updates {
+		if u.Err != nil {
+			fmt.Printf("\nWarning: %s: %v", u.Container, u.Err)
+		}
+	}
+	fmt.Println()
+
+	if len(stale) == 0 {
+		fmt.Println("\nAll instances with registry images are up to date.")
+		return nil
+	}
+
+	refs := make([]string, 0, len(stale))
+	for ref := range stale {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	if !pull {
+		fmt.Printf("\n%d image(s) have updates. Pull them with: reddock image outdated --pull\n", len(refs))
+		return nil
+	}
+	if err := container.CheckRoot(); err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if err := runtime.PullImage(ref); err != nil {
+			return fmt.Errorf("Failed to pull %s: %v", ref, err)
+		}
+	}
+	fmt.Println("\nInstances stay on their pinned digest until upgraded (with a data snapshot and rollback):")
+	for _, ref := range refs {
+		for _, name := range stale[ref] {
+			fmt.Printf("  reddock upgrade %s %s\n", name, ref)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package image
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"reddock/pkg/config"
+	"reddock/pkg/container"
+	"reddock/pkg/registry"
+	"reddock/pkg/ui"
+)
+
+// officialRepository is the upstream redroid repository; its tags are always prune candidates.
+const officialRepository = "redroid/redroid"
+
+// PruneOptions selects what `reddock prune` removes.
+type PruneOptions struct {
+	DryRun    bool
+	Yes       bool          // skip the confirmation prompt
+	All       bool          // also prune every dangling image on the host
+	OlderThan time.Duration // only images created at least this long ago (0 = any age)
+}
+
+// PruneCandidate is a local image no instance references. Refs are its repo:tag names.
+type PruneCandidate struct {
+	ID      string
+	Refs    []string
+	Size    int64
+	Created time.Time
+}
+
+type Pruner struct {
+	config  *config.Config
+	runtime container.Runtime
+	opts    PruneOptions
+}
+
+func NewPruner(opts PruneOptions) *Pruner {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Warning: Failed to load config: %v\n", err)
+		cfg = config.GetDefault()
+	}
+	return &Pruner{config: cfg, runtime: container.NewRuntime(), opts: opts}
+}
+
+// Candidates returns redroid, catalog and reddock-built images that no configured
+// instance references, oldest first.
+func (p *Pruner) Candidates() ([]PruneCandidate, error) {
+	referenced := make(map[string]bool)
+	for _, ref := range p.config.ReferencedImages() {
+		if meta, err := container.InspectLocalImage(p.runtime, ref); err == nil {
+			referenced[meta.ID] = true
+		}
+	}
+
+	repos := make(map[string]bool)
+	addRepo := func(ref string) {
+		if r, err := registry.ParseReference(ref); err == nil {
+			repos[r.Name()] = true
+		}
+	}
+	addRepo(officialRepository)
+	for _, img := range config.LoadCatalog() {
+		addRepo(img.URL)
+	}
+	for _, c := range p.config.Containers {
+		addRepo(c.ImageURL)
+	}
+
+	built := make(map[string]bool)
+	if out, err := p.runtime.Command("images", "-q", "--no-trunc", "--filter", "label="+LabelBuilt+"=true").Output(); err == nil {
+		for _, id := range strings.Fields(string(out)) {
+			built[id] = true
+		}
+	}
+
+	out, err := p.runtime.Command("images", "--no-trunc", "--format", "{{.ID}}\t{{.Repository}}\t{{.Tag}}\t{{.Digest}}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list images: %v", err)
+	}
+	byID := make(map[string]*PruneCandidate)
+	var ids []string
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		f := strings.Split(scanner.Text(), "\t")
+		if len(f) != 4 || f[1] == "<none>" || referenced[f[0]] {
+			continue
+		}
+		if !built[f[0]] && !repos[repositoryName(f[1])] {
+			continue
+		}
+		ref := f[1] + ":" + f[2]
+		if f[2] == "<none>" {
+			if f[3] == "<none>" {
+				continue
+			}
+			ref = f[1] + "@" + f[3]
+		}
+		cand, ok := byID[f[0]]
+		if !ok {
+			cand = &PruneCandidate{ID: f[0]}
+			byID[f[0]] = cand
+			ids = append(ids, f[0])
+		}
+		cand.Refs = append(cand.Refs, ref)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	out, err = p.runtime.Command(append([]string{"image", "inspect", "--format", "{{.Id}}\t{{.Size}}\t{{.Created}}"}, ids...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to inspect images: %v", err)
+	}
+	cutoff := time.Now().Add(-p.opts.OlderThan)
+	var candidates []PruneCandidate
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		f := strings.Split(line, "\t")
+		if len(f) != 3 {
+			continue
+		}
+		cand, ok := byID[f[0]]
+		if !ok {
+			continue
+		}
+		cand.Size, _ = strconv.ParseInt(f[1], 10, 64)
+		cand.Created, _ = time.Parse(time.RFC3339Nano, f[2])
+		if p.opts.OlderThan > 0 && cand.Created.After(cutoff) {
+			continue
+		}
+		candidates = append(candidates, *cand)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Created.Before(candidates[j].Created) })
+	return candidates, nil
+}
+
+// Prune lists unreferenced reddock images and removes them after confirmation. Unrelated
+// images are left alone unless All is set.
+func (p *Pruner) Prune() error {
+	if err := container.CheckRoot(); err != nil {
+		return err
+	}
+
+	s := ui.NewSpinner("Looking for images no instance references...")
+	s.Start()
+	candidates, err := p.Candidates()
+	if err != nil {
+		s.Finish("Image scan failed")
+		return err
+	}
+	s.Finish(fmt.Sprintf("Found %d unreferenced reddock image(s)", len(candidates)))
+
+	var total int64
+	if len(candidates) > 0 {
+		fmt.Printf("\n%-14s %-50s %10s  %s\n", "IMAGE ID", "REFERENCE", "SIZE", "CREATED")
+		fmt.Println(strings.Repeat("-", 92))
+		for _, c := range candidates {
+			fmt.Printf("%-14s %-50s %10s  %s\n", shortID(c.ID), strings.Join(c.Refs, ", "),
+				ui.FormatBytes(c.Size), c.Created.Local().Format("2006-01-02"))
+			total += c.Size
+		}
+		fmt.Printf("\nTotal: %s\n", ui.FormatBytes(total))
+	}
+
+	if p.opts.DryRun {
+		if p.opts.All {
+			fmt.Println("Dry run: would also prune all dangling images on the host.")
+		}
+		fmt.Println("Dry run: nothing was removed.")
+		return nil
+	}
+	if len(candidates) == 0 && !p.opts.All {
+		return nil
+	}
+
+	if !p.opts.Yes {
+		prompt := "\nRemove these images?"
+		if p.opts.All {
+			prompt = "\nRemove these images and all dangling images on the host?"
+		}
+		fmt.Printf("%s [y/N]: ", prompt)
+		var response string
+		fmt.Scanln(&response)
+		if response != "y" && response != "Y" && response != "yes" {
+			fmt.Println("Aborted.")
+			return nil
+		}
+	}
+
+	var freed int64
+	for _, c := range candidates {
+		removed := true
+		for _, ref := range c.Refs {
+			if err := p.runtime.RemoveImage(ref); err != nil {
+				fmt.Printf("Warning: Could not remove %s (it may be used by a container): %v\n", ref, err)
+				removed = false
+			}
+		}
+		if removed {
+			freed += c.Size
+		}
+	}
+	if len(candidates) > 0 {
+		fmt.Printf("Removed unreferenced reddock images, freed %s\n", ui.FormatBytes(freed))
+	}
+
+	if p.opts.All {
+		output, err := p.pruneDangling()
+		if err != nil {
+			return fmt.Errorf("Failed to prune dangling images: %v", err)
+		}
+		if output != "" {
+			fmt.Println(output)
+		}
+	}
+	return nil
+}
+
+// pruneDangling is the host-wide `docker image prune`, honoring the age filter.
+func (p *Pruner) pruneDangling() (string, error) {
+	if p.opts.OlderThan == 0 {
+		return p.runtime.PruneImages()
+	}
+	out, err := p.runtime.Command("image", "prune", "-f", "--filter", "until="+p.opts.OlderThan.String()).Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// repositoryName normalizes a `docker images` repository column for comparison.
+func repositoryName(repo string) string {
+	r, err := registry.ParseReference(repo)
+	if err != nil {
+		return repo
+	}
+	return r.Name()
+}
+
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
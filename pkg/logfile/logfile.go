@@ -0,0 +1,104 @@
+// Package logfile writes size-rotated log files.
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Defaults for saved logs: rotate at 10 MiB and keep five old files.
+const (
+	DefaultMaxSize int64 = 10 << 20
+	DefaultKeep          = 5
+)
+
+// File is an append-only log file that is renamed to path.1 (path.1 to path.2,
+// and so on, dropping the oldest beyond keep) once it reaches maxSize.
+type File struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	file    *os.File
+	size    int64
+}
+
+// Open opens path for appending, creating it and its directory if needed.
+func Open(path string, maxSize int64, keep int) (*File, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if keep < 0 {
+		keep = 0
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	r := &File{path: path, maxSize: maxSize, keep: keep}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *File) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to open log file %s: %v", r.path, err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file, r.size = f, st.Size()
+	return nil
+}
+
+// Write appends p, rotating first when p would take the file past maxSize. Callers write
+// whole lines, so rotation never splits one.
+func (r *File) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *File) rotate() error {
+	r.file.Close()
+	r.file = nil
+	if r.keep == 0 {
+		os.Remove(r.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", r.path, r.keep))
+		for i := r.keep - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return fmt.Errorf("Failed to rotate %s: %v", r.path, err)
+		}
+	}
+	return r.open()
+}
+
+func (r *File) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"reddock/pkg/sysinfo"
+)
+
+// dockerHubAuthKey is the key docker login uses for Docker Hub in config.json.
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+type dockerConfigFile struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// dockerConfigPath follows the docker CLI: $DOCKER_CONFIG/config.json, else ~/.docker/config.json.
+// Under sudo the invoking user's file is used when root has none.
+func dockerConfigPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	return sysinfo.HomeFile(".docker", "config.json")
+}
+
+// DockerCredentials looks up credentials stored by `docker login` for a registry host,
+// including credential helpers (credHelpers / credsStore).
+func DockerCredentials(registry string) (string, string) {
+	data, err := os.ReadFile(dockerConfigPath())
+	if err != nil {
+		return "", ""
+	}
+	var cfg dockerConfigFile
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return "", ""
+	}
+
+	key := registry
+	if registry == DefaultDomain {
+		key = dockerHubAuthKey
+	}
+
+	if helper := cfg.CredHelpers[registry]; helper != "" {
+		return credentialHelperGet(helper, key)
+	}
+	for _, k := range []string{key, "https://" + key, "http://" + key} {
+		if a, ok := cfg.Auths[k]; ok {
+			if a.Auth != "" {
+				if raw, err := base64.StdEncoding.DecodeString(a.Auth); err == nil {
+					user, pass, _ := strings.Cut(string(raw), ":")
+					return user, pass
+				}
+			}
+			if a.Username != "" {
+				return a.Username, a.Password
+			}
+		}
+	}
+	if cfg.CredsStore != "" {
+		return credentialHelperGet(cfg.CredsStore, key)
+	}
+	return "", ""
+}
+
+func credentialHelperGet(helper, serverURL string) (string, string) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", ""
+	}
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(out), &creds); err != nil {
+		return "", ""
+	}
+	return creds.Username, creds.Secret
+}
+
+// EngineAuthHeader returns the X-Registry-Auth value the Docker Engine API expects for
+// pulling ref, or "" when no credentials are stored for its registry.
+func EngineAuthHeader(ref string) string {
+	r, err := ParseReference(ref)
+	if err != nil {
+		return ""
+	}
+	user, pass := DockerCredentials(r.Registry())
+	if user == "" {
+		return ""
+	}
+	server := r.Registry()
+	if r.Domain == DefaultDomain {
+		server = dockerHubAuthKey
+	}
+	payload, err := json.Marshal(map[string]string{
+		"username":      user,
+		"password":      pass,
+		"serveraddress": server,
+	})
+	if err != nil {
+		return ""
+	}
+	return base64.URLEncoding.EncodeToString(payload)
+}
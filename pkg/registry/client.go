@@ -0,0 +1,359 @@
+// Package registry talks to OCI / Docker registries over the v2 HTTP API to resolve
+// image manifests, digests and platforms without pulling the image.
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	mediaTypeOCIIndex          = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest       = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerList        = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest    = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerHubDomain            = "docker.io"
+	dockerHubRegistryEndpoint  = "registry-1.docker.io"
+	manifestAcceptHeaderValues = mediaTypeOCIIndex + ", " + mediaTypeDockerList + ", " + mediaTypeOCIManifest + ", " + mediaTypeDockerManifest
+)
+
+// Platform is the os/architecture an image manifest was built for.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// ImageInfo is what the registry reports for a reference.
+type ImageInfo struct {
+	Digest    string // digest of the top-level manifest or index
+	Platforms []Platform
+	Labels    map[string]string // only filled for single-platform manifests
+}
+
+// Supports reports whether the image has a manifest for arch.
+func (i *ImageInfo) Supports(arch string) bool {
+	for _, p := range i.Platforms {
+		if p.Architecture == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// Credentials returns basic-auth credentials for a registry host (host[:port]), or empty strings.
+type Credentials func(registry string) (username, password string)
+
+// Client is a minimal registry v2 client with anonymous or basic/bearer token auth.
+type Client struct {
+	HTTP        *http.Client
+	Credentials Credentials
+	// Endpoint maps a reference domain to the base URL used for API calls. It defaults
+	// to https://<domain> (http for localhost) with Docker Hub redirected to its API host;
+	// pointing it at a stub server (e.g. httptest) exercises the client without a registry.
+	Endpoint func(domain string) string
+}
+
+func NewClient() *Client {
+	return &Client{
+		HTTP:        &http.Client{Timeout: 30 * time.Second},
+		Credentials: DockerCredentials,
+		Endpoint:    defaultEndpoint,
+	}
+}
+
+func defaultEndpoint(domain string) string {
+	if domain == dockerHubDomain {
+		return "https://" + dockerHubRegistryEndpoint
+	}
+	host := domain
+	if h, _, ok := strings.Cut(domain, ":"); ok {
+		host = h
+	}
+	if host == "localhost" || host == "127.0.0.1" || host == "::1" {
+		return "http://" + domain
+	}
+	return "https://" + domain
+}
+
+type descriptor struct {
+	MediaType string    `json:"mediaType"`
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	Platform  *Platform `json:"platform,omitempty"`
+}
+
+type manifestDoc struct {
+	MediaType string       `json:"mediaType"`
+	Manifests []descriptor `json:"manifests"`
+	Config    descriptor   `json:"config"`
+}
+
+type imageConfigDoc struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant"`
+	Config       struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+}
+
+// Inspect resolves ref (e.g. redroid/redroid:13.0.0-latest) to its digest and platforms.
+func (c *Client) Inspect(ref string) (*ImageInfo, error) {
+	r, err := ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	body, mediaType, digest, err := c.fetchManifest(r, r.reference())
+	if err != nil {
+		return nil, err
+	}
+
+	var doc manifestDoc
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("Failed to parse manifest for %s: %v", ref, err)
+	}
+	if mediaType == "" {
+		mediaType = doc.MediaType
+	}
+
+	info := &ImageInfo{Digest: digest}
+	switch mediaType {
+	case mediaTypeOCIIndex, mediaTypeDockerList:
+		for _, m := range doc.Manifests {
+			if m.Platform == nil || m.Platform.Architecture == "unknown" {
+				continue // attestation manifests
+			}
+			info.Platforms = append(info.Platforms, *m.Platform)
+		}
+	default:
+		cfg, err := c.fetchImageConfig(r, doc.Config.Digest)
+		if err != nil {
+			return nil, err
+		}
+		info.Platforms = []Platform{{OS: cfg.OS, Architecture: cfg.Architecture, Variant: cfg.Variant}}
+		info.Labels = cfg.Config.Labels
+	}
+	return info, nil
+}
+
+// Digest returns the current manifest digest for ref without walking platforms.
+func (c *Client) Digest(ref string) (string, error) {
+	r, err := ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	// HEAD is enough when the registry sends Docker-Content-Digest (and, on Docker Hub,
+	// does not count against the pull rate limit); otherwise hash the manifest body.
+	resp, err := c.request(http.MethodHead, r, c.manifestURL(r, r.reference()), manifestAcceptHeaderValues)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	_, _, digest, err := c.fetchManifest(r, r.reference())
+	return digest, err
+}
+
+func (c *Client) manifestURL(r Reference, reference string) string {
+	return fmt.Sprintf("%s/v2/%s/manifests/%s", c.Endpoint(r.Registry()), r.Path, reference)
+}
+
+func (c *Client) fetchManifest(r Reference, reference string) ([]byte, string, string, error) {
+	resp, err := c.get(r, c.manifestURL(r, reference), manifestAcceptHeaderValues)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("Failed to read manifest: %v", err)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	}
+	mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	return body, strings.TrimSpace(mediaType), digest, nil
+}
+
+func (c *Client) fetchImageConfig(r Reference, digest string) (*imageConfigDoc, error) {
+	if digest == "" {
+		return nil, fmt.Errorf("Manifest for %s has no config descriptor", r.Path)
+	}
+	u := fmt.Sprintf("%s/v2/%s/blobs/%s", c.Endpoint(r.Registry()), r.Path, digest)
+	resp, err := c.get(r, u, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var cfg imageConfigDoc
+	if err := json.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("Failed to parse image config: %v", err)
+	}
+	return &cfg, nil
+}
+
+// get performs a GET, answering a 401 challenge with basic auth or a bearer token once.
+func (c *Client) get(r Reference, u, accept string) (*http.Response, error) {
+	return c.request(http.MethodGet, r, u, accept)
+}
+
+func (c *Client) request(method string, r Reference, u, accept string) (*http.Response, error) {
+	do := func(authz string) (*http.Response, error) {
+		req, err := http.NewRequest(method, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if authz != "" {
+			req.Header.Set("Authorization", authz)
+		}
+		return c.HTTP.Do(req)
+	}
+
+	resp, err := do("")
+	if err != nil {
+		return nil, fmt.Errorf("Registry request failed: %v", err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		authz, err := c.authorize(r, challenge)
+		if err != nil {
+			return nil, err
+		}
+		if resp, err = do(authz); err != nil {
+			return nil, fmt.Errorf("Registry request failed: %v", err)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, &StatusError{URL: u, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// StatusError is a non-200 registry response.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Registry returned %d for %s: %s", e.StatusCode, e.URL, e.Body)
+}
+
+func (c *Client) credentials(domain string) (string, string) {
+	if c.Credentials == nil {
+		return "", ""
+	}
+	return c.Credentials(domain)
+}
+
+func (c *Client) authorize(r Reference, challenge string) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	user, pass := c.credentials(r.Registry())
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if user == "" {
+			return "", fmt.Errorf("Registry %s requires credentials (run docker login %s)", r.Registry(), r.Registry())
+		}
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(user, pass)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+		realm := params["realm"]
+		if realm == "" {
+			return "", fmt.Errorf("Registry %s sent a bearer challenge without realm", r.Registry())
+		}
+		q := url.Values{}
+		if s := params["service"]; s != "" {
+			q.Set("service", s)
+		}
+		scope := params["scope"]
+		if scope == "" {
+			scope = fmt.Sprintf("repository:%s:pull", r.Path)
+		}
+		q.Set("scope", scope)
+
+		req, err := http.NewRequest(http.MethodGet, realm+"?"+q.Encode(), nil)
+		if err != nil {
+			return "", err
+		}
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		resp, err := c.HTTP.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("Token request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("Token request to %s returned %d", realm, resp.StatusCode)
+		}
+		var tok struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+			return "", fmt.Errorf("Failed to parse registry token: %v", err)
+		}
+		if tok.Token == "" {
+			tok.Token = tok.AccessToken
+		}
+		return "Bearer " + tok.Token, nil
+	default:
+		return "", fmt.Errorf("Unsupported registry auth challenge: %q", challenge)
+	}
+}
+
+// parseChallenge splits `Bearer realm="...",service="..."` into scheme and parameters.
+func parseChallenge(h string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(h), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var kv string
+		rest = strings.TrimLeft(rest, " ,")
+		key, after, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		if strings.HasPrefix(after, `"`) {
+			end := strings.Index(after[1:], `"`)
+			if end < 0 {
+				kv, rest = after[1:], ""
+			} else {
+				kv, rest = after[1:end+1], after[end+2:]
+			}
+		} else {
+			kv, rest, _ = strings.Cut(after, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = kv
+	}
+	return scheme, params
+}
@@ -0,0 +1,250 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+const testRepo = "registry.test/redroid/redroid"
+
+// fakeRegistry serves manifests and blobs for testRepo behind a bearer token challenge.
+type fakeRegistry struct {
+	t     *testing.T
+	srv   *httptest.Server
+	token string
+
+	manifests map[string]fakeManifest // by tag
+	blobs     map[string][]byte       // by digest
+	// noDigestHeader leaves out Docker-Content-Digest, as some registries do.
+	noDigestHeader bool
+
+	mu       sync.Mutex
+	requests []string // "METHOD path" of authorized registry requests
+}
+
+type fakeManifest struct {
+	mediaType string
+	body      []byte
+	digest    string
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	f := &fakeRegistry{
+		t:         t,
+		token:     "secret-token",
+		manifests: make(map[string]fakeManifest),
+		blobs:     make(map[string][]byte),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", f.serveToken)
+	mux.HandleFunc("/v2/redroid/redroid/manifests/", f.serveManifest)
+	mux.HandleFunc("/v2/redroid/redroid/blobs/", f.serveBlob)
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeRegistry) client() *Client {
+	return &Client{
+		HTTP:        f.srv.Client(),
+		Credentials: func(string) (string, string) { return "user", "pass" },
+		Endpoint:    func(string) string { return f.srv.URL },
+	}
+}
+
+func (f *fakeRegistry) serveToken(w http.ResponseWriter, r *http.Request) {
+	if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+		f.t.Errorf("token request without the registry credentials")
+	}
+	if got := r.URL.Query().Get("service"); got != "registry.test" {
+		f.t.Errorf("token service = %q, want registry.test", got)
+	}
+	if got := r.URL.Query().Get("scope"); got != "repository:redroid/redroid:pull" {
+		f.t.Errorf("token scope = %q", got)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"token": f.token})
+}
+
+// authorized answers unauthenticated requests with a bearer challenge.
+func (f *fakeRegistry) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry.test"`, f.srv.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	f.mu.Unlock()
+	return true
+}
+
+func (f *fakeRegistry) serveManifest(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+	m, ok := f.manifests[r.URL.Path[len("/v2/redroid/redroid/manifests/"):]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", m.mediaType)
+	if !f.noDigestHeader {
+		w.Header().Set("Docker-Content-Digest", m.digest)
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(m.body)
+}
+
+func (f *fakeRegistry) serveBlob(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+	blob, ok := f.blobs[r.URL.Path[len("/v2/redroid/redroid/blobs/"):]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(blob)
+}
+
+func (f *fakeRegistry) addManifest(tag, mediaType string, doc any) fakeManifest {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	m := fakeManifest{mediaType: mediaType, body: body, digest: fmt.Sprintf("sha256:%x", sha256.Sum256(body))}
+	f.manifests[tag] = m
+	return m
+}
+
+func (f *fakeRegistry) addBlob(doc any) string {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	f.blobs[digest] = body
+	return digest
+}
+
+func TestInspectManifestListWithBearerChallenge(t *testing.T) {
+	f := newFakeRegistry(t)
+	list := f.addManifest("13.0.0-latest", mediaTypeDockerList, map[string]any{
+		"mediaType": mediaTypeDockerList,
+		"manifests": []map[string]any{
+			{"digest": "sha256:aaa", "platform": map[string]string{"os": "linux", "architecture": "amd64"}},
+			{"digest": "sha256:bbb", "platform": map[string]string{"os": "linux", "architecture": "arm64", "variant": "v8"}},
+			{"digest": "sha256:ccc", "platform": map[string]string{"os": "unknown", "architecture": "unknown"}},
+		},
+	})
+
+	info, err := f.client().Inspect(testRepo + ":13.0.0-latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Digest != list.digest {
+		t.Errorf("Digest = %s, want the manifest list digest %s", info.Digest, list.digest)
+	}
+	want := []Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64", Variant: "v8"}}
+	if !reflect.DeepEqual(info.Platforms, want) {
+		t.Errorf("Platforms = %v, want %v (attestation manifests skipped)", info.Platforms, want)
+	}
+	if !info.Supports("arm64") || info.Supports("riscv64") {
+		t.Errorf("Supports disagrees with Platforms %v", info.Platforms)
+	}
+}
+
+func TestInspectSingleManifest(t *testing.T) {
+	f := newFakeRegistry(t)
+	cfg := f.addBlob(map[string]any{
+		"os":           "linux",
+		"architecture": "amd64",
+		"config":       map[string]any{"Labels": map[string]string{"android.version": "13"}},
+	})
+	m := f.addManifest("13.0.0", mediaTypeDockerManifest, map[string]any{
+		"mediaType": mediaTypeDockerManifest,
+		"config":    map[string]string{"digest": cfg},
+	})
+
+	info, err := f.client().Inspect(testRepo + ":13.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Digest != m.digest {
+		t.Errorf("Digest = %s, want %s", info.Digest, m.digest)
+	}
+	if want := []Platform{{OS: "linux", Architecture: "amd64"}}; !reflect.DeepEqual(info.Platforms, want) {
+		t.Errorf("Platforms = %v, want %v", info.Platforms, want)
+	}
+	if info.Labels["android.version"] != "13" {
+		t.Errorf("Labels = %v, want the image config labels", info.Labels)
+	}
+}
+
+func TestDigestUsesHead(t *testing.T) {
+	f := newFakeRegistry(t)
+	m := f.addManifest("13.0.0-latest", mediaTypeOCIIndex, map[string]any{"mediaType": mediaTypeOCIIndex})
+
+	digest, err := f.client().Digest(testRepo + ":13.0.0-latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != m.digest {
+		t.Errorf("Digest = %s, want %s", digest, m.digest)
+	}
+	if want := []string{"HEAD /v2/redroid/redroid/manifests/13.0.0-latest"}; !reflect.DeepEqual(f.requests, want) {
+		t.Errorf("requests = %v, want only %v", f.requests, want)
+	}
+}
+
+func TestDigestFallsBackToManifestBody(t *testing.T) {
+	f := newFakeRegistry(t)
+	f.noDigestHeader = true
+	m := f.addManifest("13.0.0-latest", mediaTypeOCIIndex, map[string]any{"mediaType": mediaTypeOCIIndex})
+
+	digest, err := f.client().Digest(testRepo + ":13.0.0-latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != m.digest {
+		t.Errorf("Digest = %s, want the sha256 of the manifest body %s", digest, m.digest)
+	}
+	want := []string{
+		"HEAD /v2/redroid/redroid/manifests/13.0.0-latest",
+		"GET /v2/redroid/redroid/manifests/13.0.0-latest",
+	}
+	if !reflect.DeepEqual(f.requests, want) {
+		t.Errorf("requests = %v, want %v", f.requests, want)
+	}
+}
+
+func TestMissingManifest(t *testing.T) {
+	f := newFakeRegistry(t)
+	_, err := f.client().Inspect(testRepo + ":nope")
+	if se, ok := err.(*StatusError); !ok || se.StatusCode != http.StatusNotFound {
+		t.Errorf("err = %v, want a 404 StatusError", err)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:redroid/redroid:pull"`)
+	if scheme != "Bearer" {
+		t.Errorf("scheme = %q", scheme)
+	}
+	want := map[string]string{
+		"realm":   "https://auth.docker.io/token",
+		"service": "registry.docker.io",
+		"scope":   "repository:redroid/redroid:pull",
+	}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("params = %v, want %v", params, want)
+	}
+}
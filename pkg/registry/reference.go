@@ -0,0 +1,151 @@
+package registry
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultDomain is the registry assumed for references without a host.
+	DefaultDomain = dockerHubDomain
+	// officialRepoPrefix is prepended to single-component Docker Hub names.
+	officialRepoPrefix = "library/"
+	maxNameLength      = 255
+)
+
+// Grammar follows the distribution reference spec:
+// reference := name [ ":" tag ] [ "@" digest ]
+// name      := [domain "/"] path-component ["/" path-component]*
+var (
+	domainComponentRe = `(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])`
+	domainRe          = regexp.MustCompile(`^(` + domainComponentRe + `(?:\.` + domainComponentRe + `)*|\[[0-9a-fA-F:]+\])(?::([0-9]+))?$`)
+	pathComponentRe   = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]+)[a-z0-9]+)*$`)
+	tagRe             = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestRe          = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+	sha256HexRe       = regexp.MustCompile(`^[a-f0-9]{64}$`)
+)
+
+// Reference is a parsed, normalized image reference.
+type Reference struct {
+	Domain string // registry host without port, e.g. docker.io or registry.example.com
+	Port   string // empty when not given
+	Path   string // repository path, e.g. redroid/redroid or library/alpine
+	Tag    string // empty when only a digest is given
+	Digest string // algorithm:hex, empty when not pinned
+}
+
+// ParseReference validates s and fills in Docker's defaults (docker.io, library/ for
+// single-component Hub names, and the latest tag when neither tag nor digest is given).
+func ParseReference(s string) (Reference, error) {
+	if s == "" {
+		return Reference{}, fmt.Errorf("Image reference cannot be empty")
+	}
+	var r Reference
+	name := s
+
+	if n, digest, ok := strings.Cut(name, "@"); ok {
+		if !digestRe.MatchString(digest) {
+			return Reference{}, fmt.Errorf("Invalid digest %q in %q (expected algorithm:hex, e.g. sha256:...)", digest, s)
+		}
+		if alg, hex, _ := strings.Cut(digest, ":"); alg == "sha256" && !sha256HexRe.MatchString(hex) {
+			return Reference{}, fmt.Errorf("Invalid sha256 digest in %q: expected 64 lowercase hex characters", s)
+		}
+		name, r.Digest = n, digest
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		tag := name[i+1:]
+		if !tagRe.MatchString(tag) {
+			return Reference{}, fmt.Errorf("Invalid tag %q in %q", tag, s)
+		}
+		name, r.Tag = name[:i], tag
+	}
+	if len(name) > maxNameLength {
+		return Reference{}, fmt.Errorf("Image name in %q is longer than %d characters", s, maxNameLength)
+	}
+
+	path := name
+	if first, rest, ok := strings.Cut(name, "/"); ok && (strings.ContainsAny(first, ".:[") || first == "localhost") {
+		m := domainRe.FindStringSubmatch(first)
+		if m == nil {
+			return Reference{}, fmt.Errorf("Invalid registry host %q in %q", first, s)
+		}
+		r.Domain, r.Port, path = m[1], m[2], rest
+	} else {
+		r.Domain = DefaultDomain
+	}
+	if r.Domain == "index.docker.io" {
+		r.Domain = DefaultDomain
+	}
+
+	for _, c := range strings.Split(path, "/") {
+		if !pathComponentRe.MatchString(c) {
+			return Reference{}, fmt.Errorf("Invalid repository name component %q in %q "+
+				"(use lowercase letters, digits and . _ - separators; format [HOST[:PORT]/]NAMESPACE/REPOSITORY[:TAG][@DIGEST])", c, s)
+		}
+	}
+	if r.Domain == DefaultDomain && !strings.Contains(path, "/") {
+		path = officialRepoPrefix + path
+	}
+	r.Path = path
+
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+	return r, nil
+}
+
+// Registry is the registry host including the port, e.g. registry.example.com:5000.
+func (r Reference) Registry() string {
+	if r.Port != "" {
+		return r.Domain + ":" + r.Port
+	}
+	return r.Domain
+}
+
+// Name is the fully qualified repository name without tag or digest.
+func (r Reference) Name() string {
+	return r.Registry() + "/" + r.Path
+}
+
+// FamiliarName is the repository name as docker prints it (docker.io and library/ dropped).
+func (r Reference) FamiliarName() string {
+	if r.Domain != DefaultDomain || r.Port != "" {
+		return r.Name()
+	}
+	return strings.TrimPrefix(r.Path, officialRepoPrefix)
+}
+
+// String is the fully qualified, normalized reference.
+func (r Reference) String() string {
+	return r.format(r.Name())
+}
+
+// Familiar is the normalized reference in docker's short form, e.g. redroid/redroid:13.0.0-latest.
+func (r Reference) Familiar() string {
+	return r.format(r.FamiliarName())
+}
+
+// Pinned returns the familiar repository name pinned to digest (tag dropped).
+func (r Reference) Pinned(digest string) string {
+	return r.FamiliarName() + "@" + digest
+}
+
+// reference is the tag or digest to ask the registry for.
+func (r Reference) reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+func (r Reference) format(name string) string {
+	s := name
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
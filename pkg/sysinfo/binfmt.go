@@ -0,0 +1,94 @@
+package sysinfo
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const binfmtMiscDir = "/proc/sys/fs/binfmt_misc"
+
+// qemuArchNames maps Docker/OCI architecture names to qemu-user binfmt handler suffixes.
+var qemuArchNames = map[string]string{
+	"amd64":   "x86_64",
+	"386":     "i386",
+	"arm64":   "aarch64",
+	"arm":     "arm",
+	"riscv64": "riscv64",
+}
+
+// kernelArchNames maps `uname -m` machine names to OCI architecture names.
+var kernelArchNames = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"i386":    "386",
+	"i486":    "386",
+	"i586":    "386",
+	"i686":    "386",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"armv7l":  "arm",
+	"armv8l":  "arm",
+	"riscv64": "riscv64",
+}
+
+var (
+	hostArchOnce sync.Once
+	hostArch     string
+)
+
+// HostImageArch is the OCI architecture name images must carry to run natively. It comes
+// from the kernel (uname -m), which the local Docker daemon shares, not from how the
+// reddock binary was built: a 386 or emulated build still reports the real host.
+func HostImageArch() string {
+	hostArchOnce.Do(func() {
+		var u syscall.Utsname
+		if err := syscall.Uname(&u); err == nil {
+			var b strings.Builder
+			for _, c := range u.Machine {
+				if c == 0 {
+					break
+				}
+				b.WriteByte(byte(c))
+			}
+			if arch, ok := kernelArchNames[b.String()]; ok {
+				hostArch = arch
+				return
+			}
+		}
+		hostArch = runtime.GOARCH
+	})
+	return hostArch
+}
+
+// EmulationAvailable reports whether an enabled qemu-user binfmt_misc handler exists for the
+// given OCI architecture, so foreign-arch containers can run under emulation.
+func EmulationAvailable(arch string) bool {
+	name, ok := qemuArchNames[arch]
+	if !ok {
+		return false
+	}
+	return strings.TrimSpace(readFileFirstLine(filepath.Join(binfmtMiscDir, "qemu-"+name))) == "enabled"
+}
+
+// EmulatedArchs lists the OCI architectures with an enabled qemu-user handler.
+func EmulatedArchs() []string {
+	var archs []string
+	for arch := range qemuArchNames {
+		if arch != HostImageArch() && EmulationAvailable(arch) {
+			archs = append(archs, arch)
+		}
+	}
+	sort.Strings(archs)
+	return archs
+}
+
+// BinfmtMiscMounted is true when binfmt_misc is available for registering emulators.
+func BinfmtMiscMounted() bool {
+	_, err := os.Stat(filepath.Join(binfmtMiscDir, "status"))
+	return err == nil
+}
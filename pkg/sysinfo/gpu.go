@@ -0,0 +1,111 @@
+package sysinfo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// RenderNode is a DRM render node (/dev/dri/renderD*) and the kernel driver behind it.
+type RenderNode struct {
+	Path   string
+	Driver string // i915, xe, amdgpu, radeon, nouveau, virtio_gpu, ... ("" if unknown)
+	Major  uint32 // device number, for the container's device cgroup rule
+	Minor  uint32
+}
+
+// softwareDRMDrivers expose render nodes without real acceleration.
+var softwareDRMDrivers = map[string]bool{
+	"vgem":      true,
+	"vkms":      true,
+	"simpledrm": true,
+}
+
+// vendorDRMDrivers maps a CPU vendor to the drivers of its integrated GPUs.
+var vendorDRMDrivers = map[CPUVendor][]string{
+	VendorIntel: {"i915", "xe"},
+	VendorAMD:   {"amdgpu", "radeon"},
+}
+
+// ProbeRenderNodes lists DRM render nodes with their driver names, sorted by path.
+func ProbeRenderNodes() []RenderNode {
+	paths, _ := filepath.Glob("/dev/dri/renderD*")
+	sort.Strings(paths)
+
+	var nodes []RenderNode
+	for _, p := range paths {
+		st, err := os.Stat(p)
+		if err != nil || st.Mode()&os.ModeCharDevice == 0 {
+			continue
+		}
+		node := RenderNode{Path: p}
+		if sys, ok := st.Sys().(*syscall.Stat_t); ok {
+			node.Major, node.Minor = devMajorMinor(uint64(sys.Rdev))
+		}
+		driverLink := filepath.Join("/sys/class/drm", filepath.Base(p), "device", "driver")
+		if target, err := os.Readlink(driverLink); err == nil {
+			node.Driver = filepath.Base(target)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// devMajorMinor splits a Linux dev_t (glibc's gnu_dev_major/gnu_dev_minor encoding).
+func devMajorMinor(dev uint64) (uint32, uint32) {
+	major := uint32((dev>>8)&0xfff) | uint32((dev>>32)&^0xfff)
+	minor := uint32(dev&0xff) | uint32((dev>>12)&^0xff)
+	return major, minor
+}
+
+// IsHardware is true for render nodes backed by a GPU driver (including virtio_gpu/virgl in VMs).
+func (n RenderNode) IsHardware() bool {
+	return n.Driver != "" && !softwareDRMDrivers[n.Driver]
+}
+
+func (n RenderNode) String() string {
+	return fmt.Sprintf("%s (%s)", n.Path, orDash(n.Driver))
+}
+
+// SelectRenderNode picks the hardware render node to hand to a container. With several GPUs,
+// the one matching the CPU vendor's integrated graphics wins; otherwise the first hardware node.
+func SelectRenderNode(nodes []RenderNode, vendor CPUVendor) (RenderNode, bool) {
+	for _, drv := range vendorDRMDrivers[vendor] {
+		for _, n := range nodes {
+			if n.Driver == drv {
+				return n, true
+			}
+		}
+	}
+	for _, n := range nodes {
+		if n.IsHardware() {
+			return n, true
+		}
+	}
+	return RenderNode{}, false
+}
+
+// FindRenderNode returns the probed node at path.
+func FindRenderNode(nodes []RenderNode, path string) (RenderNode, bool) {
+	for _, n := range nodes {
+		if n.Path == path {
+			return n, true
+		}
+	}
+	return RenderNode{}, false
+}
+
+// RenderNodesSummary is a one-line list for status/doctor output.
+func RenderNodesSummary(nodes []RenderNode) string {
+	if len(nodes) == 0 {
+		return "no DRM render nodes under /dev/dri"
+	}
+	var parts []string
+	for _, n := range nodes {
+		parts = append(parts, n.String())
+	}
+	return strings.Join(parts, ", ")
+}
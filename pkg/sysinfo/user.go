@@ -0,0 +1,37 @@
+package sysinfo
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+)
+
+// SudoUserHome is the home directory of the user who ran reddock through sudo, or "" when
+// not running under sudo (or sudo was run by root).
+func SudoUserHome() string {
+	name := os.Getenv("SUDO_USER")
+	if name == "" || name == "root" {
+		return ""
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return ""
+	}
+	return u.HomeDir
+}
+
+// HomeFile is the file at elem under $HOME. Under sudo the invoking user's file is returned
+// instead when root has none, so per-user tool state (docker login, adb keys) is shared.
+func HomeFile(elem ...string) string {
+	path := filepath.Join(append([]string{os.Getenv("HOME")}, elem...)...)
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	if home := SudoUserHome(); home != "" {
+		alt := filepath.Join(append([]string{home}, elem...)...)
+		if _, err := os.Stat(alt); err == nil {
+			return alt
+		}
+	}
+	return path
+}
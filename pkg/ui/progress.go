@@ -13,10 +13,15 @@ type Progress struct {
 	total      int
 	current    int
 	isFinished bool
-	mu         sync.Mutex
+	mu         *sync.Mutex
 	stopChan   chan bool
 	isSpinner  bool
 
+	// bytes shows current/total as sizes instead of a percentage. group is set for bars
+	// drawn by a ProgressGroup, which then owns mu and the terminal lines.
+	bytes bool
+	group *ProgressGroup
+
 	// Spinner chars
 	spinnerChars []string
 }
@@ -27,6 +32,7 @@ func NewProgressBar(total int, message string) *Progress {
 		total:     total,
 		message:   message,
 		isSpinner: false,
+		mu:        new(sync.Mutex),
 	}
 }
 
@@ -35,6 +41,7 @@ func NewSpinner(message string) *Progress {
 	return &Progress{
 		message:      message,
 		isSpinner:    true,
+		mu:           new(sync.Mutex),
 		stopChan:     make(chan bool),
 		spinnerChars: []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"},
 	}
@@ -98,22 +105,31 @@ func (p *Progress) render() {
 	if p.isFinished {
 		return
 	}
-	width := 40
-	percent := float64(p.current) / float64(p.total) * 100
-	if percent > 100 {
-		percent = 100
+	if p.group != nil {
+		p.group.draw(false)
+		return
 	}
+	fmt.Printf("\r\033[K%s", p.line(40))
+}
 
-	filled := int(float64(width) * (float64(p.current) / float64(p.total)))
-	if filled > width {
-		filled = width
+// line is the bar as one line of text: message, bar and percentage (or byte counts).
+func (p *Progress) line(width int) string {
+	if p.total <= 0 {
+		return p.message
 	}
-	if filled < 0 {
-		filled = 0
+	ratio := float64(p.current) / float64(p.total)
+	if ratio > 1 {
+		ratio = 1
 	}
-
+	if ratio < 0 {
+		ratio = 0
+	}
+	filled := int(float64(width) * ratio)
 	bar := strings.Repeat("█", filled) + strings.Repeat("-", width-filled)
-	fmt.Printf("\r\033[K%s [%s] %.0f%%", p.message, bar, percent)
+	if p.bytes {
+		return fmt.Sprintf("%s [%s] %s/%s", p.message, bar, FormatBytes(int64(p.current)), FormatBytes(int64(p.total)))
+	}
+	return fmt.Sprintf("%s [%s] %.0f%%", p.message, bar, ratio*100)
 }
 
 // Finish completes the progress
@@ -0,0 +1,160 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ProgressGroup draws several byte progress bars (e.g. image layers) as a block of lines
+// with a total and ETA underneath. On a non-TTY stdout it prints one plain line per status
+// change instead.
+type ProgressGroup struct {
+	title    string
+	mu       sync.Mutex
+	bars     map[string]*Progress
+	order    []string
+	tty      bool
+	started  time.Time
+	lastDraw time.Time
+	drawn    int
+}
+
+// NewProgressGroup creates a group; it detects whether stdout is a terminal.
+func NewProgressGroup(title string) *ProgressGroup {
+	return &ProgressGroup{
+		title:   title,
+		bars:    make(map[string]*Progress),
+		tty:     IsTerminal(os.Stdout),
+		started: time.Now(),
+	}
+}
+
+// IsTerminal reports whether f is a character device (interactive terminal).
+func IsTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// Start prints the title line.
+func (g *ProgressGroup) Start() {
+	fmt.Println(g.title)
+}
+
+// Update records the state of item id, adding its bar on first use. current/total are
+// bytes (0 keeps the previous value); done fills the bar.
+func (g *ProgressGroup) Update(id, status string, current, total int64, done bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.bars[id]
+	if !ok {
+		p = &Progress{mu: &g.mu, bytes: true, group: g}
+		g.bars[id] = p
+		g.order = append(g.order, id)
+	}
+	message := fmt.Sprintf("%-12s %-18s", id, status)
+	changed := p.message != message
+	p.message = message
+	if total > 0 {
+		p.total = int(total)
+	}
+	if current > 0 {
+		p.current = int(current)
+	}
+	if done && p.total > 0 {
+		p.current = p.total
+	}
+
+	if !g.tty {
+		if changed {
+			fmt.Printf("%s: %s\n", id, status)
+		}
+		return
+	}
+	g.draw(changed)
+}
+
+// Reset forgets all bars (used before a retry) but keeps the elapsed timer.
+func (g *ProgressGroup) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.bars = make(map[string]*Progress)
+	g.order = nil
+	g.drawn = 0
+}
+
+// Finish draws the final state and prints msg.
+func (g *ProgressGroup) Finish(msg string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	current, total := g.totals()
+	if g.tty {
+		g.draw(true)
+	} else if total > 0 {
+		fmt.Printf("Total: %s / %s\n", FormatBytes(current), FormatBytes(total))
+	}
+	if msg != "" {
+		fmt.Printf("✔ %s (%s)\n", msg, time.Since(g.started).Round(time.Second))
+	}
+}
+
+func (g *ProgressGroup) totals() (int64, int64) {
+	var current, total int64
+	for _, p := range g.bars {
+		current += int64(p.current)
+		total += int64(p.total)
+	}
+	return current, total
+}
+
+// draw redraws every bar in place, at most every 100ms unless force is set. The caller
+// holds g.mu.
+func (g *ProgressGroup) draw(force bool) {
+	if !g.tty || (!force && time.Since(g.lastDraw) < 100*time.Millisecond) {
+		return
+	}
+	if g.drawn > 0 {
+		fmt.Printf("\033[%dA", g.drawn)
+	}
+	for _, id := range g.order {
+		fmt.Printf("\r\033[K%s\n", g.bars[id].line(30))
+	}
+
+	current, total := g.totals()
+	summary := fmt.Sprintf("Total: %s", FormatBytes(current))
+	if total > 0 {
+		summary += " / " + FormatBytes(total)
+		if eta, ok := g.eta(current, total); ok {
+			summary += fmt.Sprintf("  ETA %s", eta)
+		}
+	}
+	fmt.Printf("\r\033[K%s\n", summary)
+
+	g.drawn = len(g.order) + 1
+	g.lastDraw = time.Now()
+}
+
+func (g *ProgressGroup) eta(current, total int64) (time.Duration, bool) {
+	elapsed := time.Since(g.started).Seconds()
+	if current <= 0 || elapsed < 1 || current >= total {
+		return 0, false
+	}
+	rate := float64(current) / elapsed
+	return time.Duration(float64(total-current)/rate) * time.Second, true
+}
+
+// FormatBytes renders n with a binary unit suffix (e.g. 1.5 GiB).
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -5,6 +5,7 @@ import (
 	"os/exec"
 	"strings"
 
+	"reddock/pkg/adb"
 	"reddock/pkg/container"
 )
 
@@ -20,6 +21,15 @@ func NewAdbManager(containerName string) *AdbManager {
 	}
 }
 
+// Connect opens an ADB connection to the instance's published port with the built-in
+// client, so no adb binary is needed.
+func (a *AdbManager) Connect() (*adb.Conn, error) {
+	if !a.manager.IsRunning() {
+		return nil, fmt.Errorf("The container '%s' is not running. Start it with 'reddock start %s'", a.containerName, a.containerName)
+	}
+	return adb.Connect(fmt.Sprintf("127.0.0.1:%d", a.manager.GetContainer().HostADBPort()))
+}
+
 func (a *AdbManager) ShowConnection() error {
 	if err := container.CheckRoot(); err != nil {
 		return err
@@ -49,16 +59,29 @@ func (a *AdbManager) ShowConnection() error {
 	fmt.Printf("Connection: localhost:%d\n", port)
 	fmt.Printf("Internal IP: %s\n", ip)
 
-	fmt.Printf("\nAttempting to connect via ADB...\n")
-	cmd := exec.Command("adb", "connect", fmt.Sprintf("localhost:%d", port))
-	output, _ := cmd.CombinedOutput()
-	fmt.Printf("ADB Output: %s", string(output))
+	fmt.Printf("\nChecking adbd with the built-in ADB client...\n")
+	conn, err := a.Connect()
+	if err != nil {
+		fmt.Printf("ADB check failed: %v\n", err)
+	} else {
+		version, _ := conn.Getprop("ro.build.version.release")
+		props := conn.Device().Properties
+		fmt.Printf("ADB OK: %s (%s), Android %s\n", props["ro.product.model"], props["ro.product.name"], version)
+		conn.Close()
+	}
+
+	if _, err := exec.LookPath("adb"); err == nil {
+		output, _ := exec.Command("adb", "connect", fmt.Sprintf("localhost:%d", port)).CombinedOutput()
+		fmt.Printf("adb connect: %s", string(output))
+	}
 
-	fmt.Printf("\nYou can now use:\n")
+	fmt.Printf("\nWith platform-tools installed you can now use:\n")
 	fmt.Printf("  adb shell              # Access Android shell\n")
 	fmt.Printf("  adb install app.apk    # Install APK\n")
 	fmt.Printf("  adb logcat             # View logs\n")
 	fmt.Printf("  scrcpy -s localhost:%d # Run scrcpy\n", port)
+	fmt.Printf("\nWithout platform-tools:\n")
+	fmt.Printf("  reddock install %s app.apk  # Install APK, split APKs or XAPK\n", a.containerName)
 
 	return nil
 }
@@ -0,0 +1,466 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"reddock/pkg/adb"
+)
+
+// bundletool .apks archives describe their APKs in toc.pb, a BuildApksResult protobuf.
+// apksToc keeps what `bundletool install-apks` uses to pick the split APKs for one device:
+// the SDK of each variant, the delivery of each module, and the ABI, screen density,
+// language and SDK targeting of each split.
+type apksToc struct {
+	variants []apksVariant
+}
+
+type apksVariant struct {
+	number  uint64
+	minSdk  int
+	modules []apksModule
+}
+
+type apksModule struct {
+	name        string
+	installTime bool
+	splits      []apksSplit
+}
+
+type apksSplit struct {
+	path      string
+	targeting apkTargeting
+}
+
+type apkTargeting struct {
+	abis, abiAlternatives           []string
+	densities, densityAlternatives  []int
+	languages, languageAlternatives []string
+	minSdk                          int
+	// other is set for dimensions reddock does not evaluate (texture compression, device
+	// tier, ...); such splits are left out.
+	other bool
+}
+
+// deviceSpec is the part of a device's configuration that split selection looks at.
+type deviceSpec struct {
+	abis     []string // ro.product.cpu.abilist, most preferred first
+	sdk      int
+	density  int
+	language string
+}
+
+// Field numbers from bundletool's commands.proto and targeting.proto.
+var (
+	abiAliases       = map[uint64]string{1: "armeabi", 2: "armeabi-v7a", 3: "arm64-v8a", 4: "x86", 5: "x86_64", 6: "mips", 7: "mips64", 8: "riscv64"}
+	densityAliasDpis = map[uint64]int{2: 120, 3: 160, 4: 213, 5: 240, 6: 320, 7: 480, 8: 640}
+)
+
+const deliveryInstallTime = 1
+
+func parseApksToc(data []byte) (*apksToc, error) {
+	fields, err := protoFields(data)
+	if err != nil {
+		return nil, err
+	}
+	toc := &apksToc{}
+	for _, f := range fields.all(1) {
+		v, err := parseApksVariant(f.bytes)
+		if err != nil {
+			return nil, err
+		}
+		toc.variants = append(toc.variants, v)
+	}
+	return toc, nil
+}
+
+func parseApksVariant(data []byte) (apksVariant, error) {
+	var v apksVariant
+	fields, err := protoFields(data)
+	if err != nil {
+		return v, err
+	}
+	v.number = fields.varint(3)
+	if t, ok := fields.first(1); ok {
+		targeting, err := protoFields(t.bytes)
+		if err != nil {
+			return v, err
+		}
+		if sdk, ok := targeting.first(1); ok {
+			if v.minSdk, _, err = parseSdkTargeting(sdk.bytes); err != nil {
+				return v, err
+			}
+		}
+	}
+	for _, f := range fields.all(2) {
+		m, err := parseApksModule(f.bytes)
+		if err != nil {
+			return v, err
+		}
+		v.modules = append(v.modules, m)
+	}
+	return v, nil
+}
+
+func parseApksModule(data []byte) (apksModule, error) {
+	var m apksModule
+	fields, err := protoFields(data)
+	if err != nil {
+		return m, err
+	}
+	if f, ok := fields.first(1); ok {
+		meta, err := protoFields(f.bytes)
+		if err != nil {
+			return m, err
+		}
+		m.name = meta.string(1)
+		delivery, onDemand := meta.varint(6), meta.varint(2) != 0
+		m.installTime = m.name == "base" || delivery == deliveryInstallTime || (delivery == 0 && !onDemand)
+	}
+	for _, f := range fields.all(2) {
+		apk, err := protoFields(f.bytes)
+		if err != nil {
+			return m, err
+		}
+		// Only split APKs; standalone, instant and other APK kinds carry other metadata.
+		if _, ok := apk.first(3); !ok {
+			continue
+		}
+		s := apksSplit{path: apk.string(2)}
+		if t, ok := apk.first(1); ok {
+			if s.targeting, err = parseApkTargeting(t.bytes); err != nil {
+				return m, err
+			}
+		}
+		m.splits = append(m.splits, s)
+	}
+	return m, nil
+}
+
+func parseApkTargeting(data []byte) (apkTargeting, error) {
+	var t apkTargeting
+	fields, err := protoFields(data)
+	if err != nil {
+		return t, err
+	}
+	for _, f := range fields {
+		switch f.num {
+		case 1: // AbiTargeting
+			if t.abis, t.abiAlternatives, err = parseAbiTargeting(f.bytes); err != nil {
+				return t, err
+			}
+		case 3: // LanguageTargeting
+			lang, err := protoFields(f.bytes)
+			if err != nil {
+				return t, err
+			}
+			t.languages, t.languageAlternatives = lang.strings(1), lang.strings(2)
+		case 4: // ScreenDensityTargeting
+			if t.densities, t.densityAlternatives, err = parseDensityTargeting(f.bytes); err != nil {
+				return t, err
+			}
+		case 5: // SdkVersionTargeting
+			if t.minSdk, _, err = parseSdkTargeting(f.bytes); err != nil {
+				return t, err
+			}
+		default:
+			t.other = true
+		}
+	}
+	return t, nil
+}
+
+func parseAbiTargeting(data []byte) (values, alternatives []string, err error) {
+	fields, err := protoFields(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range fields {
+		abi, err := protoFields(f.bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		name := abiAliases[abi.varint(1)]
+		switch f.num {
+		case 1:
+			values = append(values, name)
+		case 2:
+			alternatives = append(alternatives, name)
+		}
+	}
+	return values, alternatives, nil
+}
+
+func parseDensityTargeting(data []byte) (values, alternatives []int, err error) {
+	fields, err := protoFields(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range fields {
+		d, err := protoFields(f.bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		dpi := int(d.varint(2))
+		if alias, ok := d.first(1); ok {
+			dpi = densityAliasDpis[alias.varint]
+		}
+		switch f.num {
+		case 1:
+			values = append(values, dpi)
+		case 2:
+			alternatives = append(alternatives, dpi)
+		}
+	}
+	return values, alternatives, nil
+}
+
+// parseSdkTargeting returns the highest minimum SDK among the values, and whether any was set.
+func parseSdkTargeting(data []byte) (int, bool, error) {
+	fields, err := protoFields(data)
+	if err != nil {
+		return 0, false, err
+	}
+	min, set := 0, false
+	for _, f := range fields.all(1) {
+		sdk, err := protoFields(f.bytes)
+		if err != nil {
+			return 0, false, err
+		}
+		if v, ok := sdk.first(1); ok {
+			wrapper, err := protoFields(v.bytes)
+			if err != nil {
+				return 0, false, err
+			}
+			if n := int(int32(wrapper.varint(1))); !set || n > min {
+				min, set = n, true
+			}
+		}
+	}
+	return min, set, nil
+}
+
+// selectSplits picks the APKs `bundletool install-apks` would install on device: the variant
+// with the highest minimum SDK the device meets, and in it the install-time modules' splits
+// whose targeting is the best match for the device among their alternatives.
+func (t *apksToc) selectSplits(device deviceSpec) ([]string, error) {
+	var variant *apksVariant
+	for i := range t.variants {
+		v := &t.variants[i]
+		if v.minSdk > device.sdk || !v.hasSplits() {
+			continue
+		}
+		if variant == nil || v.minSdk > variant.minSdk || (v.minSdk == variant.minSdk && v.number > variant.number) {
+			variant = v
+		}
+	}
+	if variant == nil {
+		return nil, fmt.Errorf("no split APK variant supports Android SDK %d", device.sdk)
+	}
+
+	var paths []string
+	for _, m := range variant.modules {
+		if !m.installTime {
+			continue
+		}
+		for _, s := range m.splits {
+			if s.targeting.matches(device) {
+				paths = append(paths, s.path)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no split APKs match the device (%s, SDK %d)", strings.Join(device.abis, ","), device.sdk)
+	}
+	return paths, nil
+}
+
+func (v *apksVariant) hasSplits() bool {
+	for _, m := range v.modules {
+		if len(m.splits) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (t apkTargeting) matches(device deviceSpec) bool {
+	if t.other || t.minSdk > device.sdk {
+		return false
+	}
+	if len(t.abis) > 0 && !containsString(t.abis, bestAbi(device.abis, append(t.abis, t.abiAlternatives...))) {
+		return false
+	}
+	if len(t.densities) > 0 && !containsInt(t.densities, bestDensity(device.density, append(t.densities, t.densityAlternatives...))) {
+		return false
+	}
+	if len(t.languages) > 0 && !containsString(t.languages, device.language) {
+		return false
+	}
+	return true
+}
+
+// bestAbi is the device's most preferred ABI among candidates, or "" when it runs none.
+func bestAbi(deviceAbis, candidates []string) string {
+	for _, abi := range deviceAbis {
+		if containsString(candidates, abi) {
+			return abi
+		}
+	}
+	return ""
+}
+
+// bestDensity is the smallest candidate at least as dense as the screen, or the densest
+// candidate when all are below it, as Android's resource selection scales down better than up.
+func bestDensity(density int, candidates []int) int {
+	sorted := append([]int(nil), candidates...)
+	sort.Ints(sorted)
+	for _, d := range sorted {
+		if d >= density {
+			return d
+		}
+	}
+	return sorted[len(sorted)-1]
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// readDeviceSpec asks the device for the properties split selection needs.
+func readDeviceSpec(conn *adb.Conn) (deviceSpec, error) {
+	var spec deviceSpec
+	abis, err := conn.Getprop("ro.product.cpu.abilist")
+	if err != nil {
+		return spec, err
+	}
+	if abis == "" {
+		abis, _ = conn.Getprop("ro.product.cpu.abi")
+	}
+	for _, abi := range strings.Split(abis, ",") {
+		if abi = strings.TrimSpace(abi); abi != "" {
+			spec.abis = append(spec.abis, abi)
+		}
+	}
+	sdk, _ := conn.Getprop("ro.build.version.sdk")
+	if spec.sdk, err = strconv.Atoi(sdk); err != nil {
+		return spec, fmt.Errorf("cannot read the device SDK version (ro.build.version.sdk=%q)", sdk)
+	}
+	density, _ := conn.Getprop("ro.sf.lcd_density")
+	if spec.density, _ = strconv.Atoi(density); spec.density == 0 {
+		spec.density = 160
+	}
+	locale, _ := conn.Getprop("persist.sys.locale")
+	if locale == "" {
+		locale, _ = conn.Getprop("ro.product.locale")
+	}
+	spec.language, _, _ = strings.Cut(locale, "-")
+	if spec.language == "" {
+		spec.language = "en"
+	}
+	return spec, nil
+}
+
+// protoField is one field of a protobuf message: varint holds varint and fixed-size values,
+// bytes length-delimited ones (strings and embedded messages).
+type protoField struct {
+	num    int
+	varint uint64
+	bytes  []byte
+}
+
+type protoMessage []protoField
+
+// protoFields splits a protobuf message into its fields, in wire order.
+func protoFields(data []byte) (protoMessage, error) {
+	var fields protoMessage
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("malformed protobuf field key")
+		}
+		data = data[n:]
+		f := protoField{num: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			if f.varint, n = binary.Uvarint(data); n <= 0 {
+				return nil, fmt.Errorf("malformed protobuf varint")
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return nil, fmt.Errorf("truncated protobuf field")
+			}
+			f.varint, data = binary.LittleEndian.Uint64(data), data[8:]
+		case 2:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return nil, fmt.Errorf("truncated protobuf field")
+			}
+			f.bytes, data = data[n:n+int(size)], data[n+int(size):]
+		case 5:
+			if len(data) < 4 {
+				return nil, fmt.Errorf("truncated protobuf field")
+			}
+			f.varint, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		default:
+			return nil, fmt.Errorf("unsupported protobuf wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func (m protoMessage) first(num int) (protoField, bool) {
+	for _, f := range m {
+		if f.num == num {
+			return f, true
+		}
+	}
+	return protoField{}, false
+}
+
+func (m protoMessage) all(num int) []protoField {
+	var out []protoField
+	for _, f := range m {
+		if f.num == num {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func (m protoMessage) varint(num int) uint64 {
+	f, _ := m.first(num)
+	return f.varint
+}
+
+func (m protoMessage) string(num int) string {
+	f, _ := m.first(num)
+	return string(f.bytes)
+}
+
+func (m protoMessage) strings(num int) []string {
+	var out []string
+	for _, f := range m.all(num) {
+		out = append(out, string(f.bytes))
+	}
+	return out
+}
@@ -1,39 +1,22 @@
 package utils
 
-import (
-	"os"
-	"runtime"
-	"strings"
-)
+import "reddock/pkg/sysinfo"
+
+// CPU detection lives in sysinfo so the container package can use it; these aliases keep
+// the utils API stable.
 
-type CPUVendor string
+type CPUVendor = sysinfo.CPUVendor
 
 const (
-	VendorIntel CPUVendor = "intel"
-	VendorAMD   CPUVendor = "amd"
-	VendorOther CPUVendor = "other"
+	VendorIntel = sysinfo.VendorIntel
+	VendorAMD   = sysinfo.VendorAMD
+	VendorOther = sysinfo.VendorOther
 )
 
 func GetCPUVendor() CPUVendor {
-	data, err := os.ReadFile("/proc/cpuinfo")
-	if err != nil {
-		return VendorOther
-	}
-
-	content := string(data)
-	if strings.Contains(content, "GenuineIntel") {
-		return VendorIntel
-	} else if strings.Contains(content, "AuthenticAMD") {
-		return VendorAMD
-	}
-
-	return VendorOther
-}
-func (v CPUVendor) String() string {
-	return string(v)
+	return sysinfo.GetCPUVendor()
 }
 
 func IsARM() bool {
-	arch := runtime.GOARCH
-	return arch == "arm" || arch == "arm64"
+	return sysinfo.IsARM()
 }
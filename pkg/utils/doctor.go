@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"reddock/pkg/container"
+	"reddock/pkg/sysinfo"
+)
+
+type checkLevel int
+
+const (
+	checkOK checkLevel = iota
+	checkWarn
+	checkFail
+)
+
+func (l checkLevel) String() string {
+	switch l {
+	case checkOK:
+		return "OK"
+	case checkWarn:
+		return "WARN"
+	default:
+		return "FAIL"
+	}
+}
+
+type doctorCheck struct {
+	name   string
+	level  checkLevel
+	detail string
+}
+
+type Doctor struct {
+	runtime container.Runtime
+}
+
+func NewDoctor() *Doctor {
+	return &Doctor{runtime: container.NewRuntime()}
+}
+
+// Run prints one line per host check and fails when a required feature is missing.
+func (d *Doctor) Run() error {
+	fmt.Println("Reddock Doctor")
+	fmt.Println("==============")
+	fmt.Println()
+
+	checks := []doctorCheck{
+		d.checkDocker(),
+		d.checkBinder(),
+		d.checkBinderFS(),
+		d.checkMemfd(),
+		d.checkGPU(),
+		d.checkEmulation(),
+		d.checkLSM(),
+		d.checkRestrictedProfile(),
+	}
+
+	failed := 0
+	for _, c := range checks {
+		fmt.Printf("[%-4s] %-22s %s\n", c.level, c.name, c.detail)
+		if c.level == checkFail {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	fmt.Println("\nNo blocking problems found.")
+	return nil
+}
+
+func (d *Doctor) checkDocker() doctorCheck {
+	out, err := d.runtime.Command("version", "--format", "{{.Server.Version}}").CombinedOutput()
+	if err != nil {
+		return doctorCheck{"Docker daemon", checkFail, "not reachable: " + strings.TrimSpace(string(out))}
+	}
+	return doctorCheck{"Docker daemon", checkOK, "server " + strings.TrimSpace(string(out))}
+}
+
+func (d *Doctor) checkBinder() doctorCheck {
+	b := sysinfo.ProbeBinderHost()
+	switch {
+	case b.HostBinderUsable():
+		return doctorCheck{"Binder", checkOK, "binder module and/or device nodes detected"}
+	case b.BinderLinuxInstallable():
+		return doctorCheck{"Binder", checkWarn, "binder_linux is packaged but not loaded (reddock init will try modprobe)"}
+	default:
+		return doctorCheck{"Binder", checkFail, "no binder_linux module or binder devices for kernel " + b.KernelRelease}
+	}
+}
+
+func (d *Doctor) checkBinderFS() doctorCheck {
+	if sysinfo.ProbeBinderHost().BinderFSInProcFS {
+		return doctorCheck{"Binder isolation", checkOK, "binderfs available; instances can get private binder devices"}
+	}
+	return doctorCheck{"Binder isolation", checkWarn, "binderfs not available; instances share host binder devices"}
+}
+
+func (d *Doctor) checkMemfd() doctorCheck {
+	if _, err := os.Stat("/dev/ashmem"); err == nil {
+		return doctorCheck{"Shared memory", checkOK, "/dev/ashmem present"}
+	}
+	return doctorCheck{"Shared memory", checkOK, "no /dev/ashmem; redroid uses memfd (androidboot.use_memfd=true)"}
+}
+
+func (d *Doctor) checkGPU() doctorCheck {
+	nodes := sysinfo.ProbeRenderNodes()
+	if node, ok := sysinfo.SelectRenderNode(nodes, sysinfo.GetCPUVendor()); ok {
+		return doctorCheck{"GPU", checkOK, "host mode available on " + node.String()}
+	}
+	return doctorCheck{"GPU", checkWarn, sysinfo.RenderNodesSummary(nodes) + "; only guest (software) mode is available"}
+}
+
+func (d *Doctor) checkEmulation() doctorCheck {
+	if !sysinfo.BinfmtMiscMounted() {
+		return doctorCheck{"Foreign-arch images", checkWarn, "binfmt_misc not mounted; only " + sysinfo.HostImageArch() + " images can run"}
+	}
+	archs := sysinfo.EmulatedArchs()
+	if len(archs) == 0 {
+		return doctorCheck{"Foreign-arch images", checkOK, "no qemu-user handlers; only " + sysinfo.HostImageArch() + " images can run"}
+	}
+	return doctorCheck{"Foreign-arch images", checkOK, "qemu-user emulation for " + strings.Join(archs, ", ")}
+}
+
+func (d *Doctor) checkLSM() doctorCheck {
+	lsm := sysinfo.ProbeHostLSM()
+	if lsm.SELinuxMayBlockDocker() || lsm.AppArmorMayAffectDocker() {
+		return doctorCheck{"Host MAC (LSM)", checkWarn, lsm.HostLSMStatusLine() + " (see reddock status for remediation)"}
+	}
+	return doctorCheck{"Host MAC (LSM)", checkOK, lsm.HostLSMStatusLine()}
+}
+
+func (d *Doctor) checkRestrictedProfile() doctorCheck {
+	problems := container.RestrictedProfileProblems(d.runtime)
+	if len(problems) > 0 {
+		return doctorCheck{"Restricted profile", checkWarn, "unsupported: " + strings.Join(problems, "; ")}
+	}
+	return doctorCheck{"Restricted profile", checkOK, "host supports --profile restricted"}
+}
@@ -0,0 +1,381 @@
+package utils
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"reddock/pkg/adb"
+	"reddock/pkg/ui"
+)
+
+// InstallOptions controls `reddock install`.
+type InstallOptions struct {
+	GrantPermissions bool // -g
+	AllowDowngrade   bool // -d
+	Multiple         bool // treat all given APK files as the splits of one package
+}
+
+// installPackage is one unit for pm: a single APK or the APKs of one split package, plus
+// OBB expansion files from an XAPK. For a bundletool .apks, toc picks the splits for each
+// device from splits (archive path to extracted file).
+type installPackage struct {
+	source  string
+	apks    []string
+	obbs    []obbFile
+	tempDir string
+
+	toc    *apksToc
+	splits map[string]string
+}
+
+type obbFile struct {
+	local  string
+	remote string // below /sdcard, e.g. Android/obb/<package>/main.1.<package>.obb
+}
+
+// InstallResult is the outcome of one package on one instance.
+type InstallResult struct {
+	Container string
+	Source    string
+	Err       error
+	Duration  time.Duration
+}
+
+// xapkManifest is the part of an XAPK's manifest.json reddock needs.
+type xapkManifest struct {
+	PackageName string `json:"package_name"`
+	Expansions  []struct {
+		File        string `json:"file"`
+		InstallPath string `json:"install_path"`
+	} `json:"expansions"`
+}
+
+// InstallPackages installs every source (APK, directory of split APKs, .xapk or .apks)
+// on each container. Containers are handled in parallel, packages on one container in
+// order. It prints a summary table and fails if any install failed.
+func InstallPackages(containers, sources []string, opts InstallOptions) error {
+	packages, err := resolvePackages(sources, opts.Multiple)
+	defer func() {
+		for _, p := range packages {
+			if p.tempDir != "" {
+				os.RemoveAll(p.tempDir)
+			}
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	bar := ui.NewProgressBar(len(containers)*len(packages), fmt.Sprintf("Installing %d package(s) on %d instance(s)...", len(packages), len(containers)))
+	bar.Start()
+	results := make([]InstallResult, 0, len(containers)*len(packages))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range containers {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			for _, r := range installOn(name, packages, opts, bar) {
+				mu.Lock()
+				results = append(results, r)
+				mu.Unlock()
+			}
+		}(name)
+	}
+	wg.Wait()
+	bar.Finish("")
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Container < results[j].Container })
+	fmt.Printf("\n%-20s %-36s %-8s %s\n", "INSTANCE", "PACKAGE", "TIME", "RESULT")
+	fmt.Println(strings.Repeat("-", 90))
+	failed := 0
+	for _, r := range results {
+		result := "Success"
+		if r.Err != nil {
+			result = r.Err.Error()
+			failed++
+		}
+		fmt.Printf("%-20s %-36s %-8s %s\n", r.Container, filepath.Base(r.Source), r.Duration.Round(100*time.Millisecond), result)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d install(s) failed", failed, len(results))
+	}
+	fmt.Printf("\nAll %d install(s) succeeded\n", len(results))
+	return nil
+}
+
+func installOn(name string, packages []*installPackage, opts InstallOptions, bar *ui.Progress) []InstallResult {
+	results := make([]InstallResult, len(packages))
+	conn, err := NewAdbManager(name).Connect()
+	for i, p := range packages {
+		results[i] = InstallResult{Container: name, Source: p.source, Err: err}
+	}
+	if err != nil {
+		for range packages {
+			bar.Increment()
+		}
+		return results
+	}
+	defer conn.Close()
+
+	// bundletool .apks splits are chosen per device.
+	var spec deviceSpec
+	var specErr error
+	for _, p := range packages {
+		if p.toc != nil {
+			if spec, specErr = readDeviceSpec(conn); specErr != nil {
+				specErr = fmt.Errorf("Failed to read the device configuration: %v", specErr)
+			}
+			break
+		}
+	}
+
+	for i, p := range packages {
+		start := time.Now()
+		apks, err := p.apks, error(nil)
+		switch {
+		case p.toc != nil && specErr != nil:
+			err = specErr
+		case p.toc != nil:
+			apks, err = p.selectSplits(spec)
+		}
+		if err == nil {
+			err = conn.Install(apks, adb.InstallOptions{GrantPermissions: opts.GrantPermissions, AllowDowngrade: opts.AllowDowngrade})
+		}
+		if err == nil && len(p.obbs) > 0 {
+			err = pushOBBs(conn, p.obbs)
+		}
+		results[i].Err, results[i].Duration = err, time.Since(start)
+		bar.Increment()
+	}
+	return results
+}
+
+// pushOBBs places expansion files in shared storage, where games look for them.
+func pushOBBs(conn *adb.Conn, obbs []obbFile) error {
+	y, err := conn.OpenSync()
+	if err != nil {
+		return err
+	}
+	defer y.Close()
+	for _, o := range obbs {
+		remote := path.Join("/sdcard", o.remote)
+		if _, err := conn.Shell("mkdir -p " + adb.Quote(path.Dir(remote))); err != nil {
+			return fmt.Errorf("OBB %s: %v", o.remote, err)
+		}
+		f, err := os.Open(o.local)
+		if err != nil {
+			return err
+		}
+		err = y.Push(f, remote, 0660, time.Now(), nil)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("OBB %s: %v", o.remote, err)
+		}
+	}
+	return nil
+}
+
+// resolvePackages turns command line sources into install units.
+func resolvePackages(sources []string, multiple bool) ([]*installPackage, error) {
+	var packages []*installPackage
+	var splits []string
+	for _, src := range sources {
+		st, err := os.Stat(src)
+		if err != nil {
+			return packages, err
+		}
+		ext := strings.ToLower(filepath.Ext(src))
+		switch {
+		case st.IsDir():
+			apks, err := filepath.Glob(filepath.Join(src, "*.apk"))
+			if err != nil || len(apks) == 0 {
+				return packages, fmt.Errorf("No .apk files in %s", src)
+			}
+			packages = append(packages, &installPackage{source: src, apks: apks})
+		case ext == ".apk" && multiple:
+			splits = append(splits, src)
+		case ext == ".apk":
+			packages = append(packages, &installPackage{source: src, apks: []string{src}})
+		case ext == ".xapk" || ext == ".apks":
+			p, err := extractBundle(src)
+			if p != nil {
+				packages = append(packages, p)
+			}
+			if err != nil {
+				return packages, err
+			}
+		default:
+			return packages, fmt.Errorf("Unsupported package %s (use .apk, .xapk, .apks or a directory of split APKs)", src)
+		}
+	}
+	if len(splits) > 0 {
+		packages = append(packages, &installPackage{source: strings.Join(splits, ","), apks: splits})
+	}
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("No packages to install")
+	}
+	return packages, nil
+}
+
+// selectSplits is the extracted splits of a bundletool .apks that match device.
+func (p *installPackage) selectSplits(device deviceSpec) ([]string, error) {
+	paths, err := p.toc.selectSplits(device)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Base(p.source), err)
+	}
+	apks := make([]string, len(paths))
+	for i, path := range paths {
+		apks[i] = p.splits[path]
+	}
+	return apks, nil
+}
+
+// extractBundle unpacks the APKs (and XAPK OBBs) of a .xapk or .apks archive. A bundletool
+// .apks built in universal mode holds one universal.apk; otherwise its toc.pb describes the
+// splits, and each device gets the ones bundletool would install on it (see apksToc).
+// XAPK and SAI archives are installed whole.
+func extractBundle(src string) (*installPackage, error) {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s: %v", src, err)
+	}
+	defer zr.Close()
+	dir, err := os.MkdirTemp("", "reddock-install-")
+	if err != nil {
+		return nil, err
+	}
+	p := &installPackage{source: src, tempDir: dir}
+
+	var manifest xapkManifest
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+		if f.Name == "manifest.json" {
+			rc, err := f.Open()
+			if err != nil {
+				return p, err
+			}
+			err = json.NewDecoder(rc).Decode(&manifest)
+			rc.Close()
+			if err != nil {
+				return p, fmt.Errorf("Failed to parse manifest.json in %s: %v", src, err)
+			}
+		}
+	}
+
+	if f, ok := files["universal.apk"]; ok {
+		local, err := extractZipFile(f, dir, 0)
+		if err != nil {
+			return p, err
+		}
+		p.apks = []string{local}
+	} else if f, ok := files["toc.pb"]; ok {
+		return p, extractApksSplits(p, zr.File, f)
+	} else {
+		// XAPK and SAI .apks archives hold the splits of one device configuration.
+		for i, f := range zr.File {
+			if !strings.HasSuffix(strings.ToLower(f.Name), ".apk") || strings.HasPrefix(f.Name, "standalones/") {
+				continue
+			}
+			local, err := extractZipFile(f, dir, i)
+			if err != nil {
+				return p, err
+			}
+			p.apks = append(p.apks, local)
+		}
+	}
+	if len(p.apks) == 0 {
+		return p, fmt.Errorf("No APKs found in %s", src)
+	}
+
+	for i, e := range manifest.Expansions {
+		f, ok := files[e.File]
+		if !ok {
+			return p, fmt.Errorf("%s lists %s, which is missing", src, e.File)
+		}
+		remote := path.Clean(strings.TrimPrefix(e.InstallPath, "/"))
+		if remote == "." || strings.HasPrefix(remote, "..") {
+			return p, fmt.Errorf("%s has an invalid OBB install path %q", src, e.InstallPath)
+		}
+		local, err := extractZipFile(f, dir, len(zr.File)+i)
+		if err != nil {
+			return p, err
+		}
+		p.obbs = append(p.obbs, obbFile{local: local, remote: remote})
+	}
+	return p, nil
+}
+
+// extractApksSplits reads toc.pb and extracts every split APK it lists.
+func extractApksSplits(p *installPackage, archive []*zip.File, toc *zip.File) error {
+	rc, err := toc.Open()
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(rc, 16<<20))
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	if p.toc, err = parseApksToc(data); err != nil {
+		return fmt.Errorf("Failed to parse toc.pb in %s: %v", p.source, err)
+	}
+	wanted := make(map[string]bool)
+	for _, v := range p.toc.variants {
+		for _, m := range v.modules {
+			for _, s := range m.splits {
+				wanted[s.path] = true
+			}
+		}
+	}
+	p.splits = make(map[string]string)
+	for i, f := range archive {
+		if !wanted[f.Name] {
+			continue
+		}
+		local, err := extractZipFile(f, p.tempDir, i)
+		if err != nil {
+			return err
+		}
+		p.splits[f.Name] = local
+	}
+	for path := range wanted {
+		if _, ok := p.splits[path]; !ok {
+			return fmt.Errorf("%s lists %s, which is missing", p.source, path)
+		}
+	}
+	if len(p.splits) == 0 {
+		return fmt.Errorf("No split APKs found in %s", p.source)
+	}
+	return nil
+}
+
+// extractZipFile writes f into dir under a name derived from its base name and index, so
+// archive paths cannot escape dir.
+func extractZipFile(f *zip.File, dir string, index int) (string, error) {
+	local := filepath.Join(dir, fmt.Sprintf("%d-%s", index, path.Base(f.Name)))
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	out, err := os.Create(local)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return "", fmt.Errorf("Failed to extract %s: %v", f.Name, err)
+	}
+	return local, out.Close()
+}
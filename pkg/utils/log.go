@@ -1,14 +1,38 @@
 package utils
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"reddock/pkg/config"
 	"reddock/pkg/container"
+	"reddock/pkg/logfile"
 )
 
+// LogOptions controls `reddock log`. Since and Until take what docker logs accepts:
+// RFC 3339 timestamps or relative durations such as 10m or 2h.
+type LogOptions struct {
+	Tail       string // number of lines from the end, or "all"
+	Since      string
+	Until      string
+	NoFollow   bool
+	Timestamps bool
+
+	// Save appends the output to the instance's LogFile, rotated at MaxSize keeping Keep
+	// old files. Saved lines always carry their timestamp; without Tail or Since, a save
+	// resumes after the last saved line instead of appending the whole history again.
+	Save    bool
+	MaxSize int64
+	Keep    int
+}
+
 type LogManager struct {
 	config        *config.Config
 	containerName string
@@ -28,21 +52,156 @@ func NewLogManager(containerName string) *LogManager {
 	}
 }
 
-func (l *LogManager) Show() error {
+func (l *LogManager) Show(opts LogOptions) error {
 	if err := container.CheckRoot(); err != nil {
 		return err
 	}
 	cont := l.config.GetContainer(l.containerName)
 	if cont == nil {
-		return fmt.Errorf("container '%s' not found", l.containerName)
+		return fmt.Errorf("Container '%s' not found", l.containerName)
+	}
+	if opts.Tail != "" && opts.Tail != "all" {
+		if n, err := strconv.Atoi(opts.Tail); err != nil || n < 0 {
+			return fmt.Errorf("Invalid --tail %q (use a number of lines or 'all')", opts.Tail)
+		}
+	}
+	if !l.runtime.Exists(l.containerName) {
+		return fmt.Errorf("Container '%s' has no Docker container (it is created on start); saved logs are in %s", l.containerName, cont.LogFilePath())
 	}
 
-	fmt.Printf("Showing the logs for container: %s\n", l.containerName)
-	fmt.Println("Press Ctrl+C to exit")
+	args := []string{"logs"}
+	if !opts.NoFollow {
+		args = append(args, "-f")
+	}
+	if opts.Tail != "" {
+		args = append(args, "--tail", opts.Tail)
+	}
+	if opts.Since != "" {
+		args = append(args, "--since", opts.Since)
+	}
+	if opts.Until != "" {
+		args = append(args, "--until", opts.Until)
+	}
+	if opts.Save && opts.Tail == "" && opts.Since == "" {
+		if last, ok := lastSavedTime(cont.LogFilePath()); ok {
+			// --since is inclusive; start just after the last saved line.
+			next := last.Add(time.Nanosecond)
+			args = append(args, "--since", fmt.Sprintf("%d.%09d", next.Unix(), next.Nanosecond()))
+			fmt.Fprintf(os.Stderr, "Resuming after the last saved line (%s)\n", last.Format(time.RFC3339Nano))
+		}
+	}
+	if opts.Timestamps || opts.Save {
+		args = append(args, "--timestamps")
+	}
+	args = append(args, l.containerName)
+	cmd := exec.Command(l.runtime.Name(), args...)
 
-	cmd := exec.Command(l.runtime.Name(), "logs", "-f", l.containerName)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	if !opts.NoFollow {
+		// On stderr, so a followed log piped into another tool stays clean.
+		fmt.Fprintf(os.Stderr, "Showing the logs for container: %s\n", l.containerName)
+		fmt.Fprintln(os.Stderr, "Press Ctrl+C to exit")
+	}
+	if !opts.Save {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
 
-	return cmd.Run()
+	f, err := logfile.Open(cont.LogFilePath(), opts.MaxSize, opts.Keep)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fmt.Fprintf(os.Stderr, "Saving to %s\n", cont.LogFilePath())
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// Container stdout and stderr are copied line by line, so the file never rotates or
+	// interleaves in the middle of a line. Lines have no length limit and write errors
+	// do not stop the copy: docker logs blocks (and Wait hangs) once a pipe is not drained.
+	var wg sync.WaitGroup
+	for _, s := range []struct {
+		r io.Reader
+		w io.Writer
+	}{{stdout, os.Stdout}, {stderr, os.Stderr}} {
+		wg.Add(1)
+		go func(r io.Reader, w io.Writer) {
+			defer wg.Done()
+			br := bufio.NewReader(r)
+			for {
+				line, err := br.ReadString('\n')
+				if line != "" {
+					if opts.Timestamps {
+						io.WriteString(w, line)
+					} else {
+						io.WriteString(w, stripTimestamp(line))
+					}
+					f.Write([]byte(line))
+				}
+				if err != nil {
+					return
+				}
+			}
+		}(s.r, s.w)
+	}
+	wg.Wait()
+	return cmd.Wait()
+}
+
+// stripTimestamp removes the timestamp docker logs --timestamps puts before each line.
+func stripTimestamp(line string) string {
+	if ts, rest, ok := strings.Cut(line, " "); ok {
+		if _, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			return rest
+		}
+	}
+	return line
+}
+
+// lastSavedTime is the timestamp of the last timestamped line in the saved log, looking at
+// the newest rotated file when the current one has none (it may just have rotated).
+func lastSavedTime(path string) (time.Time, bool) {
+	for _, p := range []string{path, path + ".1"} {
+		if t, ok := lastTimestamp(p); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func lastTimestamp(path string) (time.Time, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return time.Time{}, false
+	}
+	// The last lines are enough; other writers (start failure reports) add untimestamped ones.
+	offset := st.Size() - 256<<10
+	if offset < 0 {
+		offset = 0
+	}
+	data, err := io.ReadAll(io.NewSectionReader(f, offset, st.Size()-offset))
+	if err != nil {
+		return time.Time{}, false
+	}
+	lines := strings.Split(string(data), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		ts, _, _ := strings.Cut(lines[i], " ")
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
 }
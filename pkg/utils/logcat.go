@@ -0,0 +1,377 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"reddock/pkg/adb"
+	"reddock/pkg/config"
+	"reddock/pkg/container"
+	"reddock/pkg/logfile"
+)
+
+// Logcat output formats.
+const (
+	LogcatFormatBrief      = "brief"
+	LogcatFormatThreadtime = "threadtime"
+	LogcatFormatJSON       = "json"
+)
+
+// LogcatOptions controls `reddock logcat`.
+type LogcatOptions struct {
+	Filters  []string // logcat filterspecs, e.g. ActivityManager:I *:S
+	Priority string   // minimum priority for every tag (V, D, I, W, E, F)
+	Pid      int
+	Package  string
+	Grep     string // regular expression matched against the tag and message
+	Format   string
+	Buffers  string // comma-separated buffers (main, system, crash, events, ...)
+	Dump     bool   // print the buffered log and exit
+
+	// Save appends the (formatted, filtered) output to rotating files in the instance's log
+	// directory and keeps reconnecting while the instance restarts. Quiet stops printing.
+	Save    bool
+	Quiet   bool
+	MaxSize int64
+	Keep    int
+}
+
+// LogcatEntry is one parsed threadtime line; its JSON form is the json output format.
+// Time is in UTC and includes the year ("2006-01-02 15:04:05.000").
+type LogcatEntry struct {
+	Time     string `json:"time"`
+	Pid      int    `json:"pid"`
+	Tid      int    `json:"tid"`
+	Priority string `json:"priority"`
+	Tag      string `json:"tag"`
+	Message  string `json:"message"`
+}
+
+var (
+	threadtimePattern = regexp.MustCompile(`^(\d{4}-\d\d-\d\d \d\d:\d\d:\d\d\.\d+)\s+(\d+)\s+(\d+)\s+([VDIWEFS])\s(.*?)\s*: (.*)$`)
+	filterSpecPattern = regexp.MustCompile(`^[^\s:]+:[VDIWEFS*]$`)
+	bufferPattern     = regexp.MustCompile(`^[a-z]+(,[a-z]+)*$`)
+)
+
+// logcatReconnectDelay is how long a saving logcat waits before reconnecting.
+const logcatReconnectDelay = 5 * time.Second
+
+// logcatTimeLayout parses the time of `logcat -v threadtime -v year -v UTC` lines.
+const logcatTimeLayout = "2006-01-02 15:04:05.000"
+
+// logcatPidGrace is how long --package keeps showing a process after it has exited, so
+// its last lines still show; after that the PID may belong to another process.
+const logcatPidGrace = 30 * time.Second
+
+type LogcatManager struct {
+	containerName string
+	opts          LogcatOptions
+	grep          *regexp.Regexp
+
+	mu   sync.Mutex
+	pids map[int]time.Time // --pid/--package filter with when each was last running; nil means every process
+}
+
+func NewLogcatManager(containerName string, opts LogcatOptions) *LogcatManager {
+	return &LogcatManager{
+		containerName: containerName,
+		opts:          opts,
+	}
+}
+
+// LogcatPath is the current saved logcat file of an instance; rotated files get .1, .2, ...
+func LogcatPath(containerName string) string {
+	return filepath.Join(config.GetLogDir(containerName), "logcat.log")
+}
+
+func (l *LogcatManager) validate() error {
+	o := &l.opts
+	switch o.Format {
+	case "":
+		o.Format = LogcatFormatThreadtime
+	case LogcatFormatBrief, LogcatFormatThreadtime, LogcatFormatJSON:
+	default:
+		return fmt.Errorf("Unknown logcat format '%s' (use brief, threadtime or json)", o.Format)
+	}
+	if o.Priority != "" {
+		o.Priority = strings.ToUpper(o.Priority)[:1]
+		if !strings.Contains("VDIWEF", o.Priority) {
+			return fmt.Errorf("Invalid priority '%s' (use V, D, I, W, E or F)", o.Priority)
+		}
+	}
+	for _, f := range o.Filters {
+		if !filterSpecPattern.MatchString(f) {
+			return fmt.Errorf("Invalid filter '%s' (use <tag>:<priority>, e.g. ActivityManager:I or *:S)", f)
+		}
+	}
+	if o.Buffers != "" && !bufferPattern.MatchString(o.Buffers) {
+		return fmt.Errorf("Invalid buffer list '%s' (e.g. main,system,crash)", o.Buffers)
+	}
+	if o.Pid != 0 && o.Package != "" {
+		return fmt.Errorf("--pid and --package cannot be combined")
+	}
+	if o.Grep != "" {
+		re, err := regexp.Compile(o.Grep)
+		if err != nil {
+			return fmt.Errorf("Invalid --grep expression: %v", err)
+		}
+		l.grep = re
+	}
+	if o.Save && o.Dump {
+		return fmt.Errorf("--save follows the log; it cannot be combined with --dump")
+	}
+	if o.Quiet && !o.Save {
+		return fmt.Errorf("--quiet only makes sense with --save")
+	}
+	return nil
+}
+
+// command is the device-side logcat invocation. Output is always threadtime, with the year
+// and in UTC, so lines can be parsed and ordered; tag/priority filters run on the device,
+// the rest here. A non-zero since resumes at that time.
+func (l *LogcatManager) command(since time.Time) string {
+	args := []string{"logcat", "-v", "threadtime", "-v", "year", "-v", "UTC"}
+	if l.opts.Buffers != "" {
+		args = append(args, "-b", l.opts.Buffers)
+	}
+	if l.opts.Dump {
+		args = append(args, "-d")
+	}
+	if !since.IsZero() {
+		// Seconds since the epoch: unambiguous whatever the device's time zone and year.
+		args = append(args, "-T", fmt.Sprintf("%d.%03d", since.Unix(), since.Nanosecond()/int(time.Millisecond)))
+	}
+	for _, f := range l.opts.Filters {
+		args = append(args, adb.Quote(f))
+	}
+	if l.opts.Priority != "" {
+		args = append(args, adb.Quote("*:"+l.opts.Priority))
+	}
+	return strings.Join(args, " ")
+}
+
+// Run streams logcat until interrupted (or, with Dump, until the buffer is printed).
+func (l *LogcatManager) Run() error {
+	if err := container.CheckRoot(); err != nil {
+		return err
+	}
+	if err := l.validate(); err != nil {
+		return err
+	}
+
+	var out []io.Writer
+	if !l.opts.Quiet {
+		out = append(out, os.Stdout)
+	}
+	if l.opts.Save {
+		f, err := logfile.Open(LogcatPath(l.containerName), l.opts.MaxSize, l.opts.Keep)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = append(out, f)
+		fmt.Fprintf(os.Stderr, "Saving logcat of '%s' to %s (Ctrl+C to stop)\n", l.containerName, LogcatPath(l.containerName))
+	}
+	w := io.MultiWriter(out...)
+
+	var cursor logcatCursor
+	for {
+		err := l.stream(w, &cursor)
+		if !l.opts.Save {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "logcat: %v; reconnecting in %s\n", err, logcatReconnectDelay)
+		}
+		time.Sleep(logcatReconnectDelay)
+	}
+}
+
+// logcatCursor is where a reconnecting logcat resumes: the newest time read and how many
+// times each entry with that time was read. `logcat -T` starts at that millisecond again,
+// which can hold lines that were never read, so entries of the boundary millisecond are
+// dropped one for one instead of all of them.
+type logcatCursor struct {
+	time time.Time
+	seen map[LogcatEntry]int
+}
+
+// stream runs logcat once over ADB, resuming at cursor (which it advances) so a reconnect
+// neither replays nor loses lines.
+func (l *LogcatManager) stream(w io.Writer, cursor *logcatCursor) error {
+	conn, err := NewAdbManager(l.containerName).Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	if err := l.watchPids(conn, stop); err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	var stderr strings.Builder
+	done := make(chan error, 1)
+	command := l.command(cursor.time)
+	go func() {
+		status, err := conn.Exec(command, pw, &stderr)
+		if err == nil && status != 0 {
+			err = fmt.Errorf("logcat exited with status %d: %s", status, strings.TrimSpace(stderr.String()))
+		}
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	resume := cursor.time
+	replay := make(map[LogcatEntry]int, len(cursor.seen))
+	for e, n := range cursor.seen {
+		replay[e] = n
+	}
+	scanner := bufio.NewScanner(pr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		entry, ok := parseThreadtime(line)
+		if t, err := time.Parse(logcatTimeLayout, entry.Time); ok && err == nil {
+			if t.Before(resume) {
+				continue
+			}
+			if t.Equal(resume) && replay[entry] > 0 {
+				replay[entry]--
+				continue
+			}
+			if !t.Equal(cursor.time) {
+				cursor.time, cursor.seen = t, make(map[LogcatEntry]int)
+			}
+			cursor.seen[entry]++
+		}
+		if !l.match(entry, ok, line) {
+			continue
+		}
+		if text, show := l.format(entry, ok, line); show {
+			if _, err := io.WriteString(w, text+"\n"); err != nil {
+				pr.CloseWithError(err)
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return <-done
+}
+
+// watchPids resolves --pid/--package into the set of processes to show. A package is
+// looked up again every few seconds, so its log survives app restarts and extra processes.
+func (l *LogcatManager) watchPids(conn *adb.Conn, stop chan struct{}) error {
+	if l.opts.Pid != 0 {
+		l.pids = map[int]time.Time{l.opts.Pid: {}}
+		return nil
+	}
+	if l.opts.Package == "" {
+		return nil
+	}
+	resolve := func() {
+		// The app's processes are named after the package, extra ones "<package>:<name>".
+		out, _ := conn.Shell("ps -A -o PID,NAME")
+		now := time.Now()
+		pids := make(map[int]time.Time)
+		for _, line := range strings.Split(out, "\n") {
+			f := strings.Fields(line)
+			if len(f) != 2 || (f[1] != l.opts.Package && !strings.HasPrefix(f[1], l.opts.Package+":")) {
+				continue
+			}
+			if pid, err := strconv.Atoi(f[0]); err == nil {
+				pids[pid] = now
+			}
+		}
+		l.mu.Lock()
+		for pid, seen := range l.pids {
+			// Exited processes stay for a grace period, then their PID may be reused.
+			if _, running := pids[pid]; !running && now.Sub(seen) < logcatPidGrace {
+				pids[pid] = seen
+			}
+		}
+		l.pids = pids
+		l.mu.Unlock()
+	}
+	resolve()
+	if len(l.pids) == 0 && l.opts.Dump {
+		return fmt.Errorf("Package '%s' is not running on '%s'", l.opts.Package, l.containerName)
+	}
+	go func() {
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				resolve()
+			}
+		}
+	}()
+	return nil
+}
+
+func (l *LogcatManager) match(e LogcatEntry, parsed bool, line string) bool {
+	if l.opts.Pid != 0 || l.opts.Package != "" {
+		if !parsed {
+			return false
+		}
+		l.mu.Lock()
+		_, ok := l.pids[e.Pid]
+		l.mu.Unlock()
+		if !ok {
+			return false
+		}
+	}
+	if l.grep != nil {
+		if parsed {
+			return l.grep.MatchString(e.Tag) || l.grep.MatchString(e.Message)
+		}
+		return l.grep.MatchString(line)
+	}
+	return true
+}
+
+// format renders a line in the selected format. Unparsed lines (such as "--------- beginning
+// of main") pass through as text and are left out of JSON.
+func (l *LogcatManager) format(e LogcatEntry, parsed bool, line string) (string, bool) {
+	switch l.opts.Format {
+	case LogcatFormatJSON:
+		if !parsed {
+			return "", false
+		}
+		data, _ := json.Marshal(e)
+		return string(data), true
+	case LogcatFormatBrief:
+		if !parsed {
+			return line, true
+		}
+		return fmt.Sprintf("%s/%-8s(%5d): %s", e.Priority, e.Tag, e.Pid, e.Message), true
+	default:
+		return line, true
+	}
+}
+
+func parseThreadtime(line string) (LogcatEntry, bool) {
+	m := threadtimePattern.FindStringSubmatch(line)
+	if m == nil {
+		return LogcatEntry{}, false
+	}
+	pid, _ := strconv.Atoi(m[2])
+	tid, _ := strconv.Atoi(m[3])
+	return LogcatEntry{Time: m[1], Pid: pid, Tid: tid, Priority: m[4], Tag: m[5], Message: m[6]}, true
+}
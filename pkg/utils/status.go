@@ -7,6 +7,7 @@ import (
 	"reddock/pkg/config"
 	"reddock/pkg/container"
 	"reddock/pkg/sysinfo"
+	"reddock/pkg/ui"
 )
 
 type StatusManager struct {
@@ -39,8 +40,62 @@ func (s *StatusManager) Show() error {
 
 	fmt.Printf("\nContainer: %s\n", cont.Name)
 	fmt.Printf("Image: %s\n", cont.ImageURL)
+	if cont.ImageDigest != "" {
+		fmt.Printf("Image Digest: %s\n", cont.ImageDigest)
+	}
+	if pinned := cont.PinnedImage(); pinned != cont.ImageURL {
+		fmt.Printf("Runs: %s\n", pinned)
+	}
+	if cont.ImageArch != "" {
+		fmt.Printf("Image Arch: %s\n", cont.ImageArch)
+	}
+	if cont.AndroidVersion != "" {
+		fmt.Printf("Android Version: %s\n", cont.AndroidVersion)
+	}
+	if prev := cont.PreviousImage; prev != nil {
+		fmt.Printf("Previous Image: %s (replaced %s)\n", prev.ImageURL, prev.ReplacedAt.Local().Format("2006-01-02 15:04"))
+		if prev.Snapshot != "" {
+			fmt.Printf("Upgrade Snapshot: %s\n", prev.Snapshot)
+		}
+	}
 	fmt.Printf("Data Path: %s\n", cont.GetDataPath())
+	// Only image storage is cheap to measure (statfs); `reddock storage usage` walks the others.
+	switch cont.StorageBackend() {
+	case config.StorageImage:
+		if u, err := container.GetStorageUsage(cont); err != nil {
+			fmt.Printf("Storage: image %s (usage unavailable: %v)\n", cont.StorageImagePath(), err)
+		} else {
+			fmt.Printf("Storage: image %s, %s of %s used\n", u.Location, ui.FormatBytes(u.Used), ui.FormatBytes(u.Capacity))
+		}
+	case config.StorageVolume:
+		fmt.Printf("Storage: Docker volume %s\n", cont.Volume)
+	}
+	if n := len(cont.Snapshots); n > 0 {
+		fmt.Printf("Snapshots: %d (latest %s)\n", n, cont.Snapshots[n-1].ID)
+	}
 	fmt.Printf("GPU Mode: %s\n", cont.GPUMode)
+	if gpu, err := container.ResolveGPU(cont); err != nil {
+		fmt.Printf("GPU Node: (unavailable) %v\n", err)
+	} else if gpu.Node != nil {
+		fmt.Printf("GPU Node: %s\n", gpu.Node)
+	} else {
+		fmt.Printf("GPU Node: none (software rendering)\n")
+	}
+	profile := cont.SecurityProfile
+	if profile == "" {
+		profile = config.SecurityProfilePrivileged
+	}
+	fmt.Printf("Security Profile: %s\n", profile)
+	binderMode := cont.BinderMode
+	if binderMode == "" {
+		binderMode = config.BinderModeShared
+	}
+	fmt.Printf("Binder Mode: %s\n", binderMode)
+	devices := container.FormatBinderDevices(container.BinderDevices(cont))
+	if binderMode == config.BinderModeShared {
+		devices += " (shared with every instance)"
+	}
+	fmt.Printf("Binder Devices: %s\n", devices)
 	fmt.Printf("Initiated: %v\n", cont.Initialized)
 
 	b := sysinfo.ProbeBinderHost()
@@ -0,0 +1,506 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"reddock/pkg/adb"
+	"reddock/pkg/container"
+	"reddock/pkg/ui"
+)
+
+// sharedStorageRoots are the device paths of the emulated SD card and where the files
+// live in /data. The SD card daemon/FUSE layer manages their ownership.
+var sharedStorageRoots = []string{"/sdcard", "/storage/emulated/0", "/storage/self/primary", "/data/media/0"}
+
+// FileTransfer copies files between the host and an instance, through ADB while it runs
+// or directly in its data directory while it is stopped (offline).
+type FileTransfer struct {
+	manager       *container.Manager
+	containerName string
+	offline       bool
+}
+
+func NewFileTransfer(containerName string, offline bool) *FileTransfer {
+	return &FileTransfer{
+		manager:       container.NewManagerForContainer(containerName),
+		containerName: containerName,
+		offline:       offline,
+	}
+}
+
+// localFile is one regular file of a local source tree.
+type localFile struct {
+	path string
+	rel  string
+	info fs.FileInfo
+}
+
+// Push copies src (a file or directory, recursively) to dst on the device. As with adb,
+// an existing directory dst receives src under its own name.
+func (t *FileTransfer) Push(src, dst string) error {
+	if err := container.CheckRoot(); err != nil {
+		return err
+	}
+	files, dirs, total, err := walkLocal(src)
+	if err != nil {
+		return err
+	}
+	if t.offline {
+		return t.pushOffline(src, dst, files, dirs, total)
+	}
+
+	conn, err := NewAdbManager(t.containerName).Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	y, err := conn.OpenSync()
+	if err != nil {
+		return err
+	}
+	defer y.Close()
+
+	if st, err := y.Stat(dst); strings.HasSuffix(dst, "/") || (err == nil && st.IsDir()) {
+		dst = path.Join(dst, filepath.Base(filepath.Clean(src)))
+	}
+	for _, d := range dirs {
+		if _, err := conn.Shell("mkdir -p " + adb.Quote(path.Join(dst, d))); err != nil {
+			return err
+		}
+	}
+
+	bar := ui.NewProgressBar(int(total), fmt.Sprintf("Pushing %s to %s:%s", src, t.containerName, dst))
+	bar.Start()
+	var sent int64
+	for _, f := range files {
+		bar.SetMessage(fmt.Sprintf("Pushing %s", f.path))
+		r, err := os.Open(f.path)
+		if err != nil {
+			return err
+		}
+		err = y.Push(r, path.Join(dst, f.rel), f.info.Mode().Perm(), f.info.ModTime(), func(n int) {
+			sent += int64(n)
+			bar.Update(int(sent))
+		})
+		r.Close()
+		if err != nil {
+			bar.Finish("Push failed")
+			return fmt.Errorf("Failed to push %s: %v", f.path, err)
+		}
+	}
+	bar.Finish(fmt.Sprintf("Pushed %d file(s), %s", len(files), ui.FormatBytes(total)))
+	return t.fixOwnership(dst)
+}
+
+// fixOwnership gives pushed files the owner of their parent directory (e.g. an app's uid
+// below /data/data) and their default SELinux label. It runs as root through docker exec,
+// since adbd usually runs as the shell user. Shared storage manages its own ownership.
+func (t *FileTransfer) fixOwnership(dst string) error {
+	if isSharedStorage(dst) {
+		return nil
+	}
+	script := fmt.Sprintf(`chown -R "$(stat -c %%u:%%g %s)" %s && (restorecon -R %s 2>/dev/null || true)`,
+		adb.Quote(path.Dir(dst)), adb.Quote(dst), adb.Quote(dst))
+	out, err := container.NewRuntime().Command("exec", t.containerName, "sh", "-c", script).CombinedOutput()
+	if err != nil {
+		fmt.Printf("Warning: could not set the owner of %s: %v: %s\n", dst, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Pull copies src (a file or directory, recursively) from the device to dst on the host.
+// Under sudo the copies are owned by the invoking user.
+func (t *FileTransfer) Pull(src, dst string) error {
+	if err := container.CheckRoot(); err != nil {
+		return err
+	}
+	if st, err := os.Stat(dst); err == nil && st.IsDir() {
+		dst = filepath.Join(dst, path.Base(path.Clean(src)))
+	}
+	if t.offline {
+		return t.pullOffline(src, dst)
+	}
+
+	conn, err := NewAdbManager(t.containerName).Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	y, err := conn.OpenSync()
+	if err != nil {
+		return err
+	}
+	defer y.Close()
+
+	st, err := y.Stat(src)
+	if err != nil {
+		return err
+	}
+	if st.Mode == 0 {
+		return fmt.Errorf("%s does not exist on '%s'", src, t.containerName)
+	}
+	type remoteFile struct {
+		path, rel string
+		info      adb.FileInfo
+	}
+	var files []remoteFile
+	var dirs []string
+	var total int64
+	if st.IsDir() {
+		var walk func(dir, rel string) error
+		walk = func(dir, rel string) error {
+			dirs = append(dirs, rel)
+			entries, err := y.List(dir)
+			if err != nil {
+				return fmt.Errorf("Failed to list %s: %v", dir, err)
+			}
+			for _, e := range entries {
+				p, r := path.Join(dir, e.Name), path.Join(rel, e.Name)
+				switch {
+				case e.IsDir():
+					if err := walk(p, r); err != nil {
+						return err
+					}
+				case e.Mode.IsRegular():
+					files = append(files, remoteFile{p, r, e})
+					total += e.Size
+				}
+			}
+			return nil
+		}
+		if err := walk(src, "."); err != nil {
+			return err
+		}
+	} else {
+		files = append(files, remoteFile{src, ".", st})
+		total = st.Size
+	}
+
+	for _, d := range dirs {
+		if err := os.MkdirAll(filepath.Join(dst, d), 0755); err != nil {
+			return err
+		}
+		chownToInvokingUser(filepath.Join(dst, d))
+	}
+	bar := ui.NewProgressBar(int(total), fmt.Sprintf("Pulling %s:%s to %s", t.containerName, src, dst))
+	bar.Start()
+	var received int64
+	for _, f := range files {
+		bar.SetMessage(fmt.Sprintf("Pulling %s", f.path))
+		local := filepath.Join(dst, f.rel)
+		w, err := os.OpenFile(local, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, f.info.Mode.Perm()|0200)
+		if err != nil {
+			return err
+		}
+		err = y.Pull(f.path, w, func(n int) {
+			received += int64(n)
+			bar.Update(int(received))
+		})
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			bar.Finish("Pull failed")
+			return fmt.Errorf("Failed to pull %s: %v", f.path, err)
+		}
+		os.Chtimes(local, f.info.ModTime, f.info.ModTime)
+		chownToInvokingUser(local)
+	}
+	bar.Finish(fmt.Sprintf("Pulled %d file(s), %s", len(files), ui.FormatBytes(received)))
+	return nil
+}
+
+// hostPath maps a device path below /data (or shared storage, which lives in
+// /data/media/0) into the instance's data directory. It returns the data directory and
+// the path below it with every symlink resolved (see resolveDataPath).
+func (t *FileTransfer) hostPath(devicePath string) (string, string, error) {
+	if t.manager.IsRunning() {
+		return "", "", fmt.Errorf("Container '%s' is running; stop it before an offline transfer, or drop --offline to use ADB", t.containerName)
+	}
+	c := t.manager.GetContainer()
+	if c == nil {
+		return "", "", fmt.Errorf("Container '%s' not found", t.containerName)
+	}
+	if err := container.MountStorage(c); err != nil {
+		return "", "", err
+	}
+	p := path.Clean("/" + devicePath)
+	for _, root := range sharedStorageRoots {
+		if p == root || strings.HasPrefix(p, root+"/") {
+			p = "/data/media/0" + strings.TrimPrefix(p, root)
+			break
+		}
+	}
+	if p != "/data" && !strings.HasPrefix(p, "/data/") {
+		return "", "", fmt.Errorf("Offline transfers only reach /data and /sdcard, not %s", devicePath)
+	}
+	root := c.GetDataPath()
+	rel, err := resolveDataPath(root, strings.TrimPrefix(p, "/data"))
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %v", devicePath, err)
+	}
+	return root, rel, nil
+}
+
+// maxSymlinks bounds symlink resolution, like the kernel's ELOOP limit.
+const maxSymlinks = 40
+
+// resolveDataPath resolves rel, a path below the guest's /data, inside the host data
+// directory root the way the guest would see it: symlinks are followed one component at a
+// time, absolute targets are guest paths (so /data/... stays inside root), and a link or
+// ".." that leads outside /data is refused instead of reaching the host. Components that
+// do not exist yet are kept as they are. The result is relative to root.
+func resolveDataPath(root, rel string) (string, error) {
+	resolved := ""
+	pending := splitPath(rel)
+	links := 0
+	missing := false
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if name == ".." {
+			if resolved == "" {
+				return "", fmt.Errorf("path leads outside /data")
+			}
+			resolved = strings.TrimSuffix(path.Dir(resolved), ".")
+			continue
+		}
+		next := path.Join(resolved, name)
+		if missing {
+			resolved = next
+			continue
+		}
+		st, err := os.Lstat(filepath.Join(root, next))
+		if os.IsNotExist(err) {
+			missing = true
+			resolved = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if st.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links")
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			t := path.Clean(target)
+			if t != "/data" && !strings.HasPrefix(t, "/data/") {
+				return "", fmt.Errorf("/data/%s links to %s, outside /data", next, target)
+			}
+			resolved = ""
+			target = strings.TrimPrefix(t, "/data")
+		}
+		pending = append(splitPath(target), pending...)
+	}
+	return resolved, nil
+}
+
+func splitPath(p string) []string {
+	var parts []string
+	for _, part := range strings.Split(p, "/") {
+		if part != "" && part != "." {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// pushOffline copies into the data directory and gives every new file and directory the
+// owner and SELinux label of the directory it is created in, as Android would.
+func (t *FileTransfer) pushOffline(src, dst string, files []localFile, dirs []string, total int64) error {
+	root, rel, err := t.hostPath(dst)
+	if err != nil {
+		return err
+	}
+	if st, err := os.Stat(filepath.Join(root, rel)); strings.HasSuffix(dst, "/") || (err == nil && st.IsDir()) {
+		if rel, err = resolveDataPath(root, path.Join(rel, filepath.Base(filepath.Clean(src)))); err != nil {
+			return fmt.Errorf("%s: %v", dst, err)
+		}
+	}
+	parent := filepath.Dir(filepath.Join(root, rel))
+	if _, err := os.Stat(parent); err != nil {
+		return fmt.Errorf("%s does not exist on '%s'", path.Dir(dst), t.containerName)
+	}
+	// Every target is resolved again: the tree being written into may contain symlinks.
+	target := func(name string) (string, error) {
+		r, err := resolveDataPath(root, path.Join(rel, name))
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", path.Join(dst, name), err)
+		}
+		return filepath.Join(root, r), nil
+	}
+
+	for _, d := range dirs {
+		dir, err := target(d)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0771); err != nil {
+			return err
+		}
+		inheritOwnership(dir, parent)
+	}
+	bar := ui.NewProgressBar(int(total), fmt.Sprintf("Copying %s into %s (offline)", src, dst))
+	bar.Start()
+	var copied int64
+	for _, f := range files {
+		file, err := target(f.rel)
+		if err != nil {
+			bar.Finish("Copy failed")
+			return err
+		}
+		n, err := copyFile(f.path, file, f.info.Mode().Perm())
+		copied += n
+		bar.Update(int(copied))
+		if err != nil {
+			bar.Finish("Copy failed")
+			return err
+		}
+		os.Chtimes(file, f.info.ModTime(), f.info.ModTime())
+		inheritOwnership(file, parent)
+	}
+	bar.Finish(fmt.Sprintf("Copied %d file(s), %s", len(files), ui.FormatBytes(copied)))
+	return nil
+}
+
+// pullOffline copies out of the data directory. Only the source path itself is resolved;
+// the walk below it does not follow symlinks.
+func (t *FileTransfer) pullOffline(src, dst string) error {
+	root, rel, err := t.hostPath(src)
+	if err != nil {
+		return err
+	}
+	files, dirs, total, err := walkLocal(filepath.Join(root, rel))
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if err := os.MkdirAll(filepath.Join(dst, d), 0755); err != nil {
+			return err
+		}
+		chownToInvokingUser(filepath.Join(dst, d))
+	}
+	bar := ui.NewProgressBar(int(total), fmt.Sprintf("Copying %s out of '%s' (offline)", src, t.containerName))
+	bar.Start()
+	var copied int64
+	for _, f := range files {
+		target := filepath.Join(dst, f.rel)
+		n, err := copyFile(f.path, target, f.info.Mode().Perm()|0200)
+		copied += n
+		bar.Update(int(copied))
+		if err != nil {
+			bar.Finish("Copy failed")
+			return err
+		}
+		os.Chtimes(target, f.info.ModTime(), f.info.ModTime())
+		chownToInvokingUser(target)
+	}
+	bar.Finish(fmt.Sprintf("Copied %d file(s), %s", len(files), ui.FormatBytes(copied)))
+	return nil
+}
+
+// walkLocal lists the regular files and directories (relative to src, "." for src itself)
+// of a file or directory tree, and the total file size.
+func walkLocal(src string) ([]localFile, []string, int64, error) {
+	st, err := os.Stat(src)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if !st.IsDir() {
+		return []localFile{{path: src, rel: ".", info: st}}, nil, st.Size(), nil
+	}
+	var files []localFile
+	var dirs []string
+	var total int64
+	err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, p)
+		if d.IsDir() {
+			dirs = append(dirs, filepath.ToSlash(rel))
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, localFile{path: p, rel: filepath.ToSlash(rel), info: info})
+		total += info.Size()
+		return nil
+	})
+	return files, dirs, total, err
+}
+
+// copyFile copies src to dst without following a symlink at either end, so a link
+// swapped in for a walked file cannot redirect the copy.
+func copyFile(src, dst string, perm os.FileMode) (int64, error) {
+	r, err := os.OpenFile(src, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|syscall.O_NOFOLLOW, perm)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(w, r)
+	if err == nil {
+		err = w.Chmod(perm)
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// inheritOwnership gives path the uid, gid and SELinux label of dir.
+func inheritOwnership(path, dir string) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(dir, &st); err != nil {
+		return
+	}
+	os.Lchown(path, int(st.Uid), int(st.Gid))
+	label := make([]byte, 256)
+	if n, err := syscall.Getxattr(dir, "security.selinux", label); err == nil && n > 0 {
+		syscall.Setxattr(path, "security.selinux", label[:n], 0)
+	}
+}
+
+// chownToInvokingUser hands files created as root under sudo back to the user.
+func chownToInvokingUser(path string) {
+	uid, err1 := strconv.Atoi(os.Getenv("SUDO_UID"))
+	gid, err2 := strconv.Atoi(os.Getenv("SUDO_GID"))
+	if err1 == nil && err2 == nil && os.Geteuid() == 0 {
+		os.Lchown(path, uid, gid)
+	}
+}
+
+func isSharedStorage(devicePath string) bool {
+	p := path.Clean(devicePath)
+	for _, root := range append(sharedStorageRoots, "/storage") {
+		if p == root || strings.HasPrefix(p, root+"/") {
+			return true
+		}
+	}
+	return false
+}